@@ -0,0 +1,12 @@
+bits 16
+
+; The direct address of the accumulator forms is always a word, even for the byte (al) operations,
+; and the size of the memory operand is implied by the register
+mov al, [4660] ; 10100000 00110100 00010010
+mov ax, [4660] ; 10100001 00110100 00010010
+mov [4660], al ; 10100010 00110100 00010010
+mov [4660], ax ; 10100011 00110100 00010010
+mov al, es:[4660] ; 00100110 10100000 00110100 00010010
+mov ax, es:[4660] ; 00100110 10100001 00110100 00010010
+mov cs:[4660], al ; 00101110 10100010 00110100 00010010
+mov ss:[4660], ax ; 00110110 10100011 00110100 00010010
@@ -0,0 +1,36 @@
+bits 16
+
+; Every mod and r/m combination of the effective address: no displacement (mod = 00, r/m = 110 is the direct address),
+; 8-bit (mod = 01) and 16-bit (mod = 10) displacements, and the register (mod = 11)
+mov cx, [bx + si] ; 10001011 00001000
+mov cx, [bx + di] ; 10001011 00001001
+mov cx, [bp + si] ; 10001011 00001010
+mov cx, [bp + di] ; 10001011 00001011
+mov cx, [si] ; 10001011 00001100
+mov cx, [di] ; 10001011 00001101
+mov cx, [4660] ; 10001011 00001110 00110100 00010010
+mov cx, [bx] ; 10001011 00001111
+mov cx, [bx + si + 4] ; 10001011 01001000 00000100
+mov cx, [bx + di - 3] ; 10001011 01001001 11111101
+mov cx, [bp + si + 4] ; 10001011 01001010 00000100
+mov cx, [bp + di - 3] ; 10001011 01001011 11111101
+mov cx, [si + 4] ; 10001011 01001100 00000100
+mov cx, [di - 3] ; 10001011 01001101 11111101
+mov cx, [bp + 4] ; 10001011 01001110 00000100
+mov cx, [bx - 3] ; 10001011 01001111 11111101
+mov cx, [bx + si + 1000] ; 10001011 10001000 11101000 00000011
+mov cx, [bx + di - 1000] ; 10001011 10001001 00011000 11111100
+mov cx, [bp + si + 1000] ; 10001011 10001010 11101000 00000011
+mov cx, [bp + di - 1000] ; 10001011 10001011 00011000 11111100
+mov cx, [si + 1000] ; 10001011 10001100 11101000 00000011
+mov cx, [di - 1000] ; 10001011 10001101 00011000 11111100
+mov cx, [bp + 1000] ; 10001011 10001110 11101000 00000011
+mov cx, [bx - 1000] ; 10001011 10001111 00011000 11111100
+mov ax, cx ; 10001001 11001000
+mov cx, cx ; 10001001 11001001
+mov dx, cx ; 10001001 11001010
+mov bx, cx ; 10001001 11001011
+mov sp, cx ; 10001001 11001100
+mov bp, cx ; 10001001 11001101
+mov si, cx ; 10001001 11001110
+mov di, cx ; 10001001 11001111
@@ -0,0 +1,12 @@
+bits 16
+
+; The fixed port is an immediate byte, the variable port is in dx.
+; The accumulator is the destination of in and the source of out
+in al, 60 ; 11100100 00111100
+in ax, dx ; 11101101
+out 20, al ; 11100110 00010100
+out dx, ax ; 11101111
+in ax, 60 ; 11100101 00111100
+in al, dx ; 11101100
+out 20, ax ; 11100111 00010100
+out dx, al ; 11101110
@@ -0,0 +1,44 @@
+bits 16
+
+; The size keyword of the memory operand goes before the address: inc word [bx].
+; The word registers have the short form, the byte ones are encoded like the memory
+inc byte [16] ; 11111110 00000110 00010000 00000000
+inc byte [bx + si] ; 11111110 00000000
+inc byte [bx + di - 8] ; 11111110 01000001 11111000
+inc byte [bp + si + 300] ; 11111110 10000010 00101100 00000001
+inc byte [bp + di] ; 11111110 00000011
+inc byte [si + 8] ; 11111110 01000100 00001000
+inc byte [di - 300] ; 11111110 10000101 11010100 11111110
+inc byte [bp + 8] ; 11111110 01000110 00001000
+inc byte [bx - 8] ; 11111110 01000111 11111000
+inc word [16] ; 11111111 00000110 00010000 00000000
+inc word [bx + si] ; 11111111 00000000
+inc word [bx + di - 8] ; 11111111 01000001 11111000
+inc word [bp + si + 300] ; 11111111 10000010 00101100 00000001
+inc word [bp + di] ; 11111111 00000011
+inc word [si + 8] ; 11111111 01000100 00001000
+inc word [di - 300] ; 11111111 10000101 11010100 11111110
+inc word [bp + 300] ; 11111111 10000110 00101100 00000001
+inc word [bx - 8] ; 11111111 01000111 11111000
+dec byte [16] ; 11111110 00001110 00010000 00000000
+dec byte [bx + si] ; 11111110 00001000
+dec byte [bx + di - 8] ; 11111110 01001001 11111000
+dec byte [bp + si + 300] ; 11111110 10001010 00101100 00000001
+dec byte [bp + di] ; 11111110 00001011
+dec byte [si + 8] ; 11111110 01001100 00001000
+dec byte [di - 300] ; 11111110 10001101 11010100 11111110
+dec byte [bp + 300] ; 11111110 10001110 00101100 00000001
+dec byte [bx - 8] ; 11111110 01001111 11111000
+dec word [16] ; 11111111 00001110 00010000 00000000
+dec word [bx + si] ; 11111111 00001000
+dec word [bx + di - 8] ; 11111111 01001001 11111000
+dec word [bp + si + 300] ; 11111111 10001010 00101100 00000001
+dec word [bp + di] ; 11111111 00001011
+dec word [si + 8] ; 11111111 01001100 00001000
+dec word [di - 300] ; 11111111 10001101 11010100 11111110
+dec word [bp + 8] ; 11111111 01001110 00001000
+dec word [bx - 8] ; 11111111 01001111 11111000
+inc al ; 11111110 11000000
+dec bh ; 11111110 11001111
+inc cx ; 01000001
+dec sp ; 01001100
@@ -0,0 +1,14 @@
+bits 16
+
+; The near memory forms read a word (the new IP), the far ones a doubleword (IP and CS),
+; so both carry a size keyword to keep the reassembly unambiguous
+call ax ; 11111111 11010000
+call word [bp - 100] ; 11111111 01010110 10011100
+call word [39201] ; 11111111 00010110 00100001 10011001
+call far [bp + si - 58] ; 11111111 01011010 11000110
+call far [bx] ; 11111111 00011111
+jmp di ; 11111111 11100111
+jmp word [di] ; 11111111 00100101
+jmp word [bx + 4395] ; 11111111 10100111 00101011 00010001
+jmp far [di] ; 11111111 00101101
+jmp far [bp + 4] ; 11111111 01101110 00000100
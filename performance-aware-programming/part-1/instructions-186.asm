@@ -0,0 +1,13 @@
+bits 16
+cpu 186
+
+; The 80186 instructions, decoded with Allow186
+pusha ; 01100000
+popa ; 01100001
+bound ax, [bx] ; 01100010 00000111
+bound si, [bp + 4] ; 01100010 01110110 00000100
+imul ax, bx, 1000 ; 01101001 11000011 11101000 00000011
+imul cx, [bx + si], -2 ; 01101011 00001000 11111110
+imul dx, di, 5 ; 01101011 11010111 00000101
+enter 16, 1 ; 11001000 00010000 00000000 00000001
+leave ; 11001001
@@ -0,0 +1,6 @@
+bits 16
+
+; The atomic exchange keeps the memory operand first
+lock xchg [bx], ax ; 11110000 10000111 00000111
+lock xchg [bx + 4], cl ; 11110000 10000110 01001111 00000100
+lock xchg [bp + 4660], dx ; 11110000 10000111 10010110 00110100 00010010
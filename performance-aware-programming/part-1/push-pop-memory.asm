@@ -0,0 +1,9 @@
+bits 16
+
+; The register forms have their own short encoding,
+; so the size keyword is only ever applied to the memory operands
+push word [0x1234] ; 11111111 00110110 00110100 00010010
+pop word [0x1234] ; 10001111 00000110 00110100 00010010
+push word [bx] ; 11111111 00110111
+pop word [bp + si + 4] ; 10001111 01000010 00000100
+push word [bp] ; 11111111 01110110 00000000
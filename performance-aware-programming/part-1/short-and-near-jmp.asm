@@ -0,0 +1,10 @@
+bits 16
+
+; Both jumps target the same label.
+; The near jump fits into the short range, so NASM would shrink it without the keyword
+jmp short target ; 11101011 00000011
+jmp near target ; 11101001 00000000 00000000
+target:
+mov ax, bx
+jmp short target ; 11101011 11111100
+jmp near target ; 11101001 11111001 11111111
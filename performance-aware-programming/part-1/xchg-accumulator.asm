@@ -0,0 +1,12 @@
+bits 16
+
+; The one-byte form of xchg with the accumulator: [10010|reg]. 0x90 is xchg ax, ax,
+; the other seven registers must not be mistaken for it
+xchg ax, ax ; 10010000
+xchg ax, cx ; 10010001
+xchg ax, dx ; 10010010
+xchg ax, bx ; 10010011
+xchg ax, sp ; 10010100
+xchg ax, bp ; 10010101
+xchg ax, si ; 10010110
+xchg ax, di ; 10010111
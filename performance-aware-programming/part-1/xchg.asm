@@ -0,0 +1,14 @@
+bits 16
+
+; Both orders of the register and the memory operand are assembled to the same bytes,
+; the register goes first. Of the two registers, the first one is the r/m operand
+xchg cx, dx ; 10000111 11010001
+xchg si, cx ; 10000111 11001110
+xchg cl, ah ; 10000110 11100001
+xchg ax, cx ; 10010001
+xchg ax, [bx] ; 10000111 00000111
+xchg al, [bx + si] ; 10000110 00000000
+xchg dx, [bp - 4] ; 10000111 01010110 11111100
+xchg bh, [4660] ; 10000110 00111110 00110100 00010010
+xchg di, [bx + di + 1000] ; 10000111 10111001 11101000 00000011
+lock xchg [bx], ax ; 11110000 10000111 00000111
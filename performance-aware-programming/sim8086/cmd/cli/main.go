@@ -2,18 +2,35 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/doichev-kostia/computer-enhance/sim8086/pkg/decoder"
 	"os"
+	"sort"
 )
 
 func main() {
-	// 1 - program name, 2 - filename
-	if len(os.Args) < 2 {
+	stats := flag.Bool("stats", false, "print how often each mnemonic appears instead of the disassembly")
+	base := flag.Int("base", 10, "the base of the label names: 10 (label__26) or 16 (label_0x1a)")
+	flag.Parse()
+
+	if *base != 10 && *base != 16 {
+		exit(fmt.Errorf("invalid base %d, expected 10 or 16\n", *base))
+	}
+
+	if flag.NArg() < 1 {
 		exit(fmt.Errorf("invalid number of arguments, expected at least one for the filename\n"))
 	}
 
-	filename := os.Args[1]
+	command := ""
+	filename := flag.Arg(0)
+	if filename == "verify" {
+		if flag.NArg() < 2 {
+			exit(fmt.Errorf("invalid number of arguments, expected the filename to verify\n"))
+		}
+		command, filename = "verify", flag.Arg(1)
+	}
+
 	if !fileExists(filename) {
 		exit(fmt.Errorf("The specified file %s doesn't exist\n", filename))
 	}
@@ -23,37 +40,75 @@ func main() {
 		exit(fmt.Errorf("Failed to read the file %s. Error = %w\n", filename, err))
 	}
 
-	d := decoder.NewDecoder(bytes)
-	var contents []byte
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				if len(d.GetDecoded()) > 0 {
-					fmt.Printf("(%s) Partial decoded contents:\n%s", filename, d.GetDecoded())
-				}
-				panic(fmt.Errorf("panic occurred when processing %s; error = %v", filename, r))
-			}
-		}()
-
-		var err error
-		contents, err = d.Decode()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s = %v", filename, err)
+	if command == "verify" {
+		if err := verify(filename, bytes); err != nil {
+			exit(err)
 		}
-	}()
+		fmt.Printf("%s: the decoded output reassembles to the same %d bytes\n", filename, len(bytes))
+		return
+	}
 
+	d := decoder.NewDecoder(bytes)
+	d.HexLabels = *base == 16
+	contents, err := decode(d, filename)
 	if err != nil {
 		exit(err)
 	}
 
-	asm := printHead(filename) + string(contents)
+	if *stats {
+		fmt.Print(printHistogram(decoder.Histogram(d.Instructions())))
+		return
+	}
+
+	asm := d.Header(filename) + string(contents)
 
 	fmt.Print(asm)
 }
 
-func printHead(filename string) string {
-	return fmt.Sprintf("; %s\nbits 16\n\n", filename)
+// decode prints the partially decoded contents when the decoding fails,
+// the panics are returned as the errors with the offset the decoder stopped at
+func decode(d *decoder.Decoder, filename string) (contents []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			offset := 0
+			if instructions := d.Instructions(); len(instructions) > 0 {
+				last := instructions[len(instructions)-1]
+				offset = last.Offset + last.Size
+			}
+			err = fmt.Errorf("failed to decode %s at offset 0x%x: %v", filename, offset, r)
+		}
+		if err != nil && len(d.GetDecoded()) > 0 {
+			fmt.Printf("(%s) Partial decoded contents:\n%s", filename, d.GetDecoded())
+		}
+	}()
+
+	contents, err = d.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("%s = %w", filename, err)
+	}
+	return contents, nil
+}
+
+// printHistogram lists the mnemonics from the most to the least frequent
+func printHistogram(histogram map[string]int) string {
+	mnemonics := make([]string, 0, len(histogram))
+	for mnemonic := range histogram {
+		mnemonics = append(mnemonics, mnemonic)
+	}
+
+	sort.Slice(mnemonics, func(i, j int) bool {
+		if histogram[mnemonics[i]] != histogram[mnemonics[j]] {
+			return histogram[mnemonics[i]] > histogram[mnemonics[j]]
+		}
+		return mnemonics[i] < mnemonics[j]
+	})
+
+	output := ""
+	for _, mnemonic := range mnemonics {
+		output += fmt.Sprintf("%-8s %d\n", mnemonic, histogram[mnemonic])
+	}
+
+	return output
 }
 
 func exit(err error) {
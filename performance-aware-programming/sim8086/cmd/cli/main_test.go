@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the CLI instead of the tests when the test binary is started by runCLI
+func TestMain(m *testing.M) {
+	if os.Getenv("SIM8086_RUN_CLI") == "1" {
+		os.Args = append([]string{"sim8086"}, strings.Fields(os.Getenv("SIM8086_ARGS"))...)
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runCLI runs the CLI with the arguments in a separate process, returning its output and the exit code
+func runCLI(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "SIM8086_RUN_CLI=1", "SIM8086_ARGS="+strings.Join(args, " "))
+	output, err := cmd.CombinedOutput()
+
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		return string(output), exitError.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("failed to run the CLI = %v", err)
+	}
+	return string(output), 0
+}
+
+func TestVerifyUndecodable(t *testing.T) {
+	filename := path.Join(t.TempDir(), "bad.bin")
+	source := []byte{
+		0b10001001, 0b11011000, // mov ax, bx
+		0b01100011, 0b00000000, // undefined on the 8086
+	}
+	if err := os.WriteFile(filename, source, 0o644); err != nil {
+		t.Fatalf("failed to write the binary = %v", err)
+	}
+
+	output, code := runCLI(t, "verify", filename)
+	if code != 1 {
+		t.Errorf("expected the exit code 1, got %d\n%s", code, output)
+	}
+	if !strings.Contains(output, "offset 0x2") {
+		t.Errorf("expected the failing offset in the output, got %q", output)
+	}
+	if strings.Contains(output, "goroutine") {
+		t.Errorf("expected no stack trace, got %q", output)
+	}
+}
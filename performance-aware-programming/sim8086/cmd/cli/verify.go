@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/doichev-kostia/computer-enhance/sim8086/pkg/decoder"
+)
+
+// verify decodes the binary, assembles the output with nasm and compares the result with the original bytes.
+// The returned error describes the first mismatch
+func verify(filename string, bytes []byte) error {
+	d := decoder.NewDecoder(bytes)
+	contents, err := decode(d, filename)
+	if err != nil {
+		return err
+	}
+
+	nasmPath, err := exec.LookPath("nasm")
+	if err != nil {
+		return fmt.Errorf("nasm is required to verify the decoding, error = %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "sim8086-verify-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	source := dir + "/decoded.asm"
+	output := dir + "/assembled.bin"
+	if err := os.WriteFile(source, []byte(d.Header(filename)+string(contents)), 0o644); err != nil {
+		return err
+	}
+
+	nasm := exec.Command(nasmPath, "-o", output, source)
+	if message, err := nasm.CombinedOutput(); err != nil {
+		return fmt.Errorf("nasm failed to assemble the decoded %s, error = %w\n%s", filename, err, message)
+	}
+
+	assembled, err := os.ReadFile(output)
+	if err != nil {
+		return err
+	}
+
+	for idx := 0; idx < len(bytes) && idx < len(assembled); idx++ {
+		if assembled[idx] != bytes[idx] {
+			return fmt.Errorf("%s: the byte at offset 0x%x doesn't match, expected 0x%02x, got 0x%02x", filename, idx, bytes[idx], assembled[idx])
+		}
+	}
+
+	if len(assembled) != len(bytes) {
+		return fmt.Errorf("%s: the length doesn't match, expected %d bytes, got %d", filename, len(bytes), len(assembled))
+	}
+
+	return nil
+}
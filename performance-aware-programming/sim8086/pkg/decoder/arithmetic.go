@@ -1,9 +1,6 @@
 package decoder
 
-import (
-	"fmt"
-	"strings"
-)
+import "fmt"
 
 // Common pattern
 //
@@ -24,126 +21,126 @@ import (
 // | IDIV | 111     |
 
 // [00110111]
-func aaa(operation byte, d *Decoder) (string, error) {
-	return "aaa\n", nil
+func aaa(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "aaa"}, nil
 }
 
 // [00100111]
-func daa(operation byte, d *Decoder) (string, error) {
-	return "daa\n", nil
+func daa(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "daa"}, nil
 }
 
 // [00111111]
-func aas(operation byte, d *Decoder) (string, error) {
-	return "aas\n", nil
+func aas(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "aas"}, nil
 }
 
 // [00101111]
-func das(operation byte, d *Decoder) (string, error) {
-	return "das\n", nil
+func das(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "das"}, nil
 }
 
 // [11010100] [00001010] [disp-lo?] [disp-hi?]
 // definitions.INSTRUCTION_REFERENCE
-func aam(operation byte, d *Decoder) (string, error) {
+func aam(operation byte, d *Decoder) (Instruction, error) {
 	// Note(Kostia)
 	// I don't know why the INSTRUCTION_REFERENCE says there should be displacement, but there are no fields to figure that out.
 	// Moreover, in the Table 4-13. Machine Instruction Decoding Guide the element 11010100 00001010 has no displacement either
 	next, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'AAM' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'AAM' instruction")
 	}
 	if next != 0b00001010 {
-		return "", fmt.Errorf("expected the operand to be 00001010 for the 'AAM' instruction")
+		return Instruction{}, fmt.Errorf("expected the operand to be 00001010 for the 'AAM' instruction")
 	}
-	return "aam\n", nil
+	return Instruction{Mnemonic: "aam"}, nil
 }
 
 // [11010101] [00001010] [disp-lo?] [disp-hi?]
 // definitions.INSTRUCTION_REFERENCE
-func aad(operation byte, d *Decoder) (string, error) {
+func aad(operation byte, d *Decoder) (Instruction, error) {
 	// Note(Kostia)
 	// I don't know why the "Instruction reference" says there should be displacement, but there are no fields to figure that out.
 	// Moreover, in the Table 4-13. Machine Instruction Decoding Guide the element 11010101 00001010 has no displacement either
 	next, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'AAD' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'AAD' instruction")
 	}
 	if next != 0b00001010 {
-		return "", fmt.Errorf("expected the operand to be 00001010 for the 'AAD' instruction")
+		return Instruction{}, fmt.Errorf("expected the operand to be 00001010 for the 'AAD' instruction")
 	}
-	return "aad\n", nil
+	return Instruction{Mnemonic: "aad"}, nil
 }
 
 // [10011000]
-func cbw(operation byte, d *Decoder) (string, error) {
-	return "cbw\n", nil
+func cbw(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "cbw"}, nil
 }
 
 // [10011001]
-func cwd(operation byte, d *Decoder) (string, error) {
-	return "cwd\n", nil
+func cwd(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "cwd"}, nil
 }
 
 // [000000|d|w] [mod|reg|r/m] [disp-lo?] [disp-hi?]
-func addRegOrMemToReg(operation byte, d *Decoder) (string, error) {
+func addRegOrMemToReg(operation byte, d *Decoder) (Instruction, error) {
 	dest, src, err := d.regOrMemWithReg("ADD: Reg/memory with register to either", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
-	return fmt.Sprintf("add %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "add", Operands: []Operand{dest, src}}, nil
 }
 
 // [100000|s|w] [mod|000|r/m] [disp-lo?] [disp-hi?] [data] [data if s|w = 0|1]
-func addImmediateToRegOrMem(operation byte, d *Decoder) (string, error) {
+func addImmediateToRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	instruction, err := buildImmediateWithRegOrMemArithmeticInstruction("add", 0b000, "ADD: immediate to register/memory", operation, d)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 	return instruction, nil
 }
 
 // [0000010|w] [data] [data if w = 1]
-func addImmediateToAccumulator(operation byte, d *Decoder) (string, error) {
+func addImmediateToAccumulator(operation byte, d *Decoder) (Instruction, error) {
 	regName, immediateValue, err := d.immediateWithAccumulator("ADD: immediate to accumulator", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("add %s, %d\n", regName, immediateValue), nil
+	return Instruction{Mnemonic: "add", Operands: []Operand{registerOperand(regName), immediateOperand(immediateValue, immediateEncoding(regName == "ax"))}, Comment: d.signedComment(immediateValue)}, nil
 }
 
 // [000100|d|w] [mod|reg|r/m] [disp-lo?] [disp-hi?]
-func adcRegOrMemToReg(operation byte, d *Decoder) (string, error) {
+func adcRegOrMemToReg(operation byte, d *Decoder) (Instruction, error) {
 	dest, src, err := d.regOrMemWithReg("ADC: Reg/memory with register to either", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("adc %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "adc", Operands: []Operand{dest, src}}, nil
 }
 
 // [100000|s|w] [mod|010|r/m] [disp-lo?] [disp-hi?] [data] [data if s|w = 0|1]
-func adcImmediateToRegOrMem(operation byte, d *Decoder) (string, error) {
+func adcImmediateToRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	instruction, err := buildImmediateWithRegOrMemArithmeticInstruction("adc", 0b010, "ADC: immediate to register/memory", operation, d)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 	return instruction, nil
 }
 
 // [0001010|w] [data] [data if w = 1]
-func adcImmediateToAccumulator(operation byte, d *Decoder) (string, error) {
+func adcImmediateToAccumulator(operation byte, d *Decoder) (Instruction, error) {
 	regName, immediateValue, err := d.immediateWithAccumulator("ADC: immediate to accumulator", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("adc %s, %d\n", regName, immediateValue), nil
+	return Instruction{Mnemonic: "adc", Operands: []Operand{registerOperand(regName), immediateOperand(immediateValue, immediateEncoding(regName == "ax"))}, Comment: d.signedComment(immediateValue)}, nil
 }
 
 // [1111111|w] [mod|000|r/m] [disp-lo?] [disp-hi?]
-func incRegOrMem(operation byte, d *Decoder) (string, error) {
+func incRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -151,7 +148,7 @@ func incRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'INC: register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'INC: register/memory' instruction")
 	}
 
 	mod := operand >> 6
@@ -160,98 +157,91 @@ func incRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	// must be 000 according to the "Instruction reference"
 	if reg != 0b000 {
-		return "", fmt.Errorf("expected the reg field to be 000 for the 'INC: register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected the reg field to be 000 for the 'INC: register/memory' instruction")
 	}
 
 	dest, err := d.decodeUnaryRegOrMem("INC: register/memory", mod, rm, isWord)
 	if err != nil {
-		return "", err
-	}
-
-	size := ""
-	if isWord {
-		size = "word"
-	} else {
-		size = "byte"
+		return Instruction{}, err
 	}
 
 	if mod != RegisterModeFieldEncoding {
-		return fmt.Sprintf("inc %s %s\n", size, dest), nil
-	} else {
-		return fmt.Sprintf("inc %s\n", dest), nil
+		dest.Specifier = sizeSpecifier(isWord)
 	}
+
+	return Instruction{Mnemonic: "inc", Operands: []Operand{dest}}, nil
 }
 
 // [01000|reg]
 // Word operation
-func incReg(operation byte, d *Decoder) (string, error) {
+func incReg(operation byte, d *Decoder) (Instruction, error) {
 	reg := operation & 0b00000111
 	regName := WordOperationRegisterFieldEncoding[reg]
 
-	return fmt.Sprintf("inc %s\n", regName), nil
+	return Instruction{Mnemonic: "inc", Operands: []Operand{registerOperand(regName)}}, nil
 }
 
 // [001010|d|w] [mod|reg|r/m] [disp-lo?] [disp-hi?]
-func subRegOrMemFromReg(operation byte, d *Decoder) (string, error) {
+func subRegOrMemFromReg(operation byte, d *Decoder) (Instruction, error) {
 	dest, src, err := d.regOrMemWithReg("SUB: Reg/memory and register to either", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("sub %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "sub", Operands: []Operand{dest, src}}, nil
 }
 
 // [100000|s|w] [mod|101|r/m] [disp-lo?] [disp-hi?] [data] [data if s|w = 0|1]
-func subImmediateFromRegOrMem(operation byte, d *Decoder) (string, error) {
+func subImmediateFromRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	instruction, err := buildImmediateWithRegOrMemArithmeticInstruction("sub", 0b101, "SUB: immediate from register/memory", operation, d)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 	return instruction, nil
 }
 
 // [0010110|w] [data] [data if w = 1]
-func subImmediateFromAccumulator(operation byte, d *Decoder) (string, error) {
+func subImmediateFromAccumulator(operation byte, d *Decoder) (Instruction, error) {
 	regName, immediateValue, err := d.immediateWithAccumulator("SUB: immediate from accumulator", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("sub %s, %d\n", regName, immediateValue), nil
+	return Instruction{Mnemonic: "sub", Operands: []Operand{registerOperand(regName), immediateOperand(immediateValue, immediateEncoding(regName == "ax"))}, Comment: d.signedComment(immediateValue)}, nil
 }
 
 // [000110|d|w] [mod|reg|r/m] [disp-lo?] [disp-hi?]
-func sbbRegOrMemFromReg(operation byte, d *Decoder) (string, error) {
+func sbbRegOrMemFromReg(operation byte, d *Decoder) (Instruction, error) {
 	dest, src, err := d.regOrMemWithReg("SBB: Reg/memory and register to either", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("sbb %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "sbb", Operands: []Operand{dest, src}}, nil
 
 }
 
 // [100000|s|w] [mod|011|r/m] [disp-lo?] [disp-hi?] [data] [data if s|w = 0|1]
-func sbbImmediateFromRegOrMem(operation byte, d *Decoder) (string, error) {
+func sbbImmediateFromRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	instruction, err := buildImmediateWithRegOrMemArithmeticInstruction("sbb", 0b011, "SBB: immediate from register/memory", operation, d)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 	return instruction, nil
 }
 
 // [0010110|w] [data] [data if w = 1]
-func sbbImmediateFromAccumulator(operation byte, d *Decoder) (string, error) {
+func sbbImmediateFromAccumulator(operation byte, d *Decoder) (Instruction, error) {
 	regName, immediateValue, err := d.immediateWithAccumulator("SBB: immediate from accumulator", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("sbb %s, %d\n", regName, immediateValue), nil
+	return Instruction{Mnemonic: "sbb", Operands: []Operand{registerOperand(regName), immediateOperand(immediateValue, immediateEncoding(regName == "ax"))}, Comment: d.signedComment(immediateValue)}, nil
 }
 
 // [1111111|w] [mod|001|r/m] [disp-lo?] [disp-hi?]
-func decRegOrMem(operation byte, d *Decoder) (string, error) {
+func decRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -259,7 +249,7 @@ func decRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'DEC: register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'DEC: register/memory' instruction")
 	}
 
 	mod := operand >> 6
@@ -268,93 +258,86 @@ func decRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	// must be 001 according to the "Instruction reference"
 	if reg != 0b001 {
-		return "", fmt.Errorf("expected the reg field to be 001 for the 'DEC: register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected the reg field to be 001 for the 'DEC: register/memory' instruction")
 	}
 
 	dest, err := d.decodeUnaryRegOrMem("DEC: register/memory", mod, rm, isWord)
 	if err != nil {
-		return "", err
-	}
-
-	size := ""
-	if isWord {
-		size = "word"
-	} else {
-		size = "byte"
+		return Instruction{}, err
 	}
 
 	if mod != RegisterModeFieldEncoding {
-		return fmt.Sprintf("dec %s %s\n", size, dest), nil
-	} else {
-		return fmt.Sprintf("dec %s\n", dest), nil
+		dest.Specifier = sizeSpecifier(isWord)
 	}
+
+	return Instruction{Mnemonic: "dec", Operands: []Operand{dest}}, nil
 }
 
 // [01001|reg]
 // Word operation
-func decReg(operation byte, d *Decoder) (string, error) {
+func decReg(operation byte, d *Decoder) (Instruction, error) {
 	reg := operation & 0b00000111
 	regName := WordOperationRegisterFieldEncoding[reg]
 
-	return fmt.Sprintf("dec %s\n", regName), nil
+	return Instruction{Mnemonic: "dec", Operands: []Operand{registerOperand(regName)}}, nil
 }
 
 // [001110|d|w] [mod|reg|r/m] [disp-lo?] [disp-hi?]
-func cmpRegOrMemWithReg(operation byte, d *Decoder) (string, error) {
+func cmpRegOrMemWithReg(operation byte, d *Decoder) (Instruction, error) {
 	dest, src, err := d.regOrMemWithReg("CMP: Reg/memory and register", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("cmp %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "cmp", Operands: []Operand{dest, src}}, nil
 }
 
 // [100000|s|w] [mod|111|r/m] [disp-lo?] [disp-hi?] [data] [data if s|w = 0|1]
-func cmpImmediateWithRegOrMem(operation byte, d *Decoder) (string, error) {
+func cmpImmediateWithRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	instruction, err := buildImmediateWithRegOrMemArithmeticInstruction("cmp", 0b111, "CMP: immediate with register/memory", operation, d)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 	return instruction, nil
 }
 
 // [0011110|w] [data] [data if w = 1]
-func cmpImmediateWithAccumulator(operation byte, d *Decoder) (string, error) {
+func cmpImmediateWithAccumulator(operation byte, d *Decoder) (Instruction, error) {
 	regName, immediateValue, err := d.immediateWithAccumulator("CMP: immediate with accumulator", operation)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("cmp %s, %d\n", regName, immediateValue), nil
+	return Instruction{Mnemonic: "cmp", Operands: []Operand{registerOperand(regName), immediateOperand(immediateValue, immediateEncoding(regName == "ax"))}, Comment: d.signedComment(immediateValue)}, nil
 }
 
 // [1111011|w] [mod|011|r/m] [disp-lo?] [disp-hi?]
-func neg(operation byte, d *Decoder) (string, error) {
+func neg(operation byte, d *Decoder) (Instruction, error) {
 	return mulOrDiv("neg", 0b011, "NEG: Change sign", operation, d)
 }
 
 // [1111011|w] [mod|100|r/m] [disp-lo?] [disp-hi?]
-func mul(operation byte, d *Decoder) (string, error) {
+func mul(operation byte, d *Decoder) (Instruction, error) {
 	return mulOrDiv("mul", 0b100, "MUL: Unsigned multiplication", operation, d)
 }
 
 // [1111011|w] [mod|101|r/m] [disp-lo?] [disp-hi?]
-func imul(operation byte, d *Decoder) (string, error) {
+func imul(operation byte, d *Decoder) (Instruction, error) {
 	return mulOrDiv("imul", 0b101, "IMUL: Signed multiplication", operation, d)
 }
 
 // [1111011|w] [mod|110|r/m] [disp-lo?] [disp-hi?]
-func div(operation byte, d *Decoder) (string, error) {
+func div(operation byte, d *Decoder) (Instruction, error) {
 	return mulOrDiv("div", 0b110, "DIV: Unsigned division", operation, d)
 }
 
 // [1111011|w] [mod|111|r/m] [disp-lo?] [disp-hi?]
-func idiv(operation byte, d *Decoder) (string, error) {
+func idiv(operation byte, d *Decoder) (Instruction, error) {
 	return mulOrDiv("idiv", 0b111, "IDIV: Signed division", operation, d)
 }
 
 // [100000|s|w] [mod|<regPattern>|r/m] [disp-lo?] [disp-hi?] [data] [data if s|w = 0|1]
-func buildImmediateWithRegOrMemArithmeticInstruction(mnemonic string, regPattern byte, instructionName string, operation byte, d *Decoder) (string, error) {
+func buildImmediateWithRegOrMemArithmeticInstruction(mnemonic string, regPattern byte, instructionName string, operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -364,60 +347,60 @@ func buildImmediateWithRegOrMemArithmeticInstruction(mnemonic string, regPattern
 	verifySign(sign)
 	isSigned := sign == SignExtension
 
+	// s|w = 1|0 (0x82) is an undocumented alias of the byte form
+	if d.Strict && isSigned && !isWord {
+		return Instruction{}, fmt.Errorf("the sign extension of a byte immediate is undefined for the '%s' instruction", instructionName)
+	}
+
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
+		return Instruction{}, fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
 	}
 
 	mod, reg, rm := decodeOperand(operand)
 
 	if reg != regPattern {
-		return "", fmt.Errorf("expected the reg field to be %.3b for the '%s' instruction", regPattern, instructionName)
+		return Instruction{}, fmt.Errorf("expected the reg field to be %.3b for the '%s' instruction", regPattern, instructionName)
 	}
 
 	dest, err := d.decodeUnaryRegOrMem(instructionName, mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
 	// the 8086 uses optimization technique - instead of using two bytes to represent a 16-bit immediate value, it can use one byte and sign-extend it, saving a byte in the instruction encoding when the immediate value is small enough to fit in a signed byte.
 	immediateValue, err := d.decodeImmediate(instructionName, isWord && !isSigned)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	size := ""
-	if isWord {
-		size = "word"
-	} else {
-		size = "byte"
+	if isSigned && isWord {
+		// the sign-extension is done here, so the value is the same as the one the CPU would use
+		immediateValue = uint16(int16(int8(uint8(immediateValue))))
 	}
-
-	var builder strings.Builder
-	fmt.Fprintf(&builder, "%s %s, ", mnemonic, dest)
+	encoding := immediateEncoding(isWord)
+	if isSigned && isWord {
+		encoding = ImmediateSignExtendedByte
+	}
+	src := immediateOperand(immediateValue, encoding)
 
 	// we need to specify the size of the value
 	if mod != RegisterModeFieldEncoding {
 		// add [bp + 75], byte 12
 		// sub [bp + 75], word 512
-		builder.WriteString(size + " ")
-	}
-
-	if isSigned {
-		truncated := uint8(immediateValue)
-		fmt.Fprintf(&builder, "%d", int8(truncated))
-	} else {
-		fmt.Fprintf(&builder, "%d", immediateValue)
+		src.Specifier = sizeSpecifier(isWord)
 	}
 
-	builder.WriteString("\n")
-
-	return builder.String(), nil
+	return Instruction{
+		Mnemonic: mnemonic,
+		Operands: []Operand{dest, src},
+		Comment:  d.signedComment(immediateValue),
+	}, nil
 }
 
 // [1111011|w] [mod|<regPattern>|r/m] [disp-lo?] [disp-hi?]
 // definitions.INSTRUCTION_REFERENCE refers to mul, imul, aam as "Multiplication" and div, idiv, aad, cbw, cwd as "Division"
-func mulOrDiv(mnemonic string, regPattern byte, instructionName string, operation byte, d *Decoder) (string, error) {
+func mulOrDiv(mnemonic string, regPattern byte, instructionName string, operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -425,7 +408,7 @@ func mulOrDiv(mnemonic string, regPattern byte, instructionName string, operatio
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
+		return Instruction{}, fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
 	}
 
 	mod := operand >> 6
@@ -433,24 +416,17 @@ func mulOrDiv(mnemonic string, regPattern byte, instructionName string, operatio
 	rm := operand & 0b00000111
 
 	if reg != regPattern {
-		return "", fmt.Errorf("expected the reg field to be %.3b for the '%s' instruction", regPattern, instructionName)
+		return Instruction{}, fmt.Errorf("expected the reg field to be %.3b for the '%s' instruction", regPattern, instructionName)
 	}
 
 	dest, err := d.decodeUnaryRegOrMem(instructionName, mod, rm, isWord)
 	if err != nil {
-		return "", err
-	}
-
-	size := ""
-	if isWord {
-		size = "word"
-	} else {
-		size = "byte"
+		return Instruction{}, err
 	}
 
 	if mod != RegisterModeFieldEncoding {
-		return fmt.Sprintf("%s %s %s\n", mnemonic, size, dest), nil
-	} else {
-		return fmt.Sprintf("%s %s\n", mnemonic, dest), nil
+		dest.Specifier = sizeSpecifier(isWord)
 	}
+
+	return Instruction{Mnemonic: mnemonic, Operands: []Operand{dest}}, nil
 }
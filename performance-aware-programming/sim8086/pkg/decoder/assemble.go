@@ -0,0 +1,95 @@
+package decoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Assemble turns a single line of NASM into the machine code, the reverse of the decoding.
+// Only mov and the arithmetic and logic instructions (add, sub, cmp etc.) with the register and the immediate
+// operands are supported: mov ax, bx or add cx, -12. The comment after ";" is ignored.
+// The encoding is the one NASM picks, the word immediates that fit into a byte are sign-extended
+func Assemble(line string) ([]byte, error) {
+	line, _, _ = strings.Cut(line, ";")
+	line = strings.TrimSpace(line)
+
+	// the mnemonic is followed by any whitespace: mov\tax, bx
+	mnemonic, rest := line, ""
+	if index := strings.IndexFunc(line, unicode.IsSpace); index >= 0 {
+		mnemonic, rest = line[:index], line[index+1:]
+	}
+	mnemonic = strings.ToLower(mnemonic)
+
+	_, isArithmetic := arithmeticOperations[mnemonic]
+	if mnemonic != "mov" && !isArithmetic {
+		return nil, fmt.Errorf("the '%s' instruction isn't supported by the assembler", mnemonic)
+	}
+
+	fields := strings.Split(rest, ",")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("expected 2 operands for the '%s' instruction, got '%s'", mnemonic, strings.TrimSpace(rest))
+	}
+
+	for _, field := range fields {
+		if strings.Contains(field, "[") {
+			return nil, fmt.Errorf("the memory operands aren't supported by the assembler, got '%s'", strings.TrimSpace(field))
+		}
+	}
+
+	dest, err := assembleRegister(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination of the '%s' instruction: %w", mnemonic, err)
+	}
+	isWord := isWordRegister(dest.Register)
+
+	src, err := assembleRegister(fields[1])
+	if err == nil && isWordRegister(src.Register) != isWord {
+		return nil, fmt.Errorf("expected the operands of the '%s' instruction to be of the same size", mnemonic)
+	} else if err != nil {
+		src, err = assembleImmediate(fields[1], isWord)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source of the '%s' instruction: %w", mnemonic, err)
+		}
+
+		// mov has no sign-extended form
+		signed := int16(src.Immediate.Value)
+		if isArithmetic && isWord && signed >= -128 && signed <= 127 {
+			src.Immediate.Encoding = ImmediateSignExtendedByte
+		}
+	}
+
+	return Encode(Instruction{Mnemonic: mnemonic, Operands: []Operand{dest, src}})
+}
+
+// assembleRegister parses the name of a general register: ax or cl
+func assembleRegister(field string) (Operand, error) {
+	name := strings.ToLower(strings.TrimSpace(field))
+	if _, ok := generalRegisterIndex(name); !ok {
+		return Operand{}, fmt.Errorf("expected a general register, got '%s'", name)
+	}
+
+	return registerOperand(name), nil
+}
+
+// assembleImmediate parses the decimal or hex (0x) number, negative or not, that fits into the destination
+func assembleImmediate(field string, isWord bool) (Operand, error) {
+	text := strings.TrimSpace(field)
+	value, err := strconv.ParseInt(text, 0, 32)
+	if err != nil {
+		return Operand{}, fmt.Errorf("expected a register or a number, got '%s'", text)
+	}
+
+	if isWord && (value < -0x8000 || value > 0xffff) {
+		return Operand{}, fmt.Errorf("the immediate %d doesn't fit into a word", value)
+	}
+	if !isWord && (value < -0x80 || value > 0xff) {
+		return Operand{}, fmt.Errorf("the immediate %d doesn't fit into a byte", value)
+	}
+
+	if isWord {
+		return immediateOperand(uint16(value), ImmediateWord), nil
+	}
+	return immediateOperand(uint16(uint8(value)), ImmediateByte), nil
+}
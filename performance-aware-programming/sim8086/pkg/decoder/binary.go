@@ -0,0 +1,27 @@
+package decoder
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// EncodeBinary writes the instructions decoded so far in the gob format, e.g. for a UI that talks to the decoder
+// over a pipe. It's faster to produce and to parse than the text. DecodeBinary reads them back
+func (d *Decoder) EncodeBinary(w io.Writer) error {
+	if err := gob.NewEncoder(w).Encode(d.Instructions()); err != nil {
+		return fmt.Errorf("failed to encode the instructions: %w", err)
+	}
+
+	return nil
+}
+
+// DecodeBinary reads the instructions written by EncodeBinary
+func DecodeBinary(r io.Reader) ([]Instruction, error) {
+	instructions := make([]Instruction, 0)
+	if err := gob.NewDecoder(r).Decode(&instructions); err != nil {
+		return nil, fmt.Errorf("failed to decode the instructions: %w", err)
+	}
+
+	return instructions, nil
+}
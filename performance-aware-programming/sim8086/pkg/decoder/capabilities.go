@@ -0,0 +1,67 @@
+package decoder
+
+// Features tell which of the optional parts of the instruction set and of the output the decoder implements.
+// The instruction set features are found by decoding an example of each, so they follow the opcode table
+type Features struct {
+	Coprocessor      bool // the 8087 instructions of ESC and WAIT: fadd, fstcw, ...
+	SegmentMove      bool // mov to/from the segment registers
+	Return           bool // ret and retf with and without the immediate
+	StringPrefixes   bool // rep/repz/repnz with the string instructions
+	Segments386      bool // the fs/gs segment overrides, see Decoder.Allow386Segments
+	NearJumps386     bool // the 386 conditional jumps with the 16-bit displacement (0x0F 0x8x)
+	Instructions186  bool // enter, leave, pusha, popa, bound, imul with the immediate, see Decoder.Allow186
+	RecursiveDescent bool // see Decoder.RecursiveDescent
+	SyntaxATT        bool // see SyntaxATT
+	SyntaxObjdump    bool // see SyntaxObjdump
+	Encode           bool // see Encode
+}
+
+// Capabilities returns what this version of the decoder implements with every option enabled.
+// Decoder.Capabilities tells what a decoder accepts with its options
+func Capabilities() Features {
+	return capabilities(func(d *Decoder) {
+		d.Allow186 = true
+		d.Allow386Segments = true
+	})
+}
+
+// Capabilities returns what the decoder accepts with its options:
+// Segments386 and Instructions186 are only reported when they are allowed
+func (d *Decoder) Capabilities() Features {
+	return capabilities(func(probe *Decoder) {
+		probe.Allow186 = d.Allow186
+		probe.Allow386Segments = d.Allow386Segments
+		probe.PopCS = d.PopCS
+	})
+}
+
+// capabilities decodes an example of every instruction set feature with the options
+func capabilities(configure func(d *Decoder)) Features {
+	decodes := func(examples ...[]byte) bool {
+		for _, source := range examples {
+			d := NewDecoder(source)
+			configure(d)
+			instruction, ok, err := d.Next()
+			if err != nil || !ok || instruction.Size != len(source) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return Features{
+		Coprocessor:     decodes([]byte{0b11011001, 0b00111110, 0b00110100, 0b00010010}, []byte{0b10011011}),         // fnstcw word [4660], wait
+		SegmentMove:     decodes([]byte{0b10001110, 0b11011000}, []byte{0b10001100, 0b11000000}),                     // mov ds, ax; mov ax, es
+		Return:          decodes([]byte{0b11000011}, []byte{0b11001011}, []byte{0b11000010, 0b00000100, 0b00000000}), // ret, retf, ret 4
+		StringPrefixes:  decodes([]byte{0b11110011, 0b10100100}, []byte{0b11110010, 0b10101110}),                     // rep movsb, repnz scasb
+		Segments386:     decodes([]byte{0b01100100, 0b10001011, 0b00000111}),                                         // mov ax, fs:[bx]
+		NearJumps386:    decodes([]byte{0b00001111, 0b10000100, 0b00000000, 0b00000000}),                             // jz near 4
+		Instructions186: decodes([]byte{0b11001001}, []byte{0b01100000}),                                             // leave, pusha
+
+		// the parts of the API rather than the opcodes
+		RecursiveDescent: true,
+		SyntaxATT:        true,
+		SyntaxObjdump:    true,
+		Encode:           true,
+	}
+}
@@ -0,0 +1,136 @@
+package decoder
+
+type EdgeKind byte
+
+const (
+	EdgeFallThrough EdgeKind = iota // the next instruction in the stream
+	EdgeTaken                       // the jump/call destination
+)
+
+// BasicBlock is a straight sequence of instructions with a single entry and a single exit
+type BasicBlock struct {
+	Start        int // offset of the first instruction
+	End          int // offset right after the last instruction
+	Instructions []Instruction
+}
+
+// Edge connects two blocks by their indices in CFG.Blocks
+type Edge struct {
+	From int
+	To   int
+	Kind EdgeKind
+}
+
+// CFG is a control-flow graph of the decoded instructions
+type CFG struct {
+	Blocks []BasicBlock
+	Edges  []Edge
+}
+
+// BuildCFG splits the instructions into basic blocks at the jump targets and after the control transfers.
+// The instructions must be sorted by the offset, the way the Decoder produces them.
+// The destinations that aren't the start of a decoded instruction (indirect jumps, targets outside the binary
+// or in the middle of an instruction) get no edge.
+func BuildCFG(instructions []Instruction) *CFG {
+	cfg := &CFG{
+		Blocks: make([]BasicBlock, 0),
+		Edges:  make([]Edge, 0),
+	}
+
+	if len(instructions) == 0 {
+		return cfg
+	}
+
+	starts := make(map[int]bool, len(instructions))
+	for _, instruction := range instructions {
+		starts[instruction.Offset] = true
+	}
+
+	// a leader is the first instruction of a block
+	leaders := map[int]bool{instructions[0].Offset: true}
+	for idx, instruction := range instructions {
+		if !isControlTransfer(instruction) {
+			continue
+		}
+
+		if target, ok := jumpTarget(instruction); ok && starts[target] {
+			leaders[target] = true
+		}
+
+		if idx+1 < len(instructions) {
+			leaders[instructions[idx+1].Offset] = true
+		}
+	}
+
+	blockIndex := make(map[int]int, len(leaders)) // offset of the leader: block index
+	for _, instruction := range instructions {
+		if leaders[instruction.Offset] {
+			blockIndex[instruction.Offset] = len(cfg.Blocks)
+			cfg.Blocks = append(cfg.Blocks, BasicBlock{Start: instruction.Offset})
+		}
+
+		block := &cfg.Blocks[len(cfg.Blocks)-1]
+		block.Instructions = append(block.Instructions, instruction)
+		block.End = instruction.Offset + instruction.Size
+	}
+
+	for idx, block := range cfg.Blocks {
+		last := block.Instructions[len(block.Instructions)-1]
+
+		if target, ok := jumpTarget(last); ok && starts[target] {
+			cfg.Edges = append(cfg.Edges, Edge{From: idx, To: blockIndex[target], Kind: EdgeTaken})
+		}
+
+		if !endsFlow(last) && idx+1 < len(cfg.Blocks) {
+			cfg.Edges = append(cfg.Edges, Edge{From: idx, To: idx + 1, Kind: EdgeFallThrough})
+		}
+	}
+
+	return cfg
+}
+
+// Successors returns the indices of the blocks the control can be transferred to from the block
+func (c *CFG) Successors(block int) []int {
+	successors := make([]int, 0, 2)
+	for _, edge := range c.Edges {
+		if edge.From == block {
+			successors = append(successors, edge.To)
+		}
+	}
+
+	return successors
+}
+
+// jumpTarget returns the destination of the direct jumps and calls
+func jumpTarget(instruction Instruction) (int, bool) {
+	for _, operand := range instruction.Operands {
+		if operand.Type == OperandLabel || operand.Type == OperandOffset {
+			return operand.Target, true
+		}
+	}
+
+	return 0, false
+}
+
+func isControlTransfer(instruction Instruction) bool {
+	if _, ok := jumpTarget(instruction); ok {
+		return true
+	}
+
+	switch instruction.Mnemonic {
+	case "jmp", "call", "ret", "retf", "iret", "hlt":
+		return true
+	}
+
+	return false
+}
+
+// endsFlow reports whether the instruction never falls through to the next one
+func endsFlow(instruction Instruction) bool {
+	switch instruction.Mnemonic {
+	case "jmp", "ret", "retf", "iret", "hlt":
+		return true
+	}
+
+	return false
+}
@@ -8,270 +8,303 @@ import (
 // [11101000] [ip-inc-lo] [ip-inc-hi]
 // definitions.IP_INC_LO definitions.IP_INC_HI
 // Example: call 11804
-func callDirectWithinSegment(operation byte, d *Decoder) (string, error) {
+func callDirectWithinSegment(operation byte, d *Decoder) (Instruction, error) {
 	low, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a lower instruction pointer byte in 'CALL: Direct within segment'")
+		return Instruction{}, fmt.Errorf("expected to get a lower instruction pointer byte in 'CALL: Direct within segment'")
 	}
 	high, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a higher instruction pointer byte in 'CALL: Direct within segment'")
+		return Instruction{}, fmt.Errorf("expected to get a higher instruction pointer byte in 'CALL: Direct within segment'")
 	}
 
 	pointerIncrement := binary.LittleEndian.Uint16([]byte{low, high})
-	pointer := uint32(pointerIncrement) + uint32(d.pos)
-	return fmt.Sprintf("call %d\n", pointer), nil
+	target := Operand{Type: OperandOffset, Target: d.nearTarget(pointerIncrement)}
+	if d.ExplicitJumpSize {
+		target.Specifier = "near"
+	}
+	return Instruction{Mnemonic: "call", Operands: []Operand{target}}, nil
 }
 
 // [11111111] [mod|010|r/m] [disp-lo?] [disp-hi?]
-// Example: call ax or call [bp - 100] or call near [bp+si-0x3a]
-func callIndirectWithinSegment(operation byte, d *Decoder) (string, error) {
+// Example: call ax or call word [bp - 100]
+func callIndirectWithinSegment(operation byte, d *Decoder) (Instruction, error) {
 	const isWord = true
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand in 'CALL: Indirect within segment'")
+		return Instruction{}, fmt.Errorf("expected to get an operand in 'CALL: Indirect within segment'")
 	}
 	mod, reg, rm := decodeOperand(operand)
 	if reg != 0b010 {
-		return "", fmt.Errorf("expected to get a register value of 010 in 'CALL: Indirect within segment'")
+		return Instruction{}, fmt.Errorf("expected to get a register value of 010 in 'CALL: Indirect within segment'")
 	}
 	procedureAddress, err := d.decodeUnaryRegOrMem("CALL: Indirect within segment", mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("call %s\n", procedureAddress), nil
+	// the size keyword tells the reader (and NASM) that the pointer in memory is a near one
+	if procedureAddress.Type == OperandMemory {
+		procedureAddress.Specifier = "word"
+	}
+	return Instruction{Mnemonic: "call", Operands: []Operand{procedureAddress}}, nil
 }
 
 // [10011010] [ip-lo] [ip-hi] [cs-lo] [cs-hi]
 // Example: call 123:456; 10011010 (11001000 00000001 = 456 le) (01111011 00000000 = 123 le)
 // definitions.IP_LO definitions.IP_HI
-func callDirectIntersegment(operation byte, d *Decoder) (string, error) {
+func callDirectIntersegment(operation byte, d *Decoder) (Instruction, error) {
 	ipLow, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a lower instruction pointer byte in 'CALL: Direct intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a lower instruction pointer byte in 'CALL: Direct intersegment'")
 	}
 	ipHigh, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a higher instruction pointer byte in 'CALL: Direct intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a higher instruction pointer byte in 'CALL: Direct intersegment'")
 	}
 
 	codeSegmentLow, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a lower code segment byte in 'CALL: Direct intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a lower code segment byte in 'CALL: Direct intersegment'")
 	}
 
 	codeSegmentHigh, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a higher code segment byte in 'CALL: Direct intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a higher code segment byte in 'CALL: Direct intersegment'")
 	}
 
 	instructionPointer := binary.LittleEndian.Uint16([]byte{ipLow, ipHigh})
 	codeSegment := binary.LittleEndian.Uint16([]byte{codeSegmentLow, codeSegmentHigh})
 
-	return fmt.Sprintf("call %d:%d\n", codeSegment, instructionPointer), nil
+	pointer := FarPointer{Segment: codeSegment, Offset: instructionPointer}
+	return Instruction{Mnemonic: "call", Operands: []Operand{{Type: OperandFarPointer, Pointer: pointer}}}, nil
 }
 
 // [11111111] [mod|011|r/m] [disp-lo?] [disp-hi?]
 // Example: call far [bp+si-0x3a]
-func callIndirectIntersegment(operation byte, d *Decoder) (string, error) {
+func callIndirectIntersegment(operation byte, d *Decoder) (Instruction, error) {
 	const isWord = true
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand in 'CALL: Indirect intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get an operand in 'CALL: Indirect intersegment'")
 	}
 	mod, reg, rm := decodeOperand(operand)
 	if reg != 0b011 {
-		return "", fmt.Errorf("expected to get a register value of 011 in 'CALL: Indirect intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a register value of 011 in 'CALL: Indirect intersegment'")
 	}
 	procedureAddress, err := d.decodeUnaryRegOrMem("CALL: Indirect intersegment", mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("call far %s\n", procedureAddress), nil
+	procedureAddress.Specifier = "far"
+	return Instruction{Mnemonic: "call", Operands: []Operand{procedureAddress}}, nil
 }
 
 // [11101001] [ip-inc-lo] [ip-inc-hi]
 // definitions.IP_INC_LO definitions.IP_INC_HI
-func jumpDirectWithinSegment(operation byte, d *Decoder) (string, error) {
+func jumpDirectWithinSegment(operation byte, d *Decoder) (Instruction, error) {
 	low, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a lower instruction pointer byte in 'JMP: Direct within segment'")
+		return Instruction{}, fmt.Errorf("expected to get a lower instruction pointer byte in 'JMP: Direct within segment'")
 	}
 	high, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a higher instruction pointer byte in 'JMP: Direct within segment'")
+		return Instruction{}, fmt.Errorf("expected to get a higher instruction pointer byte in 'JMP: Direct within segment'")
 	}
 
 	pointerIncrement := binary.LittleEndian.Uint16([]byte{low, high})
-	pointer := uint32(pointerIncrement) + uint32(d.pos)
-	return fmt.Sprintf("jmp %d\n", pointer), nil
+
+	// NASM shrinks the jump to the short form when the increment fits into a byte,
+	// so the keyword is needed to keep the original encoding
+	target := Operand{Type: OperandOffset, Target: d.nearTarget(pointerIncrement)}
+	signed := int16(pointerIncrement)
+	if (signed >= -128 && signed <= 127) || d.ExplicitJumpSize {
+		target.Specifier = "near"
+	}
+
+	return Instruction{Mnemonic: "jmp", Operands: []Operand{target}}, nil
 }
 
 // [11101011] [inc-inc8]
 // definitions.IP_INC8
 // Example: jmp test_label where label is within 127 bytes
-func jumpDirectWithinSegmentShort(operation byte, d *Decoder) (string, error) {
+func jumpDirectWithinSegmentShort(operation byte, d *Decoder) (Instruction, error) {
 	pointerIncrement, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an 8-bit instruction pointer increment in 'JMP: Direct within segment-short'")
+		return Instruction{}, fmt.Errorf("expected to get an 8-bit instruction pointer increment in 'JMP: Direct within segment-short'")
 	}
 
 	offset := int8(pointerIncrement)
 	address := d.pos + int(offset)
-	labelName := createLabelName(address)
+	labelName := createLabelName(address, false)
 	d.labels[address] = labelName
-	return fmt.Sprintf("jmp %s\n", labelName), nil
+	// without the keyword, NASM is free to choose the near encoding
+	target := Operand{Type: OperandLabel, Target: address, Specifier: "short"}
+	return Instruction{Mnemonic: "jmp", Operands: []Operand{target}}, nil
 }
 
 // [11111111] [mod|100|r/m] [disp-lo?] [disp-hi?]
-func jumpIndirectWithinSegment(operation byte, d *Decoder) (string, error) {
+func jumpIndirectWithinSegment(operation byte, d *Decoder) (Instruction, error) {
 	const isWord = true
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand in 'JMP: Indirect within segment'")
+		return Instruction{}, fmt.Errorf("expected to get an operand in 'JMP: Indirect within segment'")
 	}
 	mod, reg, rm := decodeOperand(operand)
 	if reg != 0b100 {
-		return "", fmt.Errorf("expected to get a register value of 100 in 'JMP: Indirect within segment'")
+		return Instruction{}, fmt.Errorf("expected to get a register value of 100 in 'JMP: Indirect within segment'")
 	}
 	address, err := d.decodeUnaryRegOrMem("JMP: Indirect within segment", mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("jmp %s\n", address), nil
+	if address.Type == OperandMemory {
+		address.Specifier = "word"
+	}
+	return Instruction{Mnemonic: "jmp", Operands: []Operand{address}}, nil
 }
 
 // [11101010] [ip-lo] [ip-hi] [cs-lo] [cs-hi]
 // definitions.IP_LO definitions.IP_HI
-func jumpDirectIntersegment(operation byte, d *Decoder) (string, error) {
+func jumpDirectIntersegment(operation byte, d *Decoder) (Instruction, error) {
 	ipLow, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a lower instruction pointer byte in 'JMP: Direct intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a lower instruction pointer byte in 'JMP: Direct intersegment'")
 	}
 	ipHigh, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a higher instruction pointer byte in 'JMP: Direct intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a higher instruction pointer byte in 'JMP: Direct intersegment'")
 	}
 
 	codeSegmentLow, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a lower code segment byte in 'JMP: Direct intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a lower code segment byte in 'JMP: Direct intersegment'")
 	}
 
 	codeSegmentHigh, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a higher code segment byte in 'JMP: Direct intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a higher code segment byte in 'JMP: Direct intersegment'")
 	}
 
 	instructionPointer := binary.LittleEndian.Uint16([]byte{ipLow, ipHigh})
 	codeSegment := binary.LittleEndian.Uint16([]byte{codeSegmentLow, codeSegmentHigh})
 
-	return fmt.Sprintf("jmp %d:%d\n", codeSegment, instructionPointer), nil
+	pointer := FarPointer{Segment: codeSegment, Offset: instructionPointer}
+	return Instruction{Mnemonic: "jmp", Operands: []Operand{{Type: OperandFarPointer, Pointer: pointer}}}, nil
 }
 
 // [11111111] [mod|101|r/m] [disp-lo?] [disp-hi?]
-func jumpIndirectIntersegment(operation byte, d *Decoder) (string, error) {
+func jumpIndirectIntersegment(operation byte, d *Decoder) (Instruction, error) {
 	const isWord = true
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand in 'JMP: Indirect intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get an operand in 'JMP: Indirect intersegment'")
 	}
 	mod, reg, rm := decodeOperand(operand)
 	if reg != 0b101 {
-		return "", fmt.Errorf("expected to get a register value of 101 in 'JMP: Indirect intersegment'")
+		return Instruction{}, fmt.Errorf("expected to get a register value of 101 in 'JMP: Indirect intersegment'")
 	}
 	address, err := d.decodeUnaryRegOrMem("JMP: Indirect intersegment", mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("jmp far %s\n", address), nil
+	address.Specifier = "far"
+	return Instruction{Mnemonic: "jmp", Operands: []Operand{address}}, nil
 }
 
 // [11000011]
-func returnWithinSegment(operation byte, d *Decoder) (string, error) {
-	return "ret\n", nil
+func returnWithinSegment(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "ret"}, nil
 }
 
 // [11000010] [data-lo] [data-hi]
 // definitions.DATA_LO  definitions.DATA_HI
-func returnWithinSegmentAddingImmedToSP(operation byte, d *Decoder) (string, error) {
+func returnWithinSegmentAddingImmedToSP(operation byte, d *Decoder) (Instruction, error) {
 	low, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a lower data byte in 'RET: Within segment adding immediate to SP'")
+		return Instruction{}, fmt.Errorf("expected to get a lower data byte in 'RET: Within segment adding immediate to SP'")
 	}
 
 	high, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a higher data byte in 'RET: Within segment adding immediate to SP'")
+		return Instruction{}, fmt.Errorf("expected to get a higher data byte in 'RET: Within segment adding immediate to SP'")
 	}
 
 	data := binary.LittleEndian.Uint16([]byte{low, high})
-	signed := int16(data)
-	if signed < 0 {
-		return fmt.Sprintf("ret %d ; or %d \n", data, signed), nil
-	} else {
-		return fmt.Sprintf("ret %d\n", data), nil
-	}
+	return Instruction{
+		Mnemonic: "ret",
+		Operands: []Operand{immediateOperand(data, ImmediateWord)},
+		Comment:  d.signedComment(data),
+	}, nil
 }
 
 // [11001011]
-func returnIntersegment(operation byte, d *Decoder) (string, error) {
-	return "retf\n", nil
+func returnIntersegment(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "retf"}, nil
 }
 
 // [11001010] [data-lo] [data-hi]
 // definitions.DATA_LO  definitions.DATA_HI
-func returnIntersegmentAddingImmedToSP(operation byte, d *Decoder) (string, error) {
+func returnIntersegmentAddingImmedToSP(operation byte, d *Decoder) (Instruction, error) {
 	low, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a lower data byte in 'RET: Intersegment adding immediate to SP'")
+		return Instruction{}, fmt.Errorf("expected to get a lower data byte in 'RET: Intersegment adding immediate to SP'")
 	}
 
 	high, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a higher data byte in 'RET: Intersegment adding immediate to SP'")
+		return Instruction{}, fmt.Errorf("expected to get a higher data byte in 'RET: Intersegment adding immediate to SP'")
 	}
 
 	data := binary.LittleEndian.Uint16([]byte{low, high})
-	signed := int16(data)
-	if signed < 0 {
-		return fmt.Sprintf("retf %d ; or %d \n", data, signed), nil
-	} else {
-		return fmt.Sprintf("retf %d\n", data), nil
-	}
+	return Instruction{
+		Mnemonic: "retf",
+		Operands: []Operand{immediateOperand(data, ImmediateWord)},
+		Comment:  d.signedComment(data),
+	}, nil
 }
 
-func jumpConditionally(operation byte, d *Decoder) (string, error) {
+func jumpConditionally(operation byte, d *Decoder) (Instruction, error) {
 	name := JumpNames[operation]
 	comment := ""
 	altName, ok := JumpAlternativeNames[operation]
 	if ok {
-		comment = fmt.Sprintf("; %s", altName)
+		comment = altName
 	}
 
 	instructionPointer, ok := d.next()
 
 	if ok == false {
-		return "", fmt.Errorf("expected to get a jump instruction pointer for the '%s' instruction", name)
+		return Instruction{}, fmt.Errorf("expected to get a jump instruction pointer for the '%s' instruction", name)
 	}
 
 	offset := int8(instructionPointer) // signed value
 
 	labelLocation := d.pos + int(offset)
-	labelName := createLabelName(labelLocation)
+	labelName := createLabelName(labelLocation, false)
 	d.labels[labelLocation] = labelName
 
-	if comment == "" {
-		return fmt.Sprintf("%s %s\n", name, labelName), nil
-	} else {
-		return fmt.Sprintf("%s %s %s\n", name, labelName, comment), nil
-	}
+	return Instruction{
+		Mnemonic: name,
+		Operands: []Operand{{Type: OperandLabel, Target: labelLocation}},
+		Comment:  comment,
+	}, nil
 }
 
-func createLabelName(pos int) string {
+// nearTarget is the position in the stream the near jump or call transfers the control to.
+// The instruction pointer wraps around within the code segment, which starts Origin bytes before the stream
+func (d *Decoder) nearTarget(pointerIncrement uint16) int {
+	pointer := pointerIncrement + uint16(d.pos+d.Origin)
+	return int(pointer) - d.Origin
+}
+
+// createLabelName is label__26, or label_0x1a with the hex labels
+func createLabelName(pos int, hex bool) string {
+	if hex {
+		return fmt.Sprintf("label_0x%x", pos)
+	}
 	return fmt.Sprintf("label__%d", pos)
 }
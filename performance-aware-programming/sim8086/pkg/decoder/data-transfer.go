@@ -1,12 +1,9 @@
 package decoder
 
-import (
-	"fmt"
-	"strings"
-)
+import "fmt"
 
 // [1100011|w] [mod|000|r/m] [disp-lo] [disp-hi] [data] [data if w=1]
-func moveImmediateToRegOrMem(operation byte, d *Decoder) (string, error) {
+func moveImmediateToRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -14,7 +11,7 @@ func moveImmediateToRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'immediate to register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'immediate to register/memory' instruction")
 	}
 
 	mod := operand >> 6
@@ -23,49 +20,37 @@ func moveImmediateToRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	// must be 000 according to the "Instruction reference"
 	if reg != 0 {
-		return "", fmt.Errorf("expected the reg field to be 000 for the 'immediate to register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected the reg field to be 000 for the 'immediate to register/memory' instruction")
 	}
 
 	dest, err := d.decodeUnaryRegOrMem("immediate to register/memory", mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
 	immediateValue, err := d.decodeImmediate("immediate to register/memory", isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	size := ""
-	if isWord {
-		size = "word"
-	} else {
-		size = "byte"
-	}
-
-	signedValue := int16(immediateValue)
-	var builder strings.Builder
-	fmt.Fprintf(&builder, "mov %s, ", dest)
+	src := immediateOperand(immediateValue, immediateEncoding(isWord))
 
 	// we need to specify the size of the value
 	if mod != RegisterModeFieldEncoding {
 		// mov [bp + 75], byte 12
 		// mov [bp + 75], word 512
-		builder.WriteString(size + " ")
-	}
-
-	fmt.Fprintf(&builder, "%d", immediateValue)
-
-	if signedValue < 0 {
-		fmt.Fprintf(&builder, " ; or %d", signedValue)
+		src.Specifier = sizeSpecifier(isWord)
 	}
 
-	builder.WriteString("\n")
-	return builder.String(), nil
+	return Instruction{
+		Mnemonic: "mov",
+		Operands: []Operand{dest, src},
+		Comment:  d.signedComment(immediateValue),
+	}, nil
 }
 
 // [1011|w|reg]  [data]  [data if w = 1]
-func moveImmediateToReg(operation byte, d *Decoder) (string, error) {
+func moveImmediateToReg(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := (operation >> 3) & 0b00000001
 	verifyOperationType(operationType)
@@ -81,19 +66,18 @@ func moveImmediateToReg(operation byte, d *Decoder) (string, error) {
 
 	immediateValue, err := d.decodeImmediate("MOV: immediate to register", isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
-	signedValue := int16(immediateValue)
 
-	if signedValue < 0 {
-		return fmt.Sprintf("mov %s, %d ; or %d\n", regName, immediateValue, signedValue), nil
-	} else {
-		return fmt.Sprintf("mov %s, %d\n", regName, immediateValue), nil
-	}
+	return Instruction{
+		Mnemonic: "mov",
+		Operands: []Operand{registerOperand(regName), immediateOperand(immediateValue, immediateEncoding(isWord))},
+		Comment:  d.signedComment(immediateValue),
+	}, nil
 }
 
 // [100010|d|w] [mod|reg|r/m] [disp-lo] [disp-hi]
-func moveRegMemToReg(operation byte, d *Decoder) (string, error) {
+func moveRegMemToReg(operation byte, d *Decoder) (Instruction, error) {
 	// direction is the 2nd bit
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	dir := (operation >> 1) & 0b00000001
@@ -106,7 +90,7 @@ func moveRegMemToReg(operation byte, d *Decoder) (string, error) {
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'Register/memory to/from register' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'Register/memory to/from register' instruction")
 	}
 
 	// mod is the 2 high bits
@@ -123,14 +107,14 @@ func moveRegMemToReg(operation byte, d *Decoder) (string, error) {
 
 	dest, src, err := d.decodeBinaryRegOrMem("Register/memory to/from register", mod, regName, rm, isWord, dir)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("mov %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "mov", Operands: []Operand{dest, src}}, nil
 }
 
 // [1010000|w] [addr-lo] [addr-hi]
-func moveMemoryToAccumulator(operation byte, d *Decoder) (string, error) {
+func moveMemoryToAccumulator(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -143,24 +127,24 @@ func moveMemoryToAccumulator(operation byte, d *Decoder) (string, error) {
 		regName = "al"
 	}
 
-	address, err := d.decodeAddress("MOV: memory to accumulator", isWord)
+	address, err := d.decodeAddress("MOV: memory to accumulator")
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("mov %s, [%d]\n", regName, address), nil
+	return Instruction{Mnemonic: "mov", Operands: []Operand{registerOperand(regName), d.directAddressOperand(address)}}, nil
 }
 
 // [1010001|w] [addr-lo] [addr-hi]
-func moveAccumulatorToMemory(operation byte, d *Decoder) (string, error) {
+func moveAccumulatorToMemory(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
 	isWord := operationType == WordOperation
 
-	address, err := d.decodeAddress("MOV: accumulator to address", isWord)
+	address, err := d.decodeAddress("MOV: accumulator to address")
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
 	regName := ""
@@ -170,48 +154,55 @@ func moveAccumulatorToMemory(operation byte, d *Decoder) (string, error) {
 		regName = "al"
 	}
 
-	return fmt.Sprintf("mov [%d], %s\n", address, regName), nil
+	return Instruction{Mnemonic: "mov", Operands: []Operand{d.directAddressOperand(address), registerOperand(regName)}}, nil
 }
 
 // [10001110] [mod|0|SR|r/m] [disp-lo?] [disp-hi?]
-func moveRegOrMemToSegment(operation byte, d *Decoder) (string, error) {
+func moveRegOrMemToSegment(operation byte, d *Decoder) (Instruction, error) {
 	const isWord = true
 	const dir = RegIsDestination
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'MOV: Register/memory to segment' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'MOV: Register/memory to segment' instruction")
 	}
 
 	mod, reg, rm := decodeOperand(operand)
-	if reg&0b000 != 0 {
-		return "", fmt.Errorf("expected the reg field to start with 0 for the 'MOV: Register/memory to segment' instruction")
+	// the high bit of the reg field is reserved. The 8086 ignores it, so the lenient mode masks it out
+	if d.Strict && reg&0b100 != 0 {
+		return Instruction{}, fmt.Errorf("expected the reg field to start with 0 for the 'MOV: Register/memory to segment' instruction")
 	}
 
 	sr := reg & 0b011
 	regName := SegmentRegisterFieldEncoding[sr]
 
+	// loading CS with MOV is undefined, the control transfer instructions must be used instead
+	if d.Strict && regName == "cs" {
+		return Instruction{}, fmt.Errorf("CS can't be the destination of the 'MOV: Register/memory to segment' instruction")
+	}
+
 	dest, src, err := d.decodeBinaryRegOrMem("MOV: Register/memory to segment", mod, regName, rm, isWord, dir)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("mov %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "mov", Operands: []Operand{dest, src}}, nil
 }
 
 // [10001100] [mod|0|SR|r/m] [disp-lo?] [disp-hi?]
-func moveSegmentToRegOrMem(operation byte, d *Decoder) (string, error) {
+func moveSegmentToRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	const isWord = true
 	const dir = RegIsSource
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'MOV: Segment to register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'MOV: Segment to register/memory' instruction")
 	}
 
 	mod, reg, rm := decodeOperand(operand)
-	if reg&0b000 != 0 {
-		return "", fmt.Errorf("expected the reg field to start with 0 for the 'MOV: Segment to register/memory' instruction")
+	// the high bit of the reg field is reserved. The 8086 ignores it, so the lenient mode masks it out
+	if d.Strict && reg&0b100 != 0 {
+		return Instruction{}, fmt.Errorf("expected the reg field to start with 0 for the 'MOV: Segment to register/memory' instruction")
 	}
 
 	sr := reg & 0b011
@@ -219,20 +210,20 @@ func moveSegmentToRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	dest, src, err := d.decodeBinaryRegOrMem("MOV: Segment to register/memory", mod, regName, rm, isWord, dir)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("mov %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "mov", Operands: []Operand{dest, src}}, nil
 }
 
 // [11111111] [mod|110|r/m] [disp-lo] [disp-hi]
 // PUSH decrements `SP`(stack pointer) by 2 and then transfers a word from the source operand to the top of the stack now pointed by SP
-func pushRegOrMem(operation byte, d *Decoder) (string, error) {
+func pushRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	const isWord = true
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'PUSH: register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'PUSH: register/memory' instruction")
 	}
 
 	mod := operand >> 6
@@ -241,43 +232,44 @@ func pushRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	// must be 0b110 according to the "Instruction reference"
 	if reg != 0b110 {
-		return "", fmt.Errorf("expected the reg field to be 000 for the 'PUSH: register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected the reg field to be 110 for the 'PUSH: register/memory' instruction")
 	}
 
 	source, err := d.decodeUnaryRegOrMem("PUSH: register/memory", mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("push word %s\n", source), nil
+	source.Specifier = "word"
+	return Instruction{Mnemonic: "push", Operands: []Operand{source}}, nil
 }
 
 // [01010|reg]
 // PUSH decrements `SP`(stack pointer) by 2 and then transfers a word from the source operand to the top of the stack now pointed by SP
-func pushReg(operation byte, d *Decoder) (string, error) {
+func pushReg(operation byte, d *Decoder) (Instruction, error) {
 	reg := operation & 0b00000111
 	regName := WordOperationRegisterFieldEncoding[reg]
 
-	return fmt.Sprintf("push %s\n", regName), nil
+	return Instruction{Mnemonic: "push", Operands: []Operand{registerOperand(regName)}}, nil
 }
 
 // [000|reg|110]
 // PUSH decrements `SP`(stack pointer) by 2 and then transfers a word from the source operand to the top of the stack now pointed by SP
-func pushSegmentReg(operation byte, d *Decoder) (string, error) {
+func pushSegmentReg(operation byte, d *Decoder) (Instruction, error) {
 	reg := (operation >> 3) & 0b00000111
 	regName := SegmentRegisterFieldEncoding[reg]
-	return fmt.Sprintf("push %s\n", regName), nil
+	return Instruction{Mnemonic: "push", Operands: []Operand{registerOperand(regName)}}, nil
 }
 
-// [10000111] [mod|000|r/m] [disp-lo] [disp-hi]
+// [10001111] [mod|000|r/m] [disp-lo] [disp-hi]
 // POP transfers the word at the current top of the stack (pointed to by SP) to the destination operand,
 // and then increments `SP` by 2 to point to the new top of the stack (TOS).
-func popRegOrMem(operation byte, d *Decoder) (string, error) {
+func popRegOrMem(operation byte, d *Decoder) (Instruction, error) {
 	const isWord = true
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'POP: register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'POP: register/memory' instruction")
 	}
 
 	mod := operand >> 6
@@ -286,41 +278,45 @@ func popRegOrMem(operation byte, d *Decoder) (string, error) {
 
 	// must be 0b000 according to the "Instruction reference"
 	if reg != 0b000 {
-		return "", fmt.Errorf("expected the reg field to be 000 for the 'POP: register/memory' instruction")
+		return Instruction{}, fmt.Errorf("expected the reg field to be 000 for the 'POP: register/memory' instruction")
 	}
 
 	dest, err := d.decodeUnaryRegOrMem("POP: register/memory", mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("pop word %s\n", dest), nil
+	dest.Specifier = "word"
+	return Instruction{Mnemonic: "pop", Operands: []Operand{dest}}, nil
 }
 
 // [01011|reg]
 // POP transfers the word at the current top of the stack (pointed to by SP) to the destination operand,
 // and then increments `SP` by 2 to point to the new top of the stack (TOS).
-func popReg(operation byte, d *Decoder) (string, error) {
+func popReg(operation byte, d *Decoder) (Instruction, error) {
 	reg := operation & 0b00000111
 	regName := WordOperationRegisterFieldEncoding[reg]
 
-	return fmt.Sprintf("pop %s\n", regName), nil
+	return Instruction{Mnemonic: "pop", Operands: []Operand{registerOperand(regName)}}, nil
 }
 
 // [000|reg|111]
 // POP transfers the word at the current top of the stack (pointed to by SP) to the destination operand,
 // and then increments `SP` by 2 to point to the new top of the stack (TOS).
-func popSegmentReg(operation byte, d *Decoder) (string, error) {
+// [00001111] is pop cs on the 8086, decoded with Decoder.PopCS. The later CPUs use the byte as the two-byte opcode escape
+func popSegmentReg(operation byte, d *Decoder) (Instruction, error) {
+	if operation == 0b00001111 && !d.PopCS {
+		return Instruction{}, fmt.Errorf("0x0f is pop cs only on the 8086, set PopCS to decode it")
+	}
+
 	reg := (operation >> 3) & 0b00000111
 	regName := SegmentRegisterFieldEncoding[reg]
-	return fmt.Sprintf("pop %s\n", regName), nil
+	return Instruction{Mnemonic: "pop", Operands: []Operand{registerOperand(regName)}}, nil
 }
 
 // [100001|w] [mod|reg|r/m] [disp-lo] [disp-hi]
 // Reg is always source
-func exchangeRegOrMemWithReg(operation byte, d *Decoder) (string, error) {
-	const dir = RegIsSource
-
+func exchangeRegOrMemWithReg(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -328,7 +324,7 @@ func exchangeRegOrMemWithReg(operation byte, d *Decoder) (string, error) {
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'XCHG: Register/memory with register' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'XCHG: Register/memory with register' instruction")
 	}
 
 	mod := operand >> 6
@@ -342,25 +338,32 @@ func exchangeRegOrMemWithReg(operation byte, d *Decoder) (string, error) {
 		regName = ByteOperationRegisterFieldEncoding[reg]
 	}
 
+	// NASM assembles both orders of the register and the memory to the same bytes, the register goes first: xchg ax, [bx].
+	// The atomic exchange keeps the memory first: lock xchg [bx], ax. Of the two registers, the r/m one is the first
+	dir := byte(RegIsSource)
+	if mod != RegisterModeFieldEncoding && d.prefix != "lock" {
+		dir = RegIsDestination
+	}
+
 	dest, src, err := d.decodeBinaryRegOrMem("XCHG: Register/memory with register", mod, regName, rm, isWord, dir)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("xchg %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "xchg", Operands: []Operand{dest, src}}, nil
 }
 
 // [10010|reg]
 // ONLY WORD
-func exchangeRegWithAccumulator(operation byte, d *Decoder) (string, error) {
+func exchangeRegWithAccumulator(operation byte, d *Decoder) (Instruction, error) {
 	reg := operation & 0b00000111
 	regName := WordOperationRegisterFieldEncoding[reg]
 
-	return fmt.Sprintf("xchg ax, %s\n", regName), nil
+	return Instruction{Mnemonic: "xchg", Operands: []Operand{registerOperand("ax"), registerOperand(regName)}}, nil
 }
 
 // [1110010|w] [data-8]
-func inputFromFixedPort(operation byte, d *Decoder) (string, error) {
+func inputFromFixedPort(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -375,14 +378,14 @@ func inputFromFixedPort(operation byte, d *Decoder) (string, error) {
 
 	port, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a port number for the 'IN: from fixed port' instruction")
+		return Instruction{}, fmt.Errorf("expected to get a port number for the 'IN: from fixed port' instruction")
 	}
 
-	return fmt.Sprintf("in %s, %d\n", acc, port), nil
+	return Instruction{Mnemonic: "in", Operands: []Operand{registerOperand(acc), immediateOperand(uint16(port), ImmediateByte)}}, nil
 }
 
 // [1110110|w]
-func inputFromVariablePort(operation byte, d *Decoder) (string, error) {
+func inputFromVariablePort(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -395,11 +398,11 @@ func inputFromVariablePort(operation byte, d *Decoder) (string, error) {
 		acc = "al"
 	}
 
-	return fmt.Sprintf("in %s, dx\n", acc), nil
+	return Instruction{Mnemonic: "in", Operands: []Operand{registerOperand(acc), registerOperand("dx")}}, nil
 }
 
 // [1110011w] [data-8]
-func outputToFixedPort(operation byte, d *Decoder) (string, error) {
+func outputToFixedPort(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -414,14 +417,14 @@ func outputToFixedPort(operation byte, d *Decoder) (string, error) {
 
 	port, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a port number for the 'OUT: to a fixed port' instruction")
+		return Instruction{}, fmt.Errorf("expected to get a port number for the 'OUT: to a fixed port' instruction")
 	}
 
-	return fmt.Sprintf("out %d, %s\n", port, acc), nil
+	return Instruction{Mnemonic: "out", Operands: []Operand{immediateOperand(uint16(port), ImmediateByte), registerOperand(acc)}}, nil
 }
 
 // [1110111|w]
-func outputToVariablePort(operation byte, d *Decoder) (string, error) {
+func outputToVariablePort(operation byte, d *Decoder) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -434,22 +437,22 @@ func outputToVariablePort(operation byte, d *Decoder) (string, error) {
 		acc = "al"
 	}
 
-	return fmt.Sprintf("out dx, %s\n", acc), nil
+	return Instruction{Mnemonic: "out", Operands: []Operand{registerOperand("dx"), registerOperand(acc)}}, nil
 }
 
 // [11010111]
-func xlat(operation byte, d *Decoder) (string, error) {
-	return "xlat\n", nil
+func xlat(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "xlat"}, nil
 }
 
 // [10001101] [mod|reg|r/m] [disp-lo] [disp-hi]
-func lea(operation byte, d *Decoder) (string, error) {
+func lea(operation byte, d *Decoder) (Instruction, error) {
 	const dir = RegIsDestination
 	const isWord = true
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'LEA' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'LEA' instruction")
 	}
 
 	mod := operand >> 6
@@ -463,22 +466,27 @@ func lea(operation byte, d *Decoder) (string, error) {
 		regName = ByteOperationRegisterFieldEncoding[reg]
 	}
 
+	// there is no address of a register
+	if d.Strict && mod == RegisterModeFieldEncoding {
+		return Instruction{}, fmt.Errorf("expected a memory operand for the 'LEA' instruction")
+	}
+
 	dest, src, err := d.decodeBinaryRegOrMem("LEA", mod, regName, rm, isWord, dir)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("lea %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "lea", Operands: []Operand{dest, src}}, nil
 }
 
 // [11000101] [mod|reg|r/m] [disp-lo] [disp-hi]
-func lds(operation byte, d *Decoder) (string, error) {
+func lds(operation byte, d *Decoder) (Instruction, error) {
 	const dir = RegIsDestination
 	const isWord = true
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'LDS' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'LDS' instruction")
 	}
 
 	mod := operand >> 6
@@ -492,22 +500,27 @@ func lds(operation byte, d *Decoder) (string, error) {
 		regName = ByteOperationRegisterFieldEncoding[reg]
 	}
 
+	// there is no address of a register
+	if d.Strict && mod == RegisterModeFieldEncoding {
+		return Instruction{}, fmt.Errorf("expected a memory operand for the 'LDS' instruction")
+	}
+
 	dest, src, err := d.decodeBinaryRegOrMem("LDS", mod, regName, rm, isWord, dir)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("lds %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "lds", Operands: []Operand{dest, src}}, nil
 }
 
 // [11000100] [mod|reg|r/m] [disp-lo] [disp-hi]
-func les(operation byte, d *Decoder) (string, error) {
+func les(operation byte, d *Decoder) (Instruction, error) {
 	const dir = RegIsDestination
 	const isWord = true
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the 'LES' instruction")
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'LES' instruction")
 	}
 
 	mod := operand >> 6
@@ -521,30 +534,35 @@ func les(operation byte, d *Decoder) (string, error) {
 		regName = ByteOperationRegisterFieldEncoding[reg]
 	}
 
+	// there is no address of a register
+	if d.Strict && mod == RegisterModeFieldEncoding {
+		return Instruction{}, fmt.Errorf("expected a memory operand for the 'LES' instruction")
+	}
+
 	dest, src, err := d.decodeBinaryRegOrMem("LES", mod, regName, rm, isWord, dir)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	return fmt.Sprintf("les %s, %s\n", dest, src), nil
+	return Instruction{Mnemonic: "les", Operands: []Operand{dest, src}}, nil
 }
 
 // [10011111]
-func lahf(operation byte, d *Decoder) (string, error) {
-	return "lahf\n", nil
+func lahf(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "lahf"}, nil
 }
 
 // [10011110]
-func sahf(operation byte, d *Decoder) (string, error) {
-	return "sahf\n", nil
+func sahf(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "sahf"}, nil
 }
 
 // [10011100]
-func pushf(operation byte, d *Decoder) (string, error) {
-	return "pushf\n", nil
+func pushf(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "pushf"}, nil
 }
 
 // [10011101]
-func popf(operation byte, d *Decoder) (string, error) {
-	return "popf\n", nil
+func popf(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "popf"}, nil
 }
@@ -2,8 +2,10 @@ package decoder
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"strconv"
+	"io"
+	"sort"
 	"strings"
 )
 
@@ -108,87 +110,303 @@ var EffectiveAddressEquation = map[byte]string{
 }
 
 var JumpNames = map[byte]string{
-	0b01110100: "JZ",
-	0b01111100: "JL",
-	0b01111110: "JLE",
-	0b01110010: "JB",
-	0b01110110: "JBE",
-	0b01111010: "JP",
-	0b01110000: "JO",
-	0b01111000: "JS",
-	0b01110101: "JNZ",
-	0b01111101: "JGE",
-	0b01111111: "JG",
-	0b01110011: "JAE",
-	0b01110111: "JA",
-	0b01111011: "JNP",
-	0b01110001: "JNO",
-	0b01111001: "JNS",
-	0b11100011: "JCXZ",
+	0b01110100: "jz",
+	0b01111100: "jl",
+	0b01111110: "jle",
+	0b01110010: "jb",
+	0b01110110: "jbe",
+	0b01111010: "jp",
+	0b01110000: "jo",
+	0b01111000: "js",
+	0b01110101: "jnz",
+	0b01111101: "jge",
+	0b01111111: "jg",
+	0b01110011: "jae",
+	0b01110111: "ja",
+	0b01111011: "jnp",
+	0b01110001: "jno",
+	0b01111001: "jns",
+	0b11100011: "jcxz",
 
 	// Loops
-	0b11100010: "LOOP",
-	0b11100001: "LOOPZ",
-	0b11100000: "LOOPNZ",
+	0b11100010: "loop",
+	0b11100001: "loopz",
+	0b11100000: "loopnz",
 }
 
 var JumpAlternativeNames = map[byte]string{
-	0b01110100: "JE",
-	0b01111100: "JNGE",
-	0b01111110: "JNG",
-	0b01110010: "JNAE",
-	0b01110110: "JNA",
-	0b01111010: "JPE",
-	0b01110101: "JNE",
-	0b01111101: "JNL",
-	0b01111111: "JNLE",
-	0b01110011: "JNB",
-	0b01110111: "JNBE",
-	0b01111011: "JPO",
+	0b01110100: "je",
+	0b01111100: "jnge",
+	0b01111110: "jng",
+	0b01110010: "jnae",
+	0b01110110: "jna",
+	0b01111010: "jpe",
+	0b01110101: "jne",
+	0b01111101: "jnl",
+	0b01111111: "jnle",
+	0b01110011: "jnb",
+	0b01110111: "jnbe",
+	0b01111011: "jpo",
 
 	// Loops
-	0b11100001: "LOOPE",
-	0b11100000: "LOOPNE",
+	0b11100001: "loope",
+	0b11100000: "loopne",
 }
 
-type instructionNode struct {
-	value string
-	pos   int
-}
+// MaxInstructionSize is the longest 8086 instruction: 6 bytes of the opcode, the operand, the displacement
+// and the data, preceded by the lock/rep and the segment override prefixes
+const MaxInstructionSize = 8
 
 type Decoder struct {
-	bytes    []byte
-	pos      int
-	segment  string // for the effective address segment override
-	nodes    []instructionNode
-	labels   map[int]string // pos:label
-	cacheKey string
-	decoded  []byte
+	bytes     []byte
+	pos       int
+	segment   string // for the effective address segment override
+	prefix    string // lock or rep of the instruction being decoded
+	nodes     []Instruction
+	labels    map[int]string   // pos:label
+	resyncing bool             // the last instruction couldn't be decoded, looking for the next instruction boundary
+	overrides map[byte]handler // see Override
+	// decodeErrors collects the errors the data bytes are emitted for, see DecodeAll. nil = not collected
+	decodeErrors *[]DecodeError
+	cacheKey     string
+	decoded      []byte
+
+	// Limit stops the decoding after the specified number of instructions. 0 = unlimited
+	Limit int
+
+	// Strict rejects the reserved/undefined encodings instead of decoding them the way the 8086 would
+	Strict bool
+
+	// EmitDataOnError emits the first byte of an instruction that can't be decoded as data (db 0xNN)
+	// and continues from the next byte, so the output still reassembles to the original binary
+	EmitDataOnError bool
+
+	// Resync recovers from the instruction that can't be decoded by emitting the following bytes as data (db 0xNN)
+	// one at a time, until the decoding from a byte looks plausible again (see resyncWindow)
+	Resync bool
+
+	// RecursiveDescent follows the control flow from the offset 0 instead of decoding the bytes linearly.
+	// The bytes that are never reached are emitted as data (db 0xNN)
+	RecursiveDescent bool
+
+	// Syntax of the output. SyntaxIntel (NASM) by default
+	Syntax Syntax
+
+	// ShowSignedComment appends the signed interpretation of the immediates that are negative as signed numbers:
+	// add ax, 65535 ; or -1. Enabled by default
+	ShowSignedComment bool
+
+	// SignedCommentThreshold limits the signed comment to the values whose magnitude is below it,
+	// e.g. 256 keeps "; or -1" but drops "; or -16657" of the data-like immediates. 0 = no limit
+	SignedCommentThreshold int
+
+	// ExplicitJumpSize writes the size keyword of every direct call and jmp, not only where NASM could pick
+	// a different encoding: call near 11804, jmp short label__12. The far pointers (call 123:456) are always far
+	ExplicitJumpSize bool
+
+	// ExplicitStringOperands writes the string instructions without the size suffix, with the implied operands:
+	// movs byte [di], [si] instead of movsb
+	ExplicitStringOperands bool
+
+	// LineEnding terminates every line of the output. "\n" by default
+	LineEnding string
+
+	// CommentPrefix starts the comments. Empty = the one of the Syntax: ";" for NASM, "#" for AT&T and objdump
+	CommentPrefix string
+
+	// HideComments drops the comments from the output
+	HideComments bool
+
+	// HexLabels names the labels after their hex offset: label_0x1a instead of label__26
+	HexLabels bool
+
+	// OrdinalLabels names the labels after their order in the code: label0, label1 etc.
+	// like the test_label0 of the course listings. Takes precedence over HexLabels
+	OrdinalLabels bool
+
+	// SignedImmediates writes the negative immediates of mov, the arithmetic instructions and ret as signed numbers:
+	// mov cx, -12 instead of mov cx, 65524. The logic instructions and the ports stay unsigned: and al, 239
+	SignedImmediates bool
+
+	// MinimalSizeKeywords writes the size keyword before the memory operand and only where NASM needs it:
+	// add byte [bx], 34, call [bp - 100] and jmp label__12 instead of add [bx], byte 34, call word [bp - 100]
+	// and jmp short label__12. NASM picks the short jmp itself when the target is close
+	MinimalSizeKeywords bool
+
+	// OmitBPDisplacement writes [bp] instead of [bp + 0]. Both reassemble to the same bytes,
+	// since [bp] always has the displacement
+	OmitBPDisplacement bool
+
+	// CourseMnemonics writes the mnemonics the course listings use: je, jne, jnl and jnb instead of jz, jnz, jge
+	// and jae, rep cmpsb instead of repz cmpsb
+	CourseMnemonics bool
+
+	// AnnotateCategory appends the encoding category of every instruction to its comment, see Instruction.Category
+	AnnotateCategory bool
+
+	// MaxInstructionSize rejects the instruction that consumes more bytes, including the prefixes.
+	// MaxInstructionSize by default; 0 = unlimited
+	MaxInstructionSize int
+
+	// Allow186 decodes the instructions the 80186 added: enter, leave, pusha, popa, bound and imul with the immediate.
+	// The 8086 rejects them. The Header has the cpu 186 directive
+	Allow186 bool
+
+	// Allow386Segments recognizes the fs: (0x64) and gs: (0x65) segment override prefixes of the 386,
+	// for the code assembled for the later CPUs. The 8086 doesn't have them
+	Allow386Segments bool
+
+	// PopCS decodes 0x0f as pop cs the way the 8086 does. The later CPUs use the byte as the two-byte opcode escape,
+	// so it's rejected by default
+	PopCS bool
+
+	// OnInstruction observes every instruction as soon as it's decoded, with its prefixes, including the bytes
+	// that failed to decode (db 0xNN). The first pass of WriteTo and the unreached bytes of RecursiveDescent aren't observed
+	OnInstruction func(offset int, instruction Instruction)
+
+	// Filter selects the instructions that are written by GetDecoded and WriteTo, e.g. only the mov instructions.
+	// The skipped instructions are still decoded, so the offsets and the labels don't change
+	Filter func(instruction Instruction) bool
+
+	// Indent is prepended to every instruction line, the labels stay at the column 0
+	Indent string
+
+	// Origin is the address the first byte is loaded at, e.g. 0x100 for a COM file.
+	// The labels and the near targets are the absolute addresses, and the NASM Header has the org directive.
+	// Instruction.Offset stays the position in the bytes
+	Origin int
 }
 
+// NewDecoder creates a decoder that reads the bytes in place, without copying them.
+// The decoder holds the reference, so the bytes must not be modified while it's in use, see NewDecoderCopy
 func NewDecoder(bytes []byte) *Decoder {
 	return &Decoder{
 		bytes:    bytes,
 		pos:      0,
 		segment:  "",
-		nodes:    make([]instructionNode, 0),
+		nodes:    make([]Instruction, 0),
 		labels:   make(map[int]string),
 		cacheKey: "",
 		decoded:  make([]byte, 0),
+
+		ShowSignedComment:  true,
+		LineEnding:         "\n",
+		MaxInstructionSize: MaxInstructionSize,
 	}
 }
 
-func (d *Decoder) appendInstruction(pos int, value string) {
-	n := instructionNode{
-		value: value,
-		pos:   pos,
-	}
+// NewDecoderCopy creates a decoder that reads its own copy of the bytes,
+// for the callers that reuse or modify their buffer
+func NewDecoderCopy(bytes []byte) *Decoder {
+	return NewDecoder(append([]byte(nil), bytes...))
+}
 
-	d.nodes = append(d.nodes, n)
+// CourseMode creates a decoder with the options the computer-enhance listings 0037-0042 are written with:
+// the NASM syntax without the comments, the signed immediates, the size keywords before the memory operands,
+// [bp] without the displacement, the mnemonics of the course and the numbered labels
+func CourseMode(bytes []byte) *Decoder {
+	d := NewDecoder(bytes)
+	d.Syntax = SyntaxIntel
+	d.HideComments = true
+	d.ShowSignedComment = false
+	d.HexLabels = false
+	d.OrdinalLabels = true
+	d.SignedImmediates = true
+	d.MinimalSizeKeywords = true
+	d.OmitBPDisplacement = true
+	d.CourseMnemonics = true
+	return d
 }
 
 func (d *Decoder) computeCacheKey() string {
-	return fmt.Sprintf("n=%d;l=%d", len(d.nodes), len(d.labels))
+	return fmt.Sprintf("n=%d;l=%d;o=%v;e=%q;i=%q;f=%p", len(d.nodes), len(d.labels), d.FormatOptions(), d.LineEnding, d.Indent, d.Filter)
+}
+
+// FormatOptions returns the options the decoder renders the instructions with
+func (d *Decoder) FormatOptions() FormatOptions {
+	return FormatOptions{
+		Syntax:        d.Syntax,
+		CommentPrefix: d.CommentPrefix,
+		HideComments:  d.HideComments,
+
+		HexLabels:        d.HexLabels,
+		AnnotateCategory: d.AnnotateCategory,
+		Origin:           d.Origin,
+		Labels:           d.ordinalLabels(),
+
+		SignedImmediates:    d.SignedImmediates,
+		MinimalSizeKeywords: d.MinimalSizeKeywords,
+		OmitBPDisplacement:  d.OmitBPDisplacement,
+		CourseMnemonics:     d.CourseMnemonics,
+	}
+}
+
+// ordinalLabels numbers the labels found so far in the order of their addresses, nil without OrdinalLabels
+func (d *Decoder) ordinalLabels() map[int]string {
+	if !d.OrdinalLabels {
+		return nil
+	}
+
+	offsets := make([]int, 0, len(d.labels))
+	for offset := range d.labels {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	labels := make(map[int]string, len(offsets))
+	for index, offset := range offsets {
+		labels[offset+d.Origin] = fmt.Sprintf("label%d", index)
+	}
+	return labels
+}
+
+// Labels returns the names of the jump targets found so far by their absolute address (Origin included)
+func (d *Decoder) Labels() map[int]string {
+	options := d.FormatOptions()
+	labels := make(map[int]string, len(d.labels))
+	for offset := range d.labels {
+		labels[offset+d.Origin] = options.labelName(offset + d.Origin)
+	}
+	return labels
+}
+
+// WriteMap writes the Labels as a map file for the debuggers: a "offset name" line per label,
+// the hex offset (Origin included) in the ascending order, e.g. 0103 label__259
+func (d *Decoder) WriteMap(w io.Writer) error {
+	labels := d.Labels()
+	offsets := make([]int, 0, len(labels))
+	for offset := range labels {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	for _, offset := range offsets {
+		if _, err := fmt.Fprintf(w, "%04x %s%s", offset, labels[offset], d.LineEnding); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Instructions returns the instructions decoded so far
+func (d *Decoder) Instructions() []Instruction {
+	return d.nodes
+}
+
+// IndexOf returns the index in Instructions() of the instruction that covers the offset, e.g. the one IP points at.
+// ok is false if the offset is outside of the decoded instructions
+func (d *Decoder) IndexOf(offset int) (index int, ok bool) {
+	// the instructions are sorted by the offset
+	index = sort.Search(len(d.nodes), func(i int) bool {
+		return d.nodes[i].Offset+d.nodes[i].Size > offset
+	})
+
+	if index == len(d.nodes) || d.nodes[index].Offset > offset {
+		return 0, false
+	}
+
+	return index, true
 }
 
 func (d *Decoder) GetDecoded() []byte {
@@ -198,407 +416,493 @@ func (d *Decoder) GetDecoded() []byte {
 	}
 
 	d.decoded = d.decoded[:0] // reuse the same array
+	options := d.FormatOptions()
 	for _, node := range d.nodes {
-		instruction := ""
-		label, ok := d.labels[node.pos-1] // as we start counting instructions from 1, instead of 0
-		if ok {
-			instruction += fmt.Sprintf("%s:\n", label)
+		d.decoded = append(d.decoded, []byte(d.lines(node, options))...)
+	}
+
+	d.cacheKey = cacheKey
+	return d.decoded
+}
+
+// Line is a line of the output together with the offset of the instruction it belongs to
+type Line struct {
+	Offset int
+	Text   string // without the line ending
+}
+
+// Lines returns the output of GetDecoded line by line, so every line can be mapped back to the bytes.
+// The label line has the offset of the instruction it points to
+func (d *Decoder) Lines() []Line {
+	lines := make([]Line, 0, len(d.nodes))
+	options := d.FormatOptions()
+	for _, node := range d.nodes {
+		lines = append(lines, d.nodeLines(node, options)...)
+	}
+
+	return lines
+}
+
+// lines renders the instruction together with the label that points to it.
+// Empty if the Filter skips the instruction
+func (d *Decoder) lines(node Instruction, options FormatOptions) string {
+	text := ""
+	for _, line := range d.nodeLines(node, options) {
+		text += line.Text + d.LineEnding
+	}
+
+	return text
+}
+
+func (d *Decoder) nodeLines(node Instruction, options FormatOptions) []Line {
+	if d.Filter != nil && !d.Filter(node) {
+		return nil
+	}
+
+	lines := make([]Line, 0, 2)
+	// objdump has the absolute targets instead of the labels
+	if _, ok := d.labels[node.Offset]; ok && d.Syntax != SyntaxObjdump {
+		lines = append(lines, Line{Offset: node.Offset, Text: options.labelName(node.Offset+d.Origin) + ":"})
+	}
+
+	return append(lines, Line{Offset: node.Offset, Text: d.Indent + Format(node, options)})
+}
+
+// WriteTo decodes the bytes and writes the text of every instruction to w as soon as it's decoded,
+// without keeping the instructions or the output in memory. The bytes are decoded twice:
+// the first pass only collects the labels, since a jump can point backwards.
+// The instructions aren't added to Instructions(). RecursiveDescent isn't supported
+func (d *Decoder) WriteTo(w io.Writer) (int64, error) {
+	if d.RecursiveDescent {
+		return 0, fmt.Errorf("the recursive descent can't be streamed, use Decode instead")
+	}
+
+	pass := func(emit func(instruction Instruction) error) error {
+		d.pos = 0
+		d.resyncing = false
+		for count := 0; d.Limit == 0 || count < d.Limit; count++ {
+			instruction, ok, err := d.decodeNext()
+			if err != nil {
+				return err
+			}
+			if ok == false {
+				break
+			}
+
+			if err := emit(instruction); err != nil {
+				return err
+			}
 		}
 
-		instruction += node.value
-		d.decoded = append(d.decoded, []byte(instruction)...)
+		return nil
+	}
 
+	onInstruction := d.OnInstruction
+	d.OnInstruction = nil
+	err := pass(func(instruction Instruction) error { return nil })
+	d.OnInstruction = onInstruction
+	if err != nil {
+		return 0, err
 	}
 
-	d.cacheKey = cacheKey
-	return d.decoded
+	// the labels are all known after the first pass
+	options := d.FormatOptions()
+	written := int64(0)
+	err = pass(func(instruction Instruction) error {
+		n, err := io.WriteString(w, d.lines(instruction, options))
+		written += int64(n)
+		return err
+	})
+
+	return written, err
+}
+
+// Header returns the lines that precede the instructions: the filename as a comment and the 16-bit mode directive
+// of the Syntax (none for objdump), followed by an empty line. The comment is omitted if the filename is empty or the comments are hidden
+func (d *Decoder) Header(filename string) string {
+	header := ""
+	if filename != "" && !d.HideComments {
+		commentPrefix := d.CommentPrefix
+		if commentPrefix == "" && (d.Syntax == SyntaxATT || d.Syntax == SyntaxObjdump) {
+			commentPrefix = "#"
+		} else if commentPrefix == "" {
+			commentPrefix = ";"
+		}
+
+		header += commentPrefix + " " + filename + d.LineEnding
+	}
+
+	if d.Syntax == SyntaxATT {
+		header += ".code16" + d.LineEnding
+	} else if d.Syntax != SyntaxObjdump {
+		header += "bits 16" + d.LineEnding
+		if d.Allow186 {
+			header += "cpu 186" + d.LineEnding
+		}
+		if d.Origin != 0 {
+			header += fmt.Sprintf("org 0x%x", d.Origin) + d.LineEnding
+		}
+	}
+
+	return header + d.LineEnding
 }
 
 func (d *Decoder) Decode() ([]byte, error) {
+	if d.RecursiveDescent {
+		if err := d.decodeRecursively(); err != nil {
+			return nil, err
+		}
+
+		return d.GetDecoded(), nil
+	}
+
 	d.pos = 0
+	count := 0
 	for {
-		// Section 2.7 Instruction set. p. 2-30
-		instruction := ""
-		prefix := ""
+		if d.Limit > 0 && count >= d.Limit {
+			break
+		}
 
-		var err error
-		operation, ok := d.next()
+		instruction, ok, err := d.decodeNext()
+		if err != nil {
+			return nil, err
+		}
 		if ok == false {
 			// TODO: return EOF
 			break
 		}
-		instructionPointer := d.pos
-
-		// Prefix
-		switch {
-		case d.matchPattern("LOCK: Bus lock prefix", operation, "0b11110000"):
-			prefix = "lock "
-		case d.matchPattern("REP: Repeat", operation, "0b1111001z"):
-			prefix = repeatPrefix(operation, d) + " "
-		}
 
-		if prefix != "" {
-			operation, ok = d.next()
-			if ok == false {
-				// TODO: return EOF
-				break
+		d.nodes = append(d.nodes, instruction)
+		// the prefixes are a part of the instruction, so they don't count against the limit
+		count += 1
+	}
+
+	return d.GetDecoded(), nil
+}
+
+// DecodeError is the instruction that failed to decode at the offset
+type DecodeError struct {
+	Offset   int
+	Consumed []byte // the bytes of the instruction read before the failure, prefixes included
+	Err      error
+}
+
+func (e DecodeError) Error() string {
+	return fmt.Sprintf("at offset 0x%x: %v", e.Offset, e.Err)
+}
+
+func (e DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeAll decodes the bytes linearly like Decode, but doesn't stop at the first error:
+// the byte the failed instruction starts with is emitted as data (db 0xNN), and the decoding resyncs
+// the way it does with Resync. The errors are returned in the order of the offsets.
+// The instructions decoded before are replaced, like with Decode on a new decoder
+func (d *Decoder) DecodeAll() ([]Instruction, []DecodeError) {
+	decodeErrors := make([]DecodeError, 0)
+	resync := d.Resync
+	d.decodeErrors, d.Resync = &decodeErrors, true
+	defer func() {
+		d.decodeErrors, d.Resync = nil, resync
+	}()
+
+	d.nodes = nil
+	d.pos = 0
+	d.resyncing = false
+	for count := 0; d.Limit == 0 || count < d.Limit; count++ {
+		start := d.pos
+		instruction, ok, err := d.decodeNext()
+		if err != nil {
+			// Resync turns the decoding errors into data, the rest is collected the same way
+			var decodeError DecodeError
+			if !errors.As(err, &decodeError) {
+				decodeError = d.decodeError(start, err)
 			}
-			if instructionPointer != instructionPointer {
-				panic("Assertion Failed: The instruction pointer must not be updated when handling prefixes")
+			decodeErrors = append(decodeErrors, decodeError)
+			instruction, ok = d.emitData(start, nil), true
+		}
+		if ok == false {
+			break
+		}
+
+		d.nodes = append(d.nodes, instruction)
+	}
+
+	return d.nodes, decodeErrors
+}
+
+// ErrUnknownOperation is reported for the byte that isn't the operation of any instruction the decoder knows,
+// e.g. the data or a jump into the middle of an instruction
+var ErrUnknownOperation = errors.New("unknown operation")
+
+// Next decodes the instruction at the current position and moves past it, without adding it to Instructions().
+// ok is false when there are no more bytes to decode
+func (d *Decoder) Next() (instruction Instruction, ok bool, err error) {
+	return d.decodeNext()
+}
+
+// DecodeAt decodes the instruction that starts at the offset, prefixes included, without adding it to Instructions().
+// The offset may be in the middle of the instructions decoded before, e.g. the target of a jump into the shared code.
+// The position is left after the instruction. io.EOF is reported at the end of the bytes
+func (d *Decoder) DecodeAt(offset int) (Instruction, error) {
+	if offset < 0 || offset > len(d.bytes) {
+		return Instruction{}, fmt.Errorf("the offset 0x%x is outside of the 0x%x bytes", offset, len(d.bytes))
+	}
+
+	d.pos = offset
+	d.resyncing = false
+	instruction, ok, err := d.decodeNext()
+	if err != nil {
+		return Instruction{}, err
+	}
+	if ok == false {
+		return Instruction{}, io.EOF
+	}
+
+	return instruction, nil
+}
+
+// ErrSplitInstruction is reported by DecodeRange when the end of the range is in the middle of an instruction
+var ErrSplitInstruction = errors.New("the instruction crosses the end of the range")
+
+// DecodeRange decodes the bytes [start, end) without adding them to Instructions(). The offsets are relative to
+// the whole buffer. The instruction that crosses the end isn't returned, ErrSplitInstruction is reported instead
+// together with the instructions before it. The position is left at the end of the last returned instruction
+func (d *Decoder) DecodeRange(start, end int) ([]Instruction, error) {
+	if start < 0 || end > len(d.bytes) || start > end {
+		return nil, fmt.Errorf("the range [0x%x, 0x%x) is outside of the 0x%x bytes", start, end, len(d.bytes))
+	}
+
+	d.pos = start
+	d.resyncing = false
+	instructions := make([]Instruction, 0)
+	for d.pos < end {
+		if d.Limit > 0 && len(instructions) >= d.Limit {
+			break
+		}
+
+		position := d.pos
+		instruction, ok, err := d.decodeNext()
+		if err != nil {
+			d.pos = position
+			return instructions, err
+		}
+		if ok == false {
+			break
+		}
+
+		if instruction.Offset+instruction.Size > end {
+			d.pos = position
+			return instructions, fmt.Errorf("at offset 0x%x: %w", instruction.Offset, ErrSplitInstruction)
+		}
+
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, nil
+}
+
+// decodeNext decodes the instruction at the current position and passes it to OnInstruction.
+// ok is false when there are no more bytes to decode
+func (d *Decoder) decodeNext() (instruction Instruction, ok bool, err error) {
+	instruction, ok, err = d.decodeInstruction()
+	if ok && err == nil && d.OnInstruction != nil {
+		d.OnInstruction(instruction.Offset, instruction)
+	}
+
+	return instruction, ok, err
+}
+
+// decodeInstruction decodes the instruction at the current position.
+// ok is false when there are no more bytes to decode
+func (d *Decoder) decodeInstruction() (instruction Instruction, ok bool, err error) {
+	// Section 2.7 Instruction set. p. 2-30
+	start := d.pos
+	prefix := ""
+
+	if d.resyncing && start < len(d.bytes) {
+		if !d.plausibleBoundary(start) {
+			return d.emitData(start, nil), true, nil
+		}
+		d.resyncing = false
+	}
+
+	operation, ok := d.next()
+	if ok == false {
+		return Instruction{}, false, nil
+	}
+	instructionPointer := d.pos
+
+	// Prefix
+	switch {
+	case d.matchPattern("LOCK: Bus lock prefix", operation, "0b11110000"):
+		prefix = "lock"
+	case d.matchPattern("REP: Repeat", operation, "0b1111001z"):
+		prefix = repeatPrefix(operation, d)
+	}
+	d.prefix = prefix
+
+	if prefix != "" {
+		// the prefix handlers only peek at the next byte
+		if d.pos != instructionPointer {
+			panic(fmt.Errorf("AssertionError: the instruction pointer must not be updated when handling prefixes, moved from %d to %d", instructionPointer, d.pos))
+		}
+		operation, ok = d.next()
+		if ok == false {
+			return d.truncated(start)
+		}
+	}
+
+	if d.isSegmentOverride(operation) {
+		d.segment = segmentPrefix(operation, d)
+		operation, ok = d.next()
+		if ok == false {
+			return d.truncated(start)
+		}
+
+		// the 8086 would apply the last one, but the assembler can't express it
+		if d.isSegmentOverride(operation) {
+			err := fmt.Errorf("expected a single segment override prefix, got '%s' followed by '%s'", d.segment, segmentPrefix(operation, d))
+			if d.EmitDataOnError || d.Resync {
+				return d.emitData(start, err), true, nil
 			}
+			return Instruction{}, false, d.decodeError(start, err)
 		}
+	} else {
+		d.segment = ""
+	}
 
-		if d.matchPattern("SEGMENT: override prefix", operation, "0b001__110") {
-			d.segment = segmentPrefix(operation, d)
-			operation, ok = d.next()
-			if ok == false {
-				// TODO: return EOF
+	matched := false
+	category := ""
+	if override, ok := d.overrides[operation]; ok {
+		instruction, err = override(operation, d)
+		matched = true
+		category = fmt.Sprintf("OVERRIDE: %.8b", operation)
+	} else {
+		for _, op := range opcodes {
+			if d.matchPattern(op.name, operation, op.pattern) {
+				instruction, err = op.handler(operation, d)
+				matched = true
+				category = op.name
 				break
 			}
-		} else {
-			d.segment = ""
 		}
+	}
 
-		// Table 4-12. 8086 Instruction Encoding
-		switch {
-		// MOV = Move
-		case d.matchPattern("MOV: Register/memory to/from register", operation, "0b100010dw"):
-			instruction, err = moveRegMemToReg(operation, d)
-		case d.matchPattern("MOV: Immediate to register/memory", operation, "0b1100011w"):
-			instruction, err = moveImmediateToRegOrMem(operation, d)
-		case d.matchPattern("MOV: Immediate to register", operation, "0b1011wreg"):
-			instruction, err = moveImmediateToReg(operation, d)
-		case d.matchPattern("MOV: Memory to accumulator", operation, "0b1010000w"):
-			instruction, err = moveMemoryToAccumulator(operation, d)
-		case d.matchPattern("MOV: Accumulator to memory", operation, "0b1010001w"):
-			instruction, err = moveAccumulatorToMemory(operation, d)
-		case d.matchPattern("MOV: Register/memory to segment register", operation, "0b10001110|0b__0_____"):
-			instruction, err = moveRegOrMemToSegment(operation, d)
-		case d.matchPattern("MOV: Segment register to register/memory", operation, "0b10001100|0b__0_____"):
-			instruction, err = moveSegmentToRegOrMem(operation, d)
-
-		// PUSH
-		case d.matchPattern("PUSH: Register/memory", operation, "0b11111111|0b__110___"):
-			instruction, err = pushRegOrMem(operation, d)
-		case d.matchPattern("PUSH: Register", operation, "0b01010reg"):
-			instruction, err = pushReg(operation, d)
-		case d.matchPattern("PUSH: segment register", operation, "0b000__110"):
-			instruction, err = pushSegmentReg(operation, d)
-
-		// POP
-		case d.matchPattern("POP: Register/memory", operation, "0b10001111|0b__000___"):
-			instruction, err = popRegOrMem(operation, d)
-		case d.matchPattern("POP: Register", operation, "0b01011reg"):
-			instruction, err = popReg(operation, d)
-		case d.matchPattern("POP: segment register", operation, "0b000__111"):
-			instruction, err = popSegmentReg(operation, d)
-
-		// XCHG = Exchange
-		case d.matchPattern("XCHG: Register/memory with register", operation, "0b1000011w"):
-			instruction, err = exchangeRegOrMemWithReg(operation, d)
-		case d.matchPattern("XCHG: register with accumulator", operation, "0b10010reg"):
-			instruction, err = exchangeRegWithAccumulator(operation, d)
-
-		// IN = Input from
-		case d.matchPattern("IN: Fixed port", operation, "0b1110010w"):
-			instruction, err = inputFromFixedPort(operation, d)
-		case d.matchPattern("IN: Variable port", operation, "0b1110110w"):
-			instruction, err = inputFromVariablePort(operation, d)
-
-		// OUT = Output to
-		case d.matchPattern("OUT: Fixed port", operation, "0b1110011w"):
-			instruction, err = outputToFixedPort(operation, d)
-		case d.matchPattern("OUT: Variable port", operation, "0b1110111w"):
-			instruction, err = outputToVariablePort(operation, d)
-
-		case d.matchPattern("XLAT - Translate byte to AL", operation, "0b11010111"):
-			instruction, err = xlat(operation, d)
-
-		// Address Object Transfers
-		case d.matchPattern("LEA - Load effective address to register", operation, "0b10001101"):
-			instruction, err = lea(operation, d)
-		case d.matchPattern("LDS - Load pointer to DS", operation, "0b11000101"):
-			instruction, err = lds(operation, d)
-		case d.matchPattern("LES - Load pointer to ES", operation, "0b11000100"):
-			instruction, err = les(operation, d)
-
-		// Flag Transfers
-		case d.matchPattern("LAHF - Load AH with flags", operation, "0b10011111"):
-			instruction, err = lahf(operation, d)
-		case d.matchPattern("SAHF - Store AH into flags", operation, "0b10011110"):
-			instruction, err = sahf(operation, d)
-		case d.matchPattern("PUSHF - Push flags", operation, "0b10011100"):
-			instruction, err = pushf(operation, d)
-		case d.matchPattern("POPF - Pop flags", operation, "0b10011101"):
-			instruction, err = popf(operation, d)
-
-		// ADD
-		case d.matchPattern("ADD: Reg/memory with register to either", operation, "0b000000dw"):
-			instruction, err = addRegOrMemToReg(operation, d)
-		case d.matchPattern("ADD: Immediate to register/memory", operation, "0b100000sw|0b__000___"):
-			instruction, err = addImmediateToRegOrMem(operation, d)
-		case d.matchPattern("ADD: Immediate to accumulator", operation, "0b0000010w"):
-			instruction, err = addImmediateToAccumulator(operation, d)
-
-		// ADC = Add with carry
-		case d.matchPattern("ADC: Reg/memory with register to either", operation, "0b000100dw"):
-			instruction, err = adcRegOrMemToReg(operation, d)
-		case d.matchPattern("ADC: Immediate to register/memory", operation, "0b100000sw|0b__010___"):
-			instruction, err = adcImmediateToRegOrMem(operation, d)
-		case d.matchPattern("ADC: Immediate to accumulator", operation, "0b0001010w"):
-			instruction, err = adcImmediateToAccumulator(operation, d)
-
-		// INC = Increment
-		case d.matchPattern("INC: Register/memory", operation, "0b1111111w|0b__000___"):
-			instruction, err = incRegOrMem(operation, d)
-		case d.matchPattern("INC: Register", operation, "0b01000reg"):
-			instruction, err = incReg(operation, d)
-
-		case d.matchPattern("AAA: ASCII adjust for add", operation, "0b00110111"):
-			instruction, err = aaa(operation, d)
-		case d.matchPattern("DAA: Decimal adjust for add", operation, "0b00100111"):
-			instruction, err = daa(operation, d)
-
-		// SUB = Subtract
-		case d.matchPattern("SUB: Reg/memory and register to either", operation, "0b001010dw"):
-			instruction, err = subRegOrMemFromReg(operation, d)
-		case d.matchPattern("SUB: Immediate to register/memory", operation, "0b100000sw|0b__101___"):
-			instruction, err = subImmediateFromRegOrMem(operation, d)
-		case d.matchPattern("SUB: Immediate from accumulator", operation, "0b0010110w"):
-			instruction, err = subImmediateFromAccumulator(operation, d)
-
-		// SBB = Subtract with borrow
-		case d.matchPattern("SBB: Reg/memory and register to either", operation, "0b000110dw"):
-			instruction, err = sbbRegOrMemFromReg(operation, d)
-		case d.matchPattern("SBB: Immediate to register/memory", operation, "0b100000sw|0b__011___"):
-			instruction, err = sbbImmediateFromRegOrMem(operation, d)
-		case d.matchPattern("SBB: Immediate from accumulator", operation, "0b0001110w"):
-			instruction, err = sbbImmediateFromAccumulator(operation, d)
-
-		// DEC = Decrement
-		case d.matchPattern("DEC: Register/memory", operation, "0b1111111w|0b__001___"):
-			instruction, err = decRegOrMem(operation, d)
-		case d.matchPattern("DEC: Register", operation, "0b01001reg"):
-			instruction, err = decReg(operation, d)
-
-		case d.matchPattern("NEG: Change sign", operation, "0b1111011w|0b__011___"):
-			instruction, err = neg(operation, d)
-
-		// CMP = Compare
-		case d.matchPattern("CMP: Reg/memory and register", operation, "0b001110dw"):
-			instruction, err = cmpRegOrMemWithReg(operation, d)
-		case d.matchPattern("CMP: Immediate with register/memory", operation, "0b100000sw|0b__111___"):
-			instruction, err = cmpImmediateWithRegOrMem(operation, d)
-		case d.matchPattern("CMP: Immediate from accumulator", operation, "0b0011110w"):
-			instruction, err = cmpImmediateWithAccumulator(operation, d)
-
-		case d.matchPattern("AAS: ASCII adjust for subtract", operation, "0b00111111"):
-			instruction, err = aas(operation, d)
-		case d.matchPattern("DAS: decimal adjust for subtract", operation, "0b00101111"):
-			instruction, err = das(operation, d)
-
-		case d.matchPattern("MUL: Unsigned multiply", operation, "0b1111011w|0b__100___"):
-			instruction, err = mul(operation, d)
-		case d.matchPattern("IMUL: Signed multiply", operation, "0b1111011w|0b__101___"):
-			instruction, err = imul(operation, d)
-		case d.matchPattern("AAM: ASCII adjust for multiply", operation, "0b11010100|0b00001010"):
-			instruction, err = aam(operation, d)
-
-		case d.matchPattern("DIV: Unsigned divide", operation, "0b1111011w|0b__110___"):
-			instruction, err = div(operation, d)
-		case d.matchPattern("IDIV: Signed divide", operation, "0b1111011w|0b__111___"):
-			instruction, err = idiv(operation, d)
-		case d.matchPattern("AAD: ASCII adjust for divide", operation, "0b11010101|0b00001010"):
-			instruction, err = aad(operation, d)
-		case d.matchPattern("CBW: convert byte to word", operation, "0b10011000"):
-			instruction, err = cbw(operation, d)
-		case d.matchPattern("CWD: convert word to double word", operation, "0b10011001"):
-			instruction, err = cwd(operation, d)
-
-		// LOGIC
-		case d.matchPattern("NOT: Invert", operation, "0b1111011w|0b__010___"):
-			instruction, err = not(operation, d)
-		case d.matchPattern("SHL/SAL: Shift logical/arithmetic left", operation, "0b110100vw|0b__100___"):
-			instruction, err = shl(operation, d)
-		case d.matchPattern("SHR: Shift logical right", operation, "0b110100vw|0b__101___"):
-			instruction, err = shr(operation, d)
-		case d.matchPattern("SAR: Shift arithmetic right", operation, "0b110100vw|0b__111___"):
-			instruction, err = sar(operation, d)
-		case d.matchPattern("ROL: Rotate left", operation, "0b110100vw|0b__000___"):
-			instruction, err = rol(operation, d)
-		case d.matchPattern("ROR: Rotate right", operation, "0b110100vw|0b__001___"):
-			instruction, err = ror(operation, d)
-		case d.matchPattern("RCL: Rotate through carry left", operation, "0b110100vw|0b__010___"):
-			instruction, err = rcl(operation, d)
-		case d.matchPattern("RCR: Rotate through carry right", operation, "0b110100vw|0b__011___"):
-			instruction, err = rcr(operation, d)
-
-		// AND
-		case d.matchPattern("AND: Logical AND reg/mem with reg", operation, "0b001000dw"):
-			instruction, err = andRegOrMemWithReg(operation, d)
-		case d.matchPattern("AND: Logical AND immediate with reg/mem", operation, "0b1000000w|0b__100___"):
-			instruction, err = andImmediateWithRegOrMem(operation, d)
-		case d.matchPattern("AND: Logical AND immediate with accumulator", operation, "0b0010010w"):
-			instruction, err = andImmediateWithAccumulator(operation, d)
-
-		// TEST
-		case d.matchPattern("TEST: Logical compare reg/mem with reg", operation, "0b100001dw"): // NOTE(Kostia): for some reason, the "Instruction reference" says that test is [000100|d|w], but when using nasm v2.16.03, the opcode is different. Moreover, the table 4-13 aligns with the nasm, but 4-12 doesn't
-			instruction, err = testRegOrMemWithReg(operation, d)
-		case d.matchPattern("TEST: Logical compare immediate with reg/mem", operation, "0b1111011w|0b__000___"):
-			instruction, err = testImmediateWithRegOrMem(operation, d)
-		case d.matchPattern("TEST: Logical compare immediate with accumulator", operation, "0b1010100w"):
-			instruction, err = testImmediateWithAccumulator(operation, d)
-
-		// OR
-		case d.matchPattern("OR: Logical OR reg/mem with reg", operation, "0b000010dw"):
-			instruction, err = orRegOrMemWithReg(operation, d)
-		case d.matchPattern("OR: Logical OR immediate with reg/mem", operation, "0b1000000w|0b__001___"):
-			instruction, err = orImmediateWithRegOrMem(operation, d)
-		case d.matchPattern("OR: Logical OR immediate with accumulator", operation, "0b0000110w"):
-			instruction, err = orImmediateWithAccumulator(operation, d)
-
-		// XOR
-		case d.matchPattern("XOR: Logical XOR reg/mem with reg", operation, "0b001100dw"):
-			instruction, err = xorRegOrMemWithReg(operation, d)
-		case d.matchPattern("XOR: Logical XOR immediate with reg/mem", operation, "0b1000000w|0b__110___"): // NOTE(Kostia): for some reason, the "Instruction reference" says that xor is [0011010|w] [data] [disp-lo?] [disp-hi?] [data] [data if w=1], but when using nasm v2.16.03, the opcode is different and the [data] seems to be wrong. Moreover, the table 4-13 aligns with the nasm, but 4-12 doesn't
-			instruction, err = xorImmediateWithRegOrMem(operation, d)
-		case d.matchPattern("XOR: Logical XOR immediate with accumulator", operation, "0b0011010w"):
-			instruction, err = xorImmediateWithAccumulator(operation, d)
-
-		// STRING
-		case d.matchPattern("MOVS: move byte/word", operation, "0b1010010w"):
-			instruction, err = movs(operation, d)
-		case d.matchPattern("CMPS: compare byte/word", operation, "0b1010011w"):
-			instruction, err = cmps(operation, d)
-		case d.matchPattern("SCAS: scan byte/word", operation, "0b1010111w"):
-			instruction, err = scas(operation, d)
-		case d.matchPattern("LODS: load byte/word", operation, "0b1010110w"):
-			instruction, err = lods(operation, d)
-		case d.matchPattern("STOS: store byte/word", operation, "0b1010101w"):
-			instruction, err = stos(operation, d)
-
-		// CALL
-		case d.matchPattern("CALL: Direct within segment", operation, "0b11101000"):
-			instruction, err = callDirectWithinSegment(operation, d)
-		case d.matchPattern("CALL: Indirect within segment", operation, "0b11111111|0b__010___"):
-			instruction, err = callIndirectWithinSegment(operation, d)
-		case d.matchPattern("CALL: Direct intersegment", operation, "0b10011010"):
-			instruction, err = callDirectIntersegment(operation, d)
-		case d.matchPattern("CALL: Indirect intersegment", operation, "0b11111111|0b__011___"):
-			instruction, err = callIndirectIntersegment(operation, d)
-
-		// JMP = Unconditional jump
-		case d.matchPattern("JMP: Direct within segment", operation, "0b11101001"):
-			instruction, err = jumpDirectWithinSegment(operation, d)
-		case d.matchPattern("JMP: Direct within segment-short", operation, "0b11101011"):
-			instruction, err = jumpDirectWithinSegmentShort(operation, d)
-		case d.matchPattern("JMP: Indirect within segment", operation, "0b11111111|0b__100___"):
-			instruction, err = jumpIndirectWithinSegment(operation, d)
-		case d.matchPattern("JMP: Direct intersegment", operation, "0b11101010"):
-			instruction, err = jumpDirectIntersegment(operation, d)
-		case d.matchPattern("JMP: Indirect intersegment", operation, "0b11111111|0b__101___"):
-			instruction, err = jumpIndirectIntersegment(operation, d)
-
-		// RET = Return from CALL
-		case d.matchPattern("RET: Within segment", operation, "0b11000011"):
-			instruction, err = returnWithinSegment(operation, d)
-		case d.matchPattern("RET: Within seg adding immed to SP", operation, "0b11000010"):
-			instruction, err = returnWithinSegmentAddingImmedToSP(operation, d)
-		case d.matchPattern("RET: Intersegment", operation, "0b11001011"):
-			instruction, err = returnIntersegment(operation, d)
-		case d.matchPattern("RET: Intersegment adding immed to SP", operation, "0b11001010"):
-			instruction, err = returnIntersegmentAddingImmedToSP(operation, d)
-
-		// Jumps
-		case d.matchPattern("JE/JZ: Jump on equal/zero", operation, "0b01110100"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JL/JNGE: Jump on less/not greater or equal", operation, "0b01111100"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JLE/JNG: Jump on less or equal/not greater", operation, "0b01111110"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JB/JNAE: Jump on below/not above or equal", operation, "0b01110010"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JBE/JNA: Jump on below or equal/not above", operation, "0b01110110"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JP/JPE: Jump on parity/even", operation, "0b01111010"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JO: Jump on overflow", operation, "0b01110000"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JS: Jump on sign", operation, "0b01111000"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JNE/JNZ: Jump on not equal/not zero", operation, "0b01110101"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JNL/JGE: Jump on not less/greater or equal", operation, "0b01111101"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JNLE/JG: Jump on not less nor equal/greater", operation, "0b01111111"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JNB/JAE: Jump on not below/above or equal", operation, "0b01110011"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JNBE/JA: Jump on not below nor equal/above", operation, "0b01110111"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JNP/JPO: Jump on not parity/odd", operation, "0b01111011"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JNO: Jump on not overflow", operation, "0b01110001"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JNS: Jump on not sign", operation, "0b01111001"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("JCXZ: Jump if CX register is zero", operation, "0b11100011"):
-			instruction, err = jumpConditionally(operation, d)
-
-		// Loops
-		case d.matchPattern("LOOP: Loop CX times", operation, "0b11100010"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("LOOPZ/LOOPE: Loop while zero/equal", operation, "0b11100001"):
-			instruction, err = jumpConditionally(operation, d)
-		case d.matchPattern("LOOPNZ/LOOPNE: Loop while not zero/not equal", operation, "0b11100000"):
-			instruction, err = jumpConditionally(operation, d)
-
-		// Interrupts
-		case d.matchPattern("INT: Type specified", operation, "0b11001101"):
-			instruction, err = interruptWithType(operation, d)
-		case d.matchPattern("INT: type 3", operation, "0b11001100"):
-			instruction, err = interruptType3(operation, d) // Breakpoint
-		case d.matchPattern("INTO: interrupt on overflow", operation, "0b11001110"):
-			instruction, err = interruptOnOverflow(operation, d)
-		case d.matchPattern("IRET: Interrupt return", operation, "0b11001111"):
-			instruction, err = interruptReturn(operation, d)
-
-		// Processor control
-		case d.matchPattern("CLC: Clear carry", operation, "0b11111000"):
-			instruction, err = clc(operation, d)
-		case d.matchPattern("CMC: Complement carry", operation, "0b11110101"):
-			instruction, err = cmc(operation, d)
-		case d.matchPattern("STC: Set carry", operation, "0b11111001"):
-			instruction, err = stc(operation, d)
-		case d.matchPattern("CLD: Clear direction", operation, "0b11111100"):
-			instruction, err = cld(operation, d)
-		case d.matchPattern("STD: set direction", operation, "0b11111101"):
-			instruction, err = std(operation, d)
-		case d.matchPattern("CLI: Clear interrupt", operation, "0b11111010"):
-			instruction, err = cli(operation, d)
-		case d.matchPattern("STI: Set interrupt", operation, "0b11111011"):
-			instruction, err = sti(operation, d)
-		case d.matchPattern("HLT: Halt", operation, "0b11110100"):
-			instruction, err = hlt(operation, d)
-		case d.matchPattern("WAIT: Wait", operation, "0b10011011"):
-			instruction, err = wait(operation, d)
-
-		default:
-			panic(fmt.Sprintf("AssertionError: unexpected operation %b", int(operation)))
+	if !matched {
+		err := fmt.Errorf("%w %.8b", ErrUnknownOperation, operation)
+		if d.EmitDataOnError || d.Resync {
+			return d.emitData(start, err), true, nil
 		}
+		return Instruction{}, false, d.decodeError(start, err)
+	}
 
-		if err != nil {
-			return nil, err
+	if err == nil && d.MaxInstructionSize > 0 && d.pos-start > d.MaxInstructionSize {
+		err = fmt.Errorf("the '%s' instruction is %d bytes long, expected at most %d", category, d.pos-start, d.MaxInstructionSize)
+	}
+
+	if err != nil {
+		if d.EmitDataOnError || d.Resync {
+			return d.emitData(start, err), true, nil
 		}
+		return Instruction{}, false, d.decodeError(start, err)
+	}
+
+	instruction.Offset = start
+	instruction.Size = d.pos - start
+	instruction.Prefix = prefix
+	instruction.Category = category
 
-		if prefix != "" {
-			instruction = prefix + instruction
+	return instruction, true, nil
+}
+
+// isSegmentOverride reports whether the byte is the segment override prefix: [001|reg|110], or fs/gs with Allow386Segments
+func (d *Decoder) isSegmentOverride(operation byte) bool {
+	if d.Allow386Segments && (operation == 0b01100100 || operation == 0b01100101) {
+		return true
+	}
+
+	return d.matchPattern("SEGMENT: override prefix", operation, "0b001__110")
+}
+
+// truncated handles the prefixes at the end of the stream
+func (d *Decoder) truncated(start int) (Instruction, bool, error) {
+	if d.EmitDataOnError || d.Resync {
+		return d.emitData(start, fmt.Errorf("expected an instruction after the prefix")), true, nil
+	}
+
+	return Instruction{}, false, nil
+}
+
+// decodeError is the failure of the instruction that starts at the offset, with the bytes consumed up to the position
+func (d *Decoder) decodeError(start int, err error) DecodeError {
+	return DecodeError{Offset: start, Consumed: append([]byte(nil), d.bytes[start:d.pos]...), Err: err}
+}
+
+// emitData turns the first byte of the instruction that failed to decode into data
+// and continues the decoding from the next byte. err is why the instruction failed, nil while resyncing
+func (d *Decoder) emitData(start int, err error) Instruction {
+	if err != nil && d.decodeErrors != nil {
+		*d.decodeErrors = append(*d.decodeErrors, d.decodeError(start, err))
+	}
+
+	d.pos = start + 1
+	d.resyncing = d.Resync
+	return dataInstruction(start, d.bytes[start])
+}
+
+// resyncWindow is the number of instructions that must decode from a position after an error
+// for it to be considered an instruction boundary
+const resyncWindow = 3
+
+// plausibleBoundary reports whether the decoding from the position yields resyncWindow instructions
+// (or the instructions up to the end of the binary) without errors
+func (d *Decoder) plausibleBoundary(pos int) bool {
+	probe := &Decoder{
+		bytes:           d.bytes,
+		pos:             pos,
+		labels:          make(map[int]string),
+		Strict:          d.Strict,
+		EmitDataOnError: true,
+
+		Allow186:         d.Allow186,
+		Allow386Segments: d.Allow386Segments,
+		PopCS:            d.PopCS,
+		overrides:        d.overrides,
+	}
+
+	for range resyncWindow {
+		instruction, ok, _ := probe.decodeNext()
+		if ok == false {
+			return true
+		}
+		if instruction.Mnemonic == "db" {
+			return false
 		}
+	}
 
-		d.appendInstruction(instructionPointer, instruction)
+	return true
+}
+
+// Override decodes the operation byte with the handler instead of the instruction table,
+// e.g. to add the instructions the decoder doesn't support. The overrides take precedence over the built-in opcodes
+// and are consulted before the operation is reported as unknown. The prefixes are handled before the handler is called.
+// The handler reads the rest of the instruction with ReadByte
+func (d *Decoder) Override(operation byte, decode func(operation byte, d *Decoder) (Instruction, error)) {
+	if d.overrides == nil {
+		d.overrides = make(map[byte]handler)
 	}
+	d.overrides[operation] = decode
+}
 
-	return d.GetDecoded(), nil
+// ReadByte consumes the next byte of the instruction being decoded, io.EOF at the end of the bytes
+func (d *Decoder) ReadByte() (byte, error) {
+	b, ok := d.next()
+	if ok == false {
+		return 0, io.EOF
+	}
+	return b, nil
 }
 
 func (d *Decoder) next() (byte, bool) {
@@ -684,102 +988,27 @@ func (d *Decoder) matchPattern(name string, candidate byte, pattern string) bool
 }
 
 // [mod|reg|r/m]
-func (d *Decoder) decodeBinaryRegOrMem(instructionName string, mod byte, regName string, rm byte, isWord bool, dir byte) (dest string, src string, err error) {
+func (d *Decoder) decodeBinaryRegOrMem(instructionName string, mod byte, regName string, rm byte, isWord bool, dir byte) (dest Operand, src Operand, err error) {
 	verifyDirection(dir)
 
 	// MOV dest, src
 	// ADD dest, src
-	dest = ""
-	src = ""
-
-	switch mod {
-	case MemoryModeNoDisplacementFieldEncoding:
-		displacementValue := uint16(0)
-		// the exception for the direct address - 16-bit displacement for the direct address
-		if rm == 0b110 {
-			displacementLow, ok := d.next()
-			if ok == false {
-				return dest, src, fmt.Errorf("expected to receive the Low displacement value for direct address in the '%s' instruction", instructionName)
-			}
-			displacementHigh, ok := d.next()
-			if ok == false {
-				return dest, src, fmt.Errorf("expected to receive the High displacement value for direct address in the '%s' instruction", instructionName)
-			}
-			displacementValue = binary.LittleEndian.Uint16([]byte{displacementLow, displacementHigh})
-		}
-
-		effectiveAddress := d.calculateEffectiveAddress(rm, displacementValue, MemoryModeNoDisplacementFieldEncoding)
-
-		if dir == RegIsDestination {
-			dest = regName
-			src = effectiveAddress
-		} else {
-			dest = effectiveAddress
-			src = regName
-		}
-
-	case MemoryMode8DisplacementFieldEncoding:
-		displacementValue, ok := d.next()
-		if ok == false {
-			return dest, src, fmt.Errorf("expected to receive the displacement value for the '%s' instruction", instructionName)
-		}
-		effectiveAddress := d.calculateEffectiveAddress(rm, uint16(displacementValue), MemoryMode8DisplacementFieldEncoding)
-
-		if dir == RegIsDestination {
-			dest = regName
-			src = effectiveAddress
-		} else {
-			dest = effectiveAddress
-			src = regName
-		}
-
-	case MemoryMode16DisplacementFieldEncoding:
-		displacementLow, ok := d.next()
-		if ok == false {
-			return dest, src, fmt.Errorf("expected to receive the Low displacement value for the '%s' instruction", instructionName)
-		}
-		displacementHigh, ok := d.next()
-		if ok == false {
-			return dest, src, fmt.Errorf("expected to receive the High displacement value for the '%s' instruction", instructionName)
-		}
-
-		displacementValue := binary.LittleEndian.Uint16([]byte{displacementLow, displacementHigh})
-		effectiveAddress := d.calculateEffectiveAddress(rm, displacementValue, MemoryMode16DisplacementFieldEncoding)
+	regOrMem, err := d.decodeUnaryRegOrMem(instructionName, mod, rm, isWord)
+	if err != nil {
+		return Operand{}, Operand{}, err
+	}
 
-		if dir == RegIsDestination {
-			dest = regName
-			src = effectiveAddress
-		} else {
-			dest = effectiveAddress
-			src = regName
-		}
+	reg := registerOperand(regName)
 
-	case RegisterModeFieldEncoding:
-		rmRegisterName := ""
-		if isWord {
-			rmRegisterName = WordOperationRegisterFieldEncoding[rm]
-		} else {
-			rmRegisterName = ByteOperationRegisterFieldEncoding[rm]
-		}
-
-		if dir == RegIsDestination {
-			dest = regName
-			src = rmRegisterName
-		} else {
-			dest = rmRegisterName
-			src = regName
-		}
-	default:
-		panic("The mod field should only be 2 bits")
+	if dir == RegIsDestination {
+		return reg, regOrMem, nil
+	} else {
+		return regOrMem, reg, nil
 	}
-
-	return dest, src, nil
 }
 
 // [xxx|w] [mod|xxx|r/m] [disp-lo] [disp-hi]
-func (d *Decoder) decodeUnaryRegOrMem(instructionName string, mod byte, rm byte, isWord bool) (string, error) {
-	regOrMem := ""
-
+func (d *Decoder) decodeUnaryRegOrMem(instructionName string, mod byte, rm byte, isWord bool) (Operand, error) {
 	switch mod {
 	case MemoryModeNoDisplacementFieldEncoding:
 		displacementValue := uint16(0)
@@ -787,36 +1016,36 @@ func (d *Decoder) decodeUnaryRegOrMem(instructionName string, mod byte, rm byte,
 		if rm == 0b110 {
 			displacementLow, ok := d.next()
 			if ok == false {
-				return "", fmt.Errorf("expected to receive the Low displacement value for direct address in the '%s' instruction", instructionName)
+				return Operand{}, fmt.Errorf("expected to receive the Low displacement value for direct address in the '%s' instruction", instructionName)
 			}
 			displacementHigh, ok := d.next()
 			if ok == false {
-				return "", fmt.Errorf("expected to receive the High displacement value for direct address in the '%s' instruction", instructionName)
+				return Operand{}, fmt.Errorf("expected to receive the High displacement value for direct address in the '%s' instruction", instructionName)
 			}
 			displacementValue = binary.LittleEndian.Uint16([]byte{displacementLow, displacementHigh})
 		}
 
-		regOrMem = d.calculateEffectiveAddress(rm, displacementValue, MemoryModeNoDisplacementFieldEncoding)
+		return d.calculateEffectiveAddress(rm, displacementValue, MemoryModeNoDisplacementFieldEncoding), nil
 
 	case MemoryMode8DisplacementFieldEncoding:
 		displacementValue, ok := d.next()
 		if ok == false {
-			return "", fmt.Errorf("expected to receive the displacement value for the '%s' instruction", instructionName)
+			return Operand{}, fmt.Errorf("expected to receive the displacement value for the '%s' instruction", instructionName)
 		}
-		regOrMem = d.calculateEffectiveAddress(rm, uint16(displacementValue), MemoryMode8DisplacementFieldEncoding)
+		return d.calculateEffectiveAddress(rm, uint16(displacementValue), MemoryMode8DisplacementFieldEncoding), nil
 
 	case MemoryMode16DisplacementFieldEncoding:
 		displacementLow, ok := d.next()
 		if ok == false {
-			return "", fmt.Errorf("expected to receive the Low displacement value for the '%s' instruction", instructionName)
+			return Operand{}, fmt.Errorf("expected to receive the Low displacement value for the '%s' instruction", instructionName)
 		}
 		displacementHigh, ok := d.next()
 		if ok == false {
-			return "", fmt.Errorf("expected to receive the High displacement value for the '%s' instruction", instructionName)
+			return Operand{}, fmt.Errorf("expected to receive the High displacement value for the '%s' instruction", instructionName)
 		}
 
 		displacementValue := binary.LittleEndian.Uint16([]byte{displacementLow, displacementHigh})
-		regOrMem = d.calculateEffectiveAddress(rm, displacementValue, MemoryMode16DisplacementFieldEncoding)
+		return d.calculateEffectiveAddress(rm, displacementValue, MemoryMode16DisplacementFieldEncoding), nil
 
 	case RegisterModeFieldEncoding:
 		rmRegisterName := ""
@@ -826,12 +1055,10 @@ func (d *Decoder) decodeUnaryRegOrMem(instructionName string, mod byte, rm byte,
 			rmRegisterName = ByteOperationRegisterFieldEncoding[rm]
 		}
 
-		regOrMem = rmRegisterName
+		return registerOperand(rmRegisterName), nil
 	default:
 		panic("The mod field should only be 2 bits")
 	}
-
-	return regOrMem, nil
 }
 
 // [xxx|w] [data] [data if isWord]
@@ -862,29 +1089,18 @@ func (d *Decoder) decodeImmediate(instructionName string, isWord bool) (immediat
 }
 
 // [xxx|w] [addr-lo] [addr-hi]
-func (d *Decoder) decodeAddress(instructionName string, isWord bool) (address uint16, err error) {
-	address = uint16(0)
-
-	if isWord {
-		low, ok := d.next()
-		if ok == false {
-			return 0, fmt.Errorf("expected to get the address (low) for the '%s' instruction", instructionName)
-		}
-		high, ok := d.next()
-		if ok == false {
-			return 0, fmt.Errorf("expected to get the address (high) for the '%s' instruction", instructionName)
-		}
-
-		address = binary.LittleEndian.Uint16([]byte{low, high})
-	} else {
-		v, ok := d.next()
-		if ok == false {
-			return 0, fmt.Errorf("expected to get the address for the '%s' instruction", instructionName)
-		}
-		address = uint16(v)
+// decodeAddress reads the 16-bit direct address. It's a word regardless of the operation size
+func (d *Decoder) decodeAddress(instructionName string) (address uint16, err error) {
+	low, ok := d.next()
+	if ok == false {
+		return 0, fmt.Errorf("expected to get the address (low) for the '%s' instruction", instructionName)
+	}
+	high, ok := d.next()
+	if ok == false {
+		return 0, fmt.Errorf("expected to get the address (high) for the '%s' instruction", instructionName)
 	}
 
-	return address, nil
+	return binary.LittleEndian.Uint16([]byte{low, high}), nil
 }
 
 // [xxxxxxx|w] [data] [data if w = 1]
@@ -910,7 +1126,7 @@ func (d *Decoder) immediateWithAccumulator(instructionName string, operation byt
 }
 
 // [xxxxxx|d|w] [mod|reg|r/m] [disp-lo?] [disp-hi?]
-func (d *Decoder) regOrMemWithReg(instructionName string, operation byte) (dest string, src string, err error) {
+func (d *Decoder) regOrMemWithReg(instructionName string, operation byte) (dest Operand, src Operand, err error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -923,7 +1139,7 @@ func (d *Decoder) regOrMemWithReg(instructionName string, operation byte) (dest
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", "", fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
+		return Operand{}, Operand{}, fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
 	}
 
 	// mod is the 2 high bits
@@ -940,7 +1156,7 @@ func (d *Decoder) regOrMemWithReg(instructionName string, operation byte) (dest
 }
 
 // [xxxxxxx|w] [mod|<regPattern>|r/m] [disp-lo?] [disp-hi?] [data] [data if s|w = 0|1]
-func (d *Decoder) buildImmediateWithRegOrMemInstruction(mnemonic string, regPattern byte, instructionName string, operation byte) (string, error) {
+func (d *Decoder) buildImmediateWithRegOrMemInstruction(mnemonic string, regPattern byte, instructionName string, operation byte) (Instruction, error) {
 	// the & 0b00 is to discard all the other bits and leave the ones we care about
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
@@ -948,91 +1164,55 @@ func (d *Decoder) buildImmediateWithRegOrMemInstruction(mnemonic string, regPatt
 
 	operand, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
+		return Instruction{}, fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
 	}
 
 	mod, reg, rm := decodeOperand(operand)
 
 	if reg != regPattern {
-		return "", fmt.Errorf("expected the reg field to be %.3b for the '%s' instruction", regPattern, instructionName)
+		return Instruction{}, fmt.Errorf("expected the reg field to be %.3b for the '%s' instruction", regPattern, instructionName)
 	}
 
 	dest, err := d.decodeUnaryRegOrMem(instructionName, mod, rm, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
 	immediateValue, err := d.decodeImmediate(instructionName, isWord)
 	if err != nil {
-		return "", err
+		return Instruction{}, err
 	}
 
-	size := ""
-	if isWord {
-		size = "word"
-	} else {
-		size = "byte"
-	}
-
-	var builder strings.Builder
-	fmt.Fprintf(&builder, "%s %s, ", mnemonic, dest)
+	src := immediateOperand(immediateValue, immediateEncoding(isWord))
 
 	// we need to specify the size of the value
 	if mod != RegisterModeFieldEncoding {
 		// mov [bp + 75], byte 42
 		// add [bp + 75], byte 12
 		// sub [bp + 75], word 512
-		builder.WriteString(size + " ")
+		src.Specifier = sizeSpecifier(isWord)
 	}
 
-	fmt.Fprintf(&builder, "%d", immediateValue)
-
-	signedValue := int16(immediateValue)
-	if signedValue < 0 {
-		fmt.Fprintf(&builder, " ; or %d", signedValue)
-	}
-
-	builder.WriteString("\n")
-
-	return builder.String(), nil
+	return Instruction{
+		Mnemonic: mnemonic,
+		Operands: []Operand{dest, src},
+		Comment:  d.signedComment(immediateValue),
+	}, nil
 }
 
-func (d *Decoder) calculateEffectiveAddress(rm byte, displacementValue uint16, mod byte) string {
-	address := ""
-	if mod == MemoryModeNoDisplacementFieldEncoding {
-		equation := ""
-		// the exception for the direct address - 16-bit displacement for the direct address
-		if rm == 0b110 {
-			equation = strconv.Itoa(int(displacementValue))
-		} else {
-			equation = EffectiveAddressEquation[rm]
-		}
-
-		address = fmt.Sprintf("[%s]", equation)
-	} else if mod == MemoryMode8DisplacementFieldEncoding {
-		equation := EffectiveAddressEquation[rm]
-		signed := int8(uint8(displacementValue))
-		if signed < 0 {
-			address = fmt.Sprintf("[%s - %d]", equation, ^signed+1) // remove the sign 1111 1011 -> 0000 0101
-		} else {
-			address = fmt.Sprintf("[%s + %d]", equation, displacementValue)
-		}
-	} else if mod == MemoryMode16DisplacementFieldEncoding {
-		equation := EffectiveAddressEquation[rm]
-		signed := int16(displacementValue)
-		if signed < 0 {
-			address = fmt.Sprintf("[%s - %d]", equation, ^signed+1) // remove the sign 1111 1011 -> 0000 0101
-		} else {
-			address = fmt.Sprintf("[%s + %d]", equation, displacementValue)
-		}
-	} else {
+func (d *Decoder) calculateEffectiveAddress(rm byte, displacementValue uint16, mod byte) Operand {
+	if mod == RegisterModeFieldEncoding {
 		panic(fmt.Errorf("AssertionError: Unknown mod for effective address calculation. %.3b", mod))
 	}
 
-	if d.segment != "" {
-		return fmt.Sprintf("%s:%s", d.segment, address)
-	} else {
-		return address
+	return Operand{
+		Type: OperandMemory,
+		Memory: EffectiveAddress{
+			Mod:          mod,
+			Rm:           rm,
+			Displacement: displacementValue,
+			Segment:      d.segment,
+		},
 	}
 }
 
@@ -1,10 +1,16 @@
 package decoder
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -22,6 +28,16 @@ func TestDecoding(t *testing.T) {
 		part1("listing_0040_challenge_movs"),
 		part1("listing_0041_add_sub_cmp_jnz"),
 		part1("listing_0042_completionist_decode"),
+		part1("short-and-near-jmp"),
+		part1("push-pop-memory"),
+		part1("lock-xchg-memory"),
+		part1("in-out"),
+		part1("indirect-call-jmp"),
+		part1("accumulator-mov"),
+		part1("xchg"),
+		part1("addressing-modes"),
+		part1("inc-dec"),
+		part1("xchg-accumulator"),
 	}
 
 	for _, filename := range files {
@@ -55,13 +71,56 @@ func TestDecoding(t *testing.T) {
 			}
 		}()
 
-		asm := []byte("bits 16\n\n")
+		asm := []byte(decoder.Header(""))
 		asm = append(asm, contents...)
 
 		verifyAssembled(t, asm, source, filename)
 	}
 }
 
+// assemble runs nasm on the 16-bit asm and returns the machine code, so the test cases can be written as assembly.
+// The test is skipped if nasm isn't installed
+func assemble(t *testing.T, asm string) []byte {
+	t.Helper()
+	if _, err := exec.LookPath("nasm"); err != nil {
+		t.Skip("nasm is required to assemble the test cases")
+	}
+
+	dir := t.TempDir()
+	in := path.Join(dir, "in.asm")
+	out := path.Join(dir, "out")
+	if err := os.WriteFile(in, []byte("bits 16\n"+asm), 0o644); err != nil {
+		t.Fatalf("failed to write the asm = %v", err)
+	}
+
+	output, err := exec.Command("nasm", "-o", out, in).CombinedOutput()
+	if err != nil {
+		t.Fatalf("nasm err = %v\n%s", err, output)
+	}
+
+	assembled, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read the assembled output = %v", err)
+	}
+	return assembled
+}
+
+func TestAssemble(t *testing.T) {
+	// the output of the decoder is the same as its input for the canonical forms
+	cases := []string{
+		"mov ax, bx\nmov cl, [bp - 3]\nadd [bx + si + 1000], word 12\n",
+		"push word [bx]\npop ds\nxchg ax, cx\n",
+		"call word [bp - 100]\njmp far [di]\n",
+	}
+
+	for _, asm := range cases {
+		source := assemble(t, asm)
+		if contents := decodeText(t, source); contents != asm {
+			t.Errorf("expected %q, got %q", asm, contents)
+		}
+	}
+}
+
 func verifyAssembled(t *testing.T, asm []byte, source []byte, filename string) {
 	tmpIn, err := os.CreateTemp(os.TempDir(), "*")
 	if err != nil {
@@ -102,3 +161,2319 @@ func verifyAssembled(t *testing.T, asm []byte, source []byte, filename string) {
 		}
 	}
 }
+
+func BenchmarkDecode(b *testing.B) {
+	filename := part1("listing_0042_completionist_decode")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		b.Fatalf("%s = %v", filename, err)
+	}
+
+	b.Run("Decode", func(b *testing.B) {
+		b.SetBytes(int64(len(source)))
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			decoder := NewDecoder(source)
+			if _, err := decoder.Decode(); err != nil {
+				b.Fatalf("%s = %v", filename, err)
+			}
+		}
+	})
+
+	// matchPattern is called for every row of the opcode table until one matches,
+	// so its cost is multiplied by the position of the instruction in the table
+	b.Run("matchPattern", func(b *testing.B) {
+		patterns := []string{
+			"0b100010dw",
+			"0b1011wreg",
+			"0b100000sw|0b__101___",
+			"0b11111111|0b__110___",
+			"0b01110100",
+		}
+		decoder := NewDecoder(source)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			decoder.pos = 1
+			for _, pattern := range patterns {
+				decoder.matchPattern("benchmark", source[0], pattern)
+			}
+		}
+	})
+}
+
+func TestDecodingFromMmap(t *testing.T) {
+	filename := part1("listing_0042_completionist_decode")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	expected, err := NewDecoder(source).Decode()
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	decoder, unmap, err := NewDecoderFromMmap(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+	defer func() {
+		if err := unmap(); err != nil {
+			t.Errorf("%s; failed to unmap. err = %v", filename, err)
+		}
+	}()
+
+	contents, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	if string(contents) != string(expected) {
+		t.Errorf("%s: the mmap decoding doesn't match the regular one", filename)
+	}
+}
+
+func TestDecodingLimit(t *testing.T) {
+	source := []byte{
+		0b11110011, 0b10100100, // rep movsb
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10001001, 0b11011000, // mov ax, bx
+	}
+
+	decoder := NewDecoder(source)
+	decoder.Limit = 2
+
+	contents, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "rep movsb\nmov ax, bx\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  []byte
+		lenient string
+	}{
+		{
+			name:    "segment MOV with the reserved reg bit",
+			source:  []byte{0b10001100, 0b11111000}, // mov ax, ds with reg = 111
+			lenient: "mov ax, ds\n",
+		},
+		{
+			name:    "MOV to CS",
+			source:  []byte{0b10001110, 0b11001000}, // mov cs, ax
+			lenient: "mov cs, ax\n",
+		},
+		{
+			name:    "LEA with a register operand",
+			source:  []byte{0b10001101, 0b11000011}, // lea ax, bx
+			lenient: "lea ax, bx\n",
+		},
+		{
+			name:    "sign extended byte immediate",
+			source:  []byte{0b10000010, 0b11000000, 0b00000101}, // add al, 5
+			lenient: "add al, 5\n",
+		},
+	}
+
+	for _, tt := range tests {
+		contents, err := NewDecoder(tt.source).Decode()
+		if err != nil {
+			t.Errorf("%s: unexpected error in the lenient mode = %v", tt.name, err)
+		} else if string(contents) != tt.lenient {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.lenient, contents)
+		}
+
+		decoder := NewDecoder(tt.source)
+		decoder.Strict = true
+		if _, err := decoder.Decode(); err == nil {
+			t.Errorf("%s: expected an error in the strict mode", tt.name)
+		}
+	}
+}
+
+func TestBuildCFG(t *testing.T) {
+	source := []byte{
+		0b10111001, 0b00000011, 0b00000000, // 0: mov cx, 3
+		0b01001001,             // 3: dec cx
+		0b01110101, 0b11111101, // 4: jnz 3
+		0b11101011, 0b00000001, // 6: jmp short 9
+		0b11000011, // 8: ret
+		0b11110100, // 9: hlt
+	}
+
+	decoder := NewDecoder(source)
+	if _, err := decoder.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	cfg := BuildCFG(decoder.Instructions())
+
+	expectedBlocks := [][2]int{{0, 3}, {3, 6}, {6, 8}, {8, 9}, {9, 10}}
+	if len(cfg.Blocks) != len(expectedBlocks) {
+		t.Fatalf("expected %d blocks, got %d", len(expectedBlocks), len(cfg.Blocks))
+	}
+	for idx, block := range cfg.Blocks {
+		if block.Start != expectedBlocks[idx][0] || block.End != expectedBlocks[idx][1] {
+			t.Errorf("block %d: expected [%d, %d), got [%d, %d)", idx, expectedBlocks[idx][0], expectedBlocks[idx][1], block.Start, block.End)
+		}
+	}
+
+	expectedEdges := []Edge{
+		{From: 0, To: 1, Kind: EdgeFallThrough},
+		{From: 1, To: 1, Kind: EdgeTaken},
+		{From: 1, To: 2, Kind: EdgeFallThrough},
+		{From: 2, To: 4, Kind: EdgeTaken},
+	}
+	if len(cfg.Edges) != len(expectedEdges) {
+		t.Fatalf("expected %d edges, got %v", len(expectedEdges), cfg.Edges)
+	}
+	for idx, edge := range cfg.Edges {
+		if edge != expectedEdges[idx] {
+			t.Errorf("edge %d: expected %v, got %v", idx, expectedEdges[idx], edge)
+		}
+	}
+
+	if successors := cfg.Successors(3); len(successors) != 0 {
+		t.Errorf("ret must have no successors, got %v", successors)
+	}
+}
+
+func TestRecursiveDescent(t *testing.T) {
+	source := []byte{
+		0b11101011, 0b00000010, // jmp short 4
+		0x41, 0x42, // "AB" - inc cx, inc dx if decoded linearly
+		0b11110100, // hlt
+	}
+
+	decoder := NewDecoder(source)
+	decoder.RecursiveDescent = true
+
+	contents, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "jmp short label__4\ndb 0x41\ndb 0x42\nlabel__4:\nhlt\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestEmitDataOnError(t *testing.T) {
+	source := []byte{
+		0b01100000,             // unknown on 8086
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10111000, 0b00000001, // truncated mov ax, imm16
+	}
+
+	decoder := NewDecoder(source)
+	decoder.EmitDataOnError = true
+
+	contents, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "db 0x60\nmov ax, bx\ndb 0xb8\ndb 0x01\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestUnknownOperation(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011000, // mov ax, bx
+		0b01100011, // undefined on the 8086
+	}
+
+	_, err := NewDecoder(source).Decode()
+	if !errors.Is(err, ErrUnknownOperation) {
+		t.Fatalf("expected ErrUnknownOperation, got %v", err)
+	}
+
+	var decodeError DecodeError
+	if !errors.As(err, &decodeError) || decodeError.Offset != 2 {
+		t.Errorf("expected the DecodeError at the offset 2, got %v", err)
+	}
+	if expected := "at offset 0x2: unknown operation 01100011"; err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+
+	if _, err := NewDecoder(source).DecodeAt(2); !errors.Is(err, ErrUnknownOperation) {
+		t.Errorf("expected ErrUnknownOperation from DecodeAt, got %v", err)
+	}
+}
+
+// decodeText decodes the source and fails the test on error
+func decodeText(t *testing.T, source []byte) string {
+	t.Helper()
+
+	contents, err := NewDecoder(source).Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	return string(contents)
+}
+
+func TestPushPopMemory(t *testing.T) {
+	source := []byte{
+		0b11111111, 0b00110110, 0b00110100, 0b00010010, // push word [0x1234]
+		0b10001111, 0b00000110, 0b00110100, 0b00010010, // pop word [0x1234]
+	}
+
+	expected := "push word [4660]\npop word [4660]\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestATTSyntax(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10001011, 0b01000000, 0b00000100, // mov ax, [bx + si + 4]
+		0b11000111, 0b00000111, 0b00000001, 0b00000000, // mov word [bx], 1
+		0b00100110, 0b10001010, 0b01000110, 0b11111011, // mov al, es:[bp - 5]
+		0b10100001, 0b00110100, 0b00010010, // mov ax, [4660]
+		0b11111111, 0b00101111, // jmp far [bx]
+		0b11101010, 0b11001000, 0b00000001, 0b01111011, 0b00000000, // jmp 123:456
+	}
+
+	decoder := NewDecoder(source)
+	decoder.Syntax = SyntaxATT
+	contents, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "mov %bx, %ax\n" +
+		"mov 4(%bx,%si), %ax\n" +
+		"movw $1, (%bx)\n" +
+		"mov %es:-5(%bp), %al\n" +
+		"mov 4660, %ax\n" +
+		"ljmp *(%bx)\n" +
+		"ljmp $123, $456\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+}
+
+func TestObjdumpSyntax(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10001011, 0b01000000, 0b00000100, // mov ax, [bx + si + 4]
+		0b11000111, 0b00000111, 0b00000001, 0b00000000, // mov word [bx], 1
+		0b00100110, 0b10001010, 0b01000110, 0b11111011, // mov al, es:[bp - 5]
+		0b10100001, 0b00110100, 0b00010010, // mov ax, [4660]
+		0b10000011, 0b11000000, 0b11111011, // add ax, -5
+		0b11010000, 0b11100000, // shl al, 1
+		0b11101100,             // in al, dx
+		0b01110100, 0b11101000, // jz -> 0
+		0b11111111, 0b00101111, // jmp far [bx]
+		0b11101010, 0b11001000, 0b00000001, 0b01111011, 0b00000000, // jmp 123:456
+	}
+
+	d := NewDecoder(source)
+	d.Syntax = SyntaxObjdump
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "mov    %bx,%ax\n" +
+		"mov    0x4(%bx,%si),%ax\n" +
+		"movw   $0x1,(%bx)\n" +
+		"mov    %es:-0x5(%bp),%al\n" +
+		"mov    0x1234,%ax\n" +
+		"add    $0xfffb,%ax # or -5\n" +
+		"shl    %al\n" +
+		"in     (%dx),%al\n" +
+		"je     0x0\n" +
+		"ljmp   *(%bx)\n" +
+		"ljmp   $0x7b,$0x1c8\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	if header := d.Header("listing"); header != "# listing\n\n" {
+		t.Errorf("expected no directive in the header, got %q", header)
+	}
+}
+
+func TestWaitWithEscape(t *testing.T) {
+	source := []byte{
+		0b10011011, 0b11011001, 0b00111110, 0b00110100, 0b00010010, // fstcw word [4660]
+		0b11011001, 0b00111110, 0b00110100, 0b00010010, // fnstcw word [4660]
+		0b10011011, 0b11011011, 0b11100011, // finit
+		0b10011011,             // wait
+		0b11011000, 0b11000001, // fadd st0, st1
+		0b11011100, 0b11101010, // fsub st2, st0
+		0b11011101, 0b01000110, 0b00001000, // fld qword [bp + 8]
+		0b10011011, // wait
+	}
+
+	expected := "fstcw word [4660]\n" +
+		"fnstcw word [4660]\n" +
+		"finit\n" +
+		"wait\n" +
+		"fadd st0, st1\n" +
+		"fsub st2, st0\n" +
+		"fld qword [bp + 8]\n" +
+		"wait\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestErrorOffset(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10001011, 0b10000000, 0b00000100, // mov ax, [bx + si + ...] - truncated
+	}
+
+	_, err := NewDecoder(source).Decode()
+	if err == nil {
+		t.Fatalf("expected an error for the truncated instruction")
+	}
+
+	expected := "at offset 0x2: expected to receive the High displacement value for the 'Register/memory to/from register' instruction"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+
+	// the partial encoding: the operation, the operand and the low displacement
+	var decodeError DecodeError
+	if !errors.As(err, &decodeError) {
+		t.Fatalf("expected a DecodeError, got %T", err)
+	}
+	if consumed := []byte{0b10001011, 0b10000000, 0b00000100}; !reflect.DeepEqual(decodeError.Consumed, consumed) {
+		t.Errorf("expected the consumed bytes %08b, got %08b", consumed, decodeError.Consumed)
+	}
+}
+
+// patternMask splits the pattern into the mask of the fixed bits and their values, byte by byte
+func patternMask(pattern string) (masks []byte, values []byte) {
+	for _, p := range strings.Split(pattern, "|") {
+		mask, value := byte(0), byte(0)
+		for offset, ch := range strings.TrimPrefix(p, "0b") {
+			shift := 7 - offset
+			if ch == '0' || ch == '1' {
+				mask |= 1 << shift
+			}
+			if ch == '1' {
+				value |= 1 << shift
+			}
+		}
+
+		masks = append(masks, mask)
+		values = append(values, value)
+	}
+
+	return masks, values
+}
+
+// TestOpcodesDontOverlap makes sure every encoding is matched by at most one row of the opcode table,
+// otherwise the row placed later is silently shadowed
+func TestOpcodesDontOverlap(t *testing.T) {
+	for i := 0; i < len(opcodes); i++ {
+		for j := i + 1; j < len(opcodes); j++ {
+			masksA, valuesA := patternMask(opcodes[i].pattern)
+			masksB, valuesB := patternMask(opcodes[j].pattern)
+
+			overlap := true
+			for idx := 0; idx < len(masksA) && idx < len(masksB); idx++ {
+				if (valuesA[idx]^valuesB[idx])&masksA[idx]&masksB[idx] != 0 {
+					overlap = false
+					break
+				}
+			}
+
+			if overlap {
+				t.Errorf("'%s' (%s) overlaps with '%s' (%s)", opcodes[i].name, opcodes[i].pattern, opcodes[j].name, opcodes[j].pattern)
+			}
+		}
+	}
+}
+
+// TestMoveImmediateBadReg makes sure the 0xC6/0xC7 encodings with a non-zero reg extension are reported as errors
+func TestMoveImmediateBadReg(t *testing.T) {
+	for reg := byte(0b001); reg <= 0b111; reg++ {
+		source := []byte{
+			0b10001001, 0b11011000, // mov ax, bx
+			0b11000110, 0b00000111 | reg<<3, 0b00000001, // mov byte [bx], 1 with the reg field != 000
+		}
+
+		d := NewDecoder(source)
+		_, err := d.Decode()
+		if err == nil {
+			t.Errorf("reg %03b: expected an error", reg)
+			continue
+		}
+
+		expected := "at offset 0x2: expected the reg field to be 000 for the 'immediate to register/memory' instruction"
+		if err.Error() != expected {
+			t.Errorf("reg %03b: expected %q, got %q", reg, expected, err.Error())
+		}
+
+		if len(d.Instructions()) != 1 || d.Instructions()[0].Mnemonic != "mov" {
+			t.Errorf("reg %03b: expected only the first instruction to be decoded, got %v", reg, d.Instructions())
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10010000,                         // xchg ax, ax
+		0b10000011, 0b11000001, 0b11111111, // add cx, -1
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	// transform the instructions and render them back
+	lines := make([]string, 0)
+	for _, instruction := range d.Instructions() {
+		if instruction.Mnemonic == "xchg" {
+			continue
+		}
+		// the operands are shared with the decoder
+		instruction.Operands = append([]Operand(nil), instruction.Operands...)
+		if instruction.Operands[0].Register == "ax" {
+			instruction.Operands[0].Register = "dx"
+		}
+
+		lines = append(lines, instruction.String())
+	}
+
+	expected := "mov dx, bx|add cx, 65535 ; or -1"
+	if contents := strings.Join(lines, "|"); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+
+	d.Syntax = SyntaxATT
+	if contents := Format(d.Instructions()[2], d.FormatOptions()); contents != "add $65535, %cx # or -1" {
+		t.Errorf("expected %q, got %q", "add $65535, %cx # or -1", contents)
+	}
+}
+
+func TestLockXchg(t *testing.T) {
+	source := []byte{
+		0b11110000, 0b10000111, 0b00000111, // lock xchg [bx], ax
+		0b11110000, 0b10000110, 0b01001111, 0b00000100, // lock xchg [bx + 4], cl
+	}
+
+	expected := "lock xchg [bx], ax\nlock xchg [bx + 4], cl\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestSignedComment(t *testing.T) {
+	source := []byte{
+		0b10000011, 0b11000001, 0b11111111, // add cx, -1 (sign-extended)
+		0b10000001, 0b11101001, 0b00000000, 0b10000000, // sub cx, -32768
+		0b10000011, 0b00111111, 0b11111110, // cmp word [bx], -2 (sign-extended)
+		0b10111001, 0b11111111, 0b11111111, // mov cx, -1
+		0b10000000, 0b11010001, 0b11111111, // adc cl, 255
+		0b00101101, 0b11111111, 0b11111111, // sub ax, -1
+		0b00100101, 0b00000000, 0b11111111, // and ax, -256
+		0b00111100, 0b11111111, // cmp al, 255
+	}
+
+	expected := "add cx, 65535 ; or -1\n" +
+		"sub cx, 32768 ; or -32768\n" +
+		"cmp [bx], word 65534 ; or -2\n" +
+		"mov cx, 65535 ; or -1\n" +
+		"adc cl, 255\n" +
+		"sub ax, 65535 ; or -1\n" +
+		"and ax, 65280 ; or -256\n" +
+		"cmp al, 255\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+
+	d := NewDecoder(source)
+	d.ShowSignedComment = false
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected = "add cx, 65535\nsub cx, 32768\ncmp [bx], word 65534\nmov cx, 65535\nadc cl, 255\n" +
+		"sub ax, 65535\nand ax, 65280\ncmp al, 255\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	d = NewDecoder(source)
+	d.SignedCommentThreshold = 256
+	contents, err = d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	// -256 and -32768 look more like the unsigned masks than the negative numbers
+	expected = "add cx, 65535 ; or -1\nsub cx, 32768\ncmp [bx], word 65534 ; or -2\nmov cx, 65535 ; or -1\nadc cl, 255\n" +
+		"sub ax, 65535 ; or -1\nand ax, 65280\ncmp al, 255\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+}
+
+func TestNewDecoderCopy(t *testing.T) {
+	source := []byte{0b10001001, 0b11011000} // mov ax, bx
+	d := NewDecoderCopy(source)
+	source[0], source[1] = 0b10010000, 0b10010000 // nop, nop
+
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if expected := "mov ax, bx\n"; string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+}
+
+func TestResync(t *testing.T) {
+	source := []byte{
+		0b01100000,             // undefined on the 8086
+		0b10001000,             // a stray byte that looks like the start of mov
+		0b10001001, 0b11011000, // mov ax, bx
+		0b00000001, 0b11011000, // add ax, bx
+		0b00101001, 0b11011000, // sub ax, bx
+	}
+
+	d := NewDecoder(source)
+	d.Resync = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "db 0x60\ndb 0x88\nmov ax, bx\nadd ax, bx\nsub ax, bx\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	// without the resync the stray byte swallows the real instructions
+	d = NewDecoder(source)
+	d.EmitDataOnError = true
+	contents, err = d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	if strings.Contains(string(contents), "mov ax, bx") {
+		t.Errorf("expected the linear decoding to desync, got %q", string(contents))
+	}
+}
+
+func TestExplicitStringOperands(t *testing.T) {
+	source := []byte{
+		0b11110011, 0b10100100, // rep movsb
+		0b11110011, 0b10100111, // repz cmpsw
+		0b10101110,             // scasb
+		0b00100110, 0b10101101, // lodsw with the es override
+		0b10101011, // stosw
+	}
+
+	expected := "rep movsb\nrepz cmpsw\nscasb\nlodsw\nstosw\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+
+	d := NewDecoder(source)
+	d.ExplicitStringOperands = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected = "rep movs byte [di], [si]\n" +
+		"repz cmps word [si], [di]\n" +
+		"scas byte [di]\n" +
+		"lods word es:[si]\n" +
+		"stos word [di]\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10001001, 0b11000011, // mov bx, ax
+		0b00000001, 0b11011000, // add ax, bx
+		0b11110011, 0b10100100, // rep movsb
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	histogram := Histogram(d.Instructions())
+	expected := map[string]int{"mov": 2, "add": 1, "movsb": 1}
+	if len(histogram) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, histogram)
+	}
+	for mnemonic, count := range expected {
+		if histogram[mnemonic] != count {
+			t.Errorf("expected %d '%s', got %d", count, mnemonic, histogram[mnemonic])
+		}
+	}
+}
+
+func TestLineEndingAndComments(t *testing.T) {
+	source := []byte{
+		0b10111001, 0b11111111, 0b11111111, // mov cx, -1
+		0b01110101, 0b11111011, // jnz label__0
+	}
+
+	d := NewDecoder(source)
+	d.LineEnding = "\r\n"
+	d.CommentPrefix = "//"
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "label__0:\r\nmov cx, 65535 // or -1\r\njnz label__0 // jne\r\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	d.HideComments = true
+	expected = "label__0:\r\nmov cx, 65535\r\njnz label__0\r\n"
+	if contents := string(d.GetDecoded()); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestImmediateEncoding(t *testing.T) {
+	source := []byte{
+		0b10000011, 0b11000001, 0b11111111, // add cx, -1 (sign-extended)
+		0b10000001, 0b11000001, 0b11111111, 0b11111111, // add cx, 65535
+		0b10000000, 0b11000001, 0b11111111, // add cl, 255
+		0b11010001, 0b11100001, // shl cx, 1
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	instructions := d.Instructions()
+
+	expected := []ImmediateEncoding{ImmediateSignExtendedByte, ImmediateWord, ImmediateByte, ImmediateImplied}
+	if len(instructions) != len(expected) {
+		t.Fatalf("expected %d instructions, got %d", len(expected), len(instructions))
+	}
+
+	for i, instruction := range instructions {
+		if encoding := instruction.Operands[1].Immediate.Encoding; encoding != expected[i] {
+			t.Errorf("%s: expected the encoding %d, got %d", instruction, expected[i], encoding)
+		}
+	}
+
+	// the sign-extended and the word forms hold the same value
+	if instructions[0].Operands[1].Immediate.Value != instructions[1].Operands[1].Immediate.Value {
+		t.Errorf("expected the same value, got %d and %d", instructions[0].Operands[1].Immediate.Value, instructions[1].Operands[1].Immediate.Value)
+	}
+
+	// the byte is signed from the bit 7
+	signed := []int16{-1, -1, -1, 1}
+	unsigned := []uint16{65535, 65535, 255, 1}
+	widths := []int{2, 2, 1, 1}
+	for i, instruction := range instructions {
+		immediate := instruction.Operands[1].Immediate
+		if immediate.Signed() != signed[i] || immediate.Unsigned() != unsigned[i] || immediate.Width() != widths[i] {
+			t.Errorf("%s: expected %d, %d and the width %d, got %d, %d and %d",
+				instruction, signed[i], unsigned[i], widths[i], immediate.Signed(), immediate.Unsigned(), immediate.Width())
+		}
+	}
+}
+
+func TestDecodeRange(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011001, // mov cx, bx
+		0b10111001, 0b00001100, 0b00000000, // mov cx, 12
+		0b10001001, 0b11011001, // mov cx, bx
+	}
+
+	d := NewDecoder(source)
+	instructions, err := d.DecodeRange(2, 7)
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if len(instructions) != 2 || instructions[0].Offset != 2 || instructions[1].Offset != 5 {
+		t.Fatalf("expected the instructions at 2 and 5, got %v", instructions)
+	}
+	if len(d.Instructions()) != 0 {
+		t.Errorf("expected the range not to be added to the instructions, got %v", d.Instructions())
+	}
+
+	// the end splits mov cx, 12
+	instructions, err = d.DecodeRange(0, 4)
+	if !errors.Is(err, ErrSplitInstruction) {
+		t.Fatalf("expected ErrSplitInstruction, got %v", err)
+	}
+	if len(instructions) != 1 || instructions[0].Offset != 0 {
+		t.Errorf("expected the instruction at 0, got %v", instructions)
+	}
+
+	if _, err := d.DecodeRange(4, 8); err == nil {
+		t.Errorf("expected an error for the range outside of the bytes")
+	}
+}
+
+func TestDecodeAt(t *testing.T) {
+	source := []byte{
+		0b10111000, 0b10110000, 0b00000101, // mov ax, 1456; the last 2 bytes are mov al, 5
+		0b11110011, 0b00100110, 0b10100100, // rep movsb with es:
+		0b11101011, 0b11111001, // jmp short 1
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	cases := []struct {
+		offset   int
+		expected string
+	}{
+		{1, "mov al, 5"},
+		{3, "rep movsb"},
+		{4, "movsb"},
+		{0, "mov ax, 1456"},
+	}
+	for _, c := range cases {
+		instruction, err := d.DecodeAt(c.offset)
+		if err != nil {
+			t.Fatalf("%d: unexpected error = %v", c.offset, err)
+		}
+		if instruction.Offset != c.offset || instruction.String() != c.expected {
+			t.Errorf("%d: expected %q, got %q at %d", c.offset, c.expected, instruction.String(), instruction.Offset)
+		}
+	}
+
+	if len(d.Instructions()) != 3 {
+		t.Errorf("expected the decoded instructions to stay the same, got %v", d.Instructions())
+	}
+
+	if _, err := d.DecodeAt(len(source)); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+	if _, err := d.DecodeAt(len(source) + 1); err == nil {
+		t.Errorf("expected an error for the offset outside of the bytes")
+	}
+}
+
+// TestEffectiveAddressTable renders every mod|r/m combination. Mod = 00 with r/m = 110 is the direct address,
+// so [bp] only exists with a displacement
+func TestEffectiveAddressTable(t *testing.T) {
+	expected := [4][8]string{
+		// mod = 00
+		{"[bx + si]", "[bx + di]", "[bp + si]", "[bp + di]", "[si]", "[di]", "[4660]", "[bx]"},
+		// mod = 01, disp8 = -2
+		{"[bx + si - 2]", "[bx + di - 2]", "[bp + si - 2]", "[bp + di - 2]", "[si - 2]", "[di - 2]", "[bp - 2]", "[bx - 2]"},
+		// mod = 10, disp16 = 4660
+		{"[bx + si + 4660]", "[bx + di + 4660]", "[bp + si + 4660]", "[bp + di + 4660]", "[si + 4660]", "[di + 4660]", "[bp + 4660]", "[bx + 4660]"},
+		// mod = 11
+		{"ax", "cx", "dx", "bx", "sp", "bp", "si", "di"},
+	}
+
+	for mod := byte(0); mod < 4; mod++ {
+		for rm := byte(0); rm < 8; rm++ {
+			// mov ax, r/m
+			source := []byte{0b10001011, mod<<6 | rm}
+			switch {
+			case mod == MemoryModeNoDisplacementFieldEncoding && rm == 0b110:
+				source = append(source, 0x34, 0x12)
+			case mod == MemoryMode8DisplacementFieldEncoding:
+				source = append(source, 0xfe)
+			case mod == MemoryMode16DisplacementFieldEncoding:
+				source = append(source, 0x34, 0x12)
+			}
+
+			want := "mov ax, " + expected[mod][rm] + "\n"
+			if contents := decodeText(t, source); contents != want {
+				t.Errorf("mod=%.2b r/m=%.3b: expected %q, got %q", mod, rm, want, contents)
+			}
+		}
+	}
+}
+
+// TestGroup3 feeds every reg extension of 0xF7. The opcodes share the first byte and differ in the reg field
+func TestGroup3(t *testing.T) {
+	expected := map[byte]string{
+		0b000: "test cx, 4660\n",
+		0b010: "not cx\n",
+		0b011: "neg cx\n",
+		0b100: "mul cx\n",
+		0b101: "imul cx\n",
+		0b110: "div cx\n",
+		0b111: "idiv cx\n",
+	}
+
+	for reg, want := range expected {
+		source := []byte{0b11110111, 0b11000001 | reg<<3}
+		if reg == 0b000 {
+			source = append(source, 0x34, 0x12)
+		}
+
+		if contents := decodeText(t, source); contents != want {
+			t.Errorf("reg=%.3b: expected %q, got %q", reg, want, contents)
+		}
+	}
+
+	// reg = 001 is undefined
+	d := NewDecoder([]byte{0b11110111, 0b11001001})
+	d.EmitDataOnError = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if !strings.HasPrefix(string(contents), "db 0xf7\n") {
+		t.Errorf("expected reg=001 to be emitted as data, got %q", contents)
+	}
+}
+
+func TestTestEncoding(t *testing.T) {
+	// NASM and the table 4-13 encode test as [1000010|w], the table 4-12 pattern [000100|d|w] is adc
+	// and the neighbouring [1000011|w] is xchg, so neither of them is ever decoded as test
+	cases := []struct {
+		source   []byte
+		expected string
+	}{
+		{[]byte{0b10000100, 0b11011000}, "test al, bl\n"},
+		{[]byte{0b10000101, 0b00000111}, "test [bx], ax\n"},
+		{[]byte{0b10000110, 0b11011000}, "xchg al, bl\n"},
+		{[]byte{0b00010000, 0b11011000}, "adc al, bl\n"},
+		{[]byte{0b00010011, 0b00000111}, "adc ax, [bx]\n"},
+	}
+
+	for _, c := range cases {
+		if contents := decodeText(t, c.source); contents != c.expected {
+			t.Errorf("%08b: expected %q, got %q", c.source, c.expected, contents)
+		}
+	}
+
+	// the same bytes come out of nasm
+	source := assemble(t, "test al, bl\ntest [bx], ax\n")
+	if !reflect.DeepEqual(source, []byte{0b10000100, 0b11011000, 0b10000101, 0b00000111}) {
+		t.Errorf("expected nasm to encode test as [1000010|w], got %08b", source)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	d := NewDecoder(nil)
+	if header := d.Header("listing.bin"); header != "; listing.bin\nbits 16\n\n" {
+		t.Errorf("unexpected header %q", header)
+	}
+	if header := d.Header(""); header != "bits 16\n\n" {
+		t.Errorf("unexpected header %q", header)
+	}
+
+	d.Syntax = SyntaxATT
+	d.LineEnding = "\r\n"
+	if header := d.Header("listing.bin"); header != "# listing.bin\r\n.code16\r\n\r\n" {
+		t.Errorf("unexpected header %q", header)
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	// a COM file is loaded at 0x100
+	source := []byte{
+		0b10111001, 0b00000011, 0b00000000, // mov cx, 3
+		0b01001001,             // dec cx
+		0b01110101, 0b11111101, // jnz -3
+		0b11101000, 0b00000001, 0b00000000, // call +1
+		0b11000011, // ret
+		0b11000011, // ret
+	}
+
+	d := NewDecoder(source)
+	d.Origin = 0x100
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "mov cx, 3\nlabel__259:\ndec cx\njnz label__259 ; jne\ncall 266\nret\nret\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+	if labels := d.Labels(); !reflect.DeepEqual(labels, map[int]string{259: "label__259"}) {
+		t.Errorf("expected the label at 259, got %v", labels)
+	}
+	var labelMap strings.Builder
+	if err := d.WriteMap(&labelMap); err != nil || labelMap.String() != "0103 label__259\n" {
+		t.Errorf("expected the map of the label at 0x103, got %q, err = %v", labelMap.String(), err)
+	}
+	if header := d.Header(""); header != "bits 16\norg 0x100\n\n" {
+		t.Errorf("unexpected header %q", header)
+	}
+
+	// the offsets of the instructions are still the positions in the file
+	if index, ok := d.IndexOf(3); !ok || d.Instructions()[index].Mnemonic != "dec" {
+		t.Errorf("expected dec at the offset 3")
+	}
+
+	if _, err := exec.LookPath("nasm"); err != nil {
+		t.Skip("nasm is required to verify the org directive")
+	}
+	verifyAssembled(t, append([]byte(d.Header("")), contents...), source, "origin")
+}
+
+func TestWriteMap(t *testing.T) {
+	source := []byte{
+		// label_0x0:
+		0b01110101, 0b00000010, // jnz label_0x4
+		0b01110100, 0b11111100, // jz label_0x0
+		// label_0x4:
+		0b11100010, 0b11111010, // loop label_0x0
+	}
+
+	d := NewDecoder(source)
+	d.HexLabels = true
+	d.LineEnding = "\r\n"
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	var labelMap strings.Builder
+	if err := d.WriteMap(&labelMap); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if expected := "0000 label_0x0\r\n0004 label_0x4\r\n"; labelMap.String() != expected {
+		t.Errorf("expected %q, got %q", expected, labelMap.String())
+	}
+}
+
+func TestIndent(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011001, // mov cx, bx
+		0b01110101, 0b11111100, // jnz label__0
+	}
+
+	d := NewDecoder(source)
+	d.Indent = "    "
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "label__0:\n    mov cx, bx\n    jnz label__0 ; jne\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	if _, err := exec.LookPath("nasm"); err != nil {
+		t.Skip("nasm is required to verify the indented output")
+	}
+
+	filename := part1("short-and-near-jmp")
+	source, err = os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	d = NewDecoder(source)
+	d.Indent = "\t"
+	contents, err = d.Decode()
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	verifyAssembled(t, append([]byte(d.Header("")), contents...), source, filename)
+}
+
+func TestSupportedInstructions(t *testing.T) {
+	supported := SupportedInstructions()
+	set := make(map[string]bool)
+	for _, mnemonic := range supported {
+		set[mnemonic] = true
+	}
+
+	for _, mnemonic := range []string{"mov", "retf", "movsb", "jcxz", "lock", "repnz", "fstcw", "fnstcw"} {
+		if !set[mnemonic] {
+			t.Errorf("expected '%s' to be supported", mnemonic)
+		}
+	}
+
+	if set["db"] {
+		t.Errorf("expected the data not to be reported as an instruction")
+	}
+
+	// the callers get their own copy
+	supported[0] = ""
+	if SupportedInstructions()[0] == "" {
+		t.Errorf("expected the result not to be shared")
+	}
+}
+
+// goldenInstructions has a minimal example for every supported instruction in the form the decoder outputs
+var goldenInstructions = map[string]string{
+	"aaa": "aaa", "aad": "aad", "aam": "aam", "aas": "aas",
+	"adc": "adc al, 0", "add": "add al, 0", "and": "and al, 0", "or": "or al, 0",
+	"sbb": "sbb al, 0", "sub": "sub al, 1", "cmp": "cmp al, 1", "xor": "xor al, 1", "test": "test al, 1",
+	"bound": "bound ax, [1]", "enter": "enter 1, 0", "leave": "leave", "pusha": "pusha", "popa": "popa",
+	"call": "call 0", "jmp": "jmp ax", "ret": "ret", "retf": "retf", "iret": "iret",
+	"int": "int 1", "int3": "int3", "into": "into",
+	"cbw": "cbw", "cwd": "cwd", "clc": "clc", "cld": "cld", "cli": "cli", "cmc": "cmc",
+	"stc": "stc", "std": "std", "sti": "sti", "hlt": "hlt", "wait": "wait",
+	"lahf": "lahf", "sahf": "sahf", "pushf": "pushf", "popf": "popf",
+	"daa": "daa", "das": "das", "xlat": "xlat",
+	"cmpsb": "cmpsb", "cmpsw": "cmpsw", "lodsb": "lodsb", "lodsw": "lodsw", "movsb": "movsb",
+	"movsw": "movsw", "scasb": "scasb", "scasw": "scasw", "stosb": "stosb", "stosw": "stosw",
+	"lock": "lock xchg [bx], al", "rep": "rep movsb", "repz": "repz cmpsb", "repnz": "repnz cmpsb",
+	"inc": "inc ax", "dec": "dec ax", "neg": "neg al", "not": "not al",
+	"mul": "mul al", "imul": "imul al", "div": "div al", "idiv": "idiv al",
+	"rcl": "rcl al, 1", "rcr": "rcr al, 1", "rol": "rol al, 1", "ror": "ror al, 1",
+	"sar": "sar al, 1", "shl": "shl al, 1", "shr": "shr al, 1",
+	"in": "in al, 1", "out": "out 1, al",
+	"lds": "lds ax, [1]", "les": "les ax, [1]", "lea": "lea ax, [1]",
+	"mov": "mov al, 1", "xchg": "xchg ax, cx", "push": "push es", "pop": "pop es",
+	"ja": "label__0:\nja label__0", "jae": "label__0:\njae label__0", "jb": "label__0:\njb label__0",
+	"jbe": "label__0:\njbe label__0", "jg": "label__0:\njg label__0", "jge": "label__0:\njge label__0",
+	"jl": "label__0:\njl label__0", "jle": "label__0:\njle label__0", "jno": "label__0:\njno label__0",
+	"jnp": "label__0:\njnp label__0", "jns": "label__0:\njns label__0", "jnz": "label__0:\njnz label__0",
+	"jo": "label__0:\njo label__0", "jp": "label__0:\njp label__0", "js": "label__0:\njs label__0",
+	"jz": "label__0:\njz label__0", "jcxz": "label__0:\njcxz label__0", "loop": "label__0:\nloop label__0",
+	"loopnz": "label__0:\nloopnz label__0", "loopz": "label__0:\nloopz label__0",
+	"f2xm1": "f2xm1", "fabs": "fabs", "fchs": "fchs", "fcompp": "fcompp", "fdecstp": "fdecstp",
+	"fincstp": "fincstp", "fld1": "fld1", "fldl2e": "fldl2e", "fldl2t": "fldl2t", "fldlg2": "fldlg2",
+	"fldln2": "fldln2", "fldpi": "fldpi", "fldz": "fldz", "fnop": "fnop", "fpatan": "fpatan",
+	"fprem": "fprem", "fptan": "fptan", "frndint": "frndint", "fscale": "fscale", "fsqrt": "fsqrt",
+	"ftst": "ftst", "fxam": "fxam", "fxtract": "fxtract", "fyl2x": "fyl2x", "fyl2xp1": "fyl2xp1",
+	"fclex": "fclex", "fnclex": "fnclex", "fdisi": "fdisi", "fndisi": "fndisi",
+	"feni": "feni", "fneni": "fneni", "finit": "finit", "fninit": "fninit",
+	"fadd": "fadd st0, st0", "faddp": "faddp st0, st0", "fdiv": "fdiv st0, st0", "fdivp": "fdivp st0, st0",
+	"fdivr": "fdivr st0, st0", "fdivrp": "fdivrp st0, st0", "fmul": "fmul st0, st0", "fmulp": "fmulp st0, st0",
+	"fsub": "fsub st0, st0", "fsubp": "fsubp st0, st0", "fsubr": "fsubr st0, st0", "fsubrp": "fsubrp st0, st0",
+	"fcom": "fcom st0", "fcomp": "fcomp st0", "ffree": "ffree st0", "fld": "fld st0",
+	"fst": "fst st0", "fstp": "fstp st0", "fxch": "fxch st0",
+	"fbld": "fbld tword [1]", "fbstp": "fbstp tword [1]",
+	"fiadd": "fiadd word [1]", "ficom": "ficom word [1]", "ficomp": "ficomp word [1]", "fidiv": "fidiv word [1]",
+	"fidivr": "fidivr word [1]", "fild": "fild word [1]", "fimul": "fimul word [1]", "fist": "fist word [1]",
+	"fistp": "fistp word [1]", "fisub": "fisub word [1]", "fisubr": "fisubr word [1]",
+	"fldcw": "fldcw word [1]", "fstcw": "fstcw word [1]", "fnstcw": "fnstcw word [1]",
+	"fstsw": "fstsw word [1]", "fnstsw": "fnstsw word [1]",
+	"fldenv": "fldenv [1]", "fstenv": "fstenv [1]", "fnstenv": "fnstenv [1]",
+	"frstor": "frstor [1]", "fsave": "fsave [1]", "fnsave": "fnsave [1]",
+}
+
+func TestGoldenInstructions(t *testing.T) {
+	for _, mnemonic := range SupportedInstructions() {
+		if _, ok := goldenInstructions[mnemonic]; !ok {
+			t.Errorf("expected an example of the supported '%s' instruction", mnemonic)
+		}
+	}
+
+	for mnemonic, asm := range goldenInstructions {
+		asm += "\n"
+		t.Run(mnemonic, func(t *testing.T) {
+			d := NewDecoder(assemble(t, asm))
+			d.Allow186 = true
+			d.HideComments = true
+
+			contents, err := d.Decode()
+			if err != nil {
+				t.Fatalf("unexpected error = %v", err)
+			}
+			if string(contents) != asm {
+				t.Errorf("expected %q, got %q", asm, contents)
+			}
+		})
+	}
+}
+
+func TestInOut(t *testing.T) {
+	filename := part1("in-out")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	// the accumulator is the destination of in and the source of out
+	expected := "in al, 60\nin ax, dx\nout 20, al\nout dx, ax\n" +
+		"in ax, 60\nin al, dx\nout 20, ax\nout dx, al\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestIndirectCallJmp(t *testing.T) {
+	filename := part1("indirect-call-jmp")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	// registers carry their own size, memory operands are either near (word) or far
+	expected := "call ax\ncall word [bp - 100]\ncall word [39201]\ncall far [bp + si - 58]\ncall far [bx]\n" +
+		"jmp di\njmp word [di]\njmp word [bx + 4395]\njmp far [di]\njmp far [bp + 4]\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestAccumulatorMov(t *testing.T) {
+	filename := part1("accumulator-mov")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	// the byte forms have the 16-bit address as well, and the override applies to it
+	expected := "mov al, [4660]\nmov ax, [4660]\nmov [4660], al\nmov [4660], ax\n" +
+		"mov al, es:[4660]\nmov ax, es:[4660]\nmov cs:[4660], al\nmov ss:[4660], ax\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestXchg(t *testing.T) {
+	filename := part1("xchg")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	expected := "xchg cx, dx\nxchg si, cx\nxchg cl, ah\nxchg ax, cx\n" +
+		"xchg ax, [bx]\nxchg al, [bx + si]\nxchg dx, [bp - 4]\nxchg bh, [4660]\nxchg di, [bx + di + 1000]\n" +
+		"lock xchg [bx], ax\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestXchgAccumulator(t *testing.T) {
+	filename := part1("xchg-accumulator")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	expected := "xchg ax, ax\nxchg ax, cx\nxchg ax, dx\nxchg ax, bx\nxchg ax, sp\nxchg ax, bp\nxchg ax, si\nxchg ax, di\n"
+	if contents := decodeText(t, source); contents != expected || listingText(t, filename) != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestFarPointer(t *testing.T) {
+	source := []byte{
+		0b10011010, 0b11001000, 0b00000001, 0b01111011, 0b00000000, // call 123:456
+		0b11101010, 0b00010000, 0b00000000, 0b00110100, 0b00010010, // jmp 4660:16
+	}
+
+	d := NewDecoder(source)
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if expected := "call 123:456\njmp 4660:16\n"; string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	expected := []FarPointer{{Segment: 123, Offset: 456}, {Segment: 4660, Offset: 16}}
+	for idx, instruction := range d.Instructions() {
+		operand := instruction.Operands[0]
+		if operand.Type != OperandFarPointer || operand.Pointer != expected[idx] {
+			t.Errorf("expected the far pointer %v, got %+v", expected[idx], operand)
+		}
+	}
+}
+
+func TestExplicitJumpSize(t *testing.T) {
+	source := []byte{
+		0b11101000, 0b00000011, 0b00000000, // call 6
+		0b11101001, 0b00000000, 0b00000001, // jmp 262
+		0b11101011, 0b11111000, // jmp short label__0
+	}
+
+	if expected := "label__0:\ncall 6\njmp 262\njmp short label__0\n"; decodeText(t, source) != expected {
+		t.Errorf("expected %q, got %q", expected, decodeText(t, source))
+	}
+
+	d := NewDecoder(source)
+	d.ExplicitJumpSize = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if expected := "label__0:\ncall near 6\njmp near 262\njmp short label__0\n"; string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+}
+
+// listingText is the asm of the listing the way the decoder writes it: without the comments and the bits directive
+func listingText(t *testing.T, filename string) string {
+	t.Helper()
+
+	asm, err := os.ReadFile(filename + ".asm")
+	if err != nil {
+		t.Fatalf("%s.asm = %v", filename, err)
+	}
+
+	var text strings.Builder
+	for _, line := range strings.Split(string(asm), "\n") {
+		instruction, _, _ := strings.Cut(line, ";")
+		instruction = strings.TrimSpace(instruction)
+		if instruction != "" && instruction != "bits 16" {
+			text.WriteString(instruction + "\n")
+		}
+	}
+
+	return text.String()
+}
+
+func TestAddressingModes(t *testing.T) {
+	filename := part1("addressing-modes")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	if expected, contents := listingText(t, filename), decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestIncDec(t *testing.T) {
+	filename := part1("inc-dec")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	if expected, contents := listingText(t, filename), decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+var (
+	memoryOperand  = regexp.MustCompile(`\[[^\]]*\]`)
+	memoryTerm     = regexp.MustCompile(`\s*([+-])?\s*([0-9a-z]+)`)
+	immediateSize  = regexp.MustCompile(`^(\w+) ((?:\w+:)?\[[^\]]*\]), (byte|word) `)
+	labelReference = regexp.MustCompile(`\b\w+\b`)
+)
+
+// courseText is the listingText with the parts of the hand-written listings that aren't in the bytes written one way:
+// the spaces and the decimal numbers of the addresses ([bx+si-0x3a] is [bx + si - 58]), the lowercase registers,
+// [bp + 0] as [bp], the size keyword before the memory operand (mov [bx], byte 7 is mov byte [bx], 7),
+// the course names of the aliases (jnz is jne) and the labels numbered in the order they are defined
+// (test_label0, label -> label0, label1).
+// The listings write all of them both ways, while the bytes of the instructions are the same
+func courseText(t *testing.T, filename string) string {
+	t.Helper()
+
+	lines := strings.Split(strings.TrimSuffix(listingText(t, filename), "\n"), "\n")
+	labels := make(map[string]string)
+	for _, line := range lines {
+		if name, ok := strings.CutSuffix(line, ":"); ok {
+			labels[name] = fmt.Sprintf("label%d", len(labels))
+		}
+	}
+
+	var text strings.Builder
+	for _, line := range lines {
+		line = strings.ToLower(line)
+		line = strings.ReplaceAll(line, ",", ", ")
+		line = strings.Join(strings.Fields(line), " ")
+		line = memoryOperand.ReplaceAllStringFunc(line, func(operand string) string {
+			address := ""
+			for _, term := range memoryTerm.FindAllStringSubmatch(operand[1:len(operand)-1], -1) {
+				sign, value := term[1], term[2]
+				if number, err := strconv.ParseInt(value, 0, 32); err == nil {
+					value = strconv.Itoa(int(number))
+				}
+				if address != "" {
+					address += " " + sign + " "
+				}
+				address += value
+			}
+			return "[" + strings.TrimSuffix(address, " + 0") + "]"
+		})
+		line = immediateSize.ReplaceAllString(line, "$1 $3 $2, ")
+		line = labelReference.ReplaceAllStringFunc(line, func(word string) string {
+			if label, ok := labels[word]; ok {
+				return label
+			}
+			if name, ok := courseMnemonics[word]; ok {
+				return name
+			}
+			return word
+		})
+		text.WriteString(line + "\n")
+	}
+
+	return commutativeOrder(text.String())
+}
+
+// commutativeOrder writes the memory operand of xchg and test first, their encoding has no direction
+func commutativeOrder(text string) string {
+	lines := strings.Split(text, "\n")
+	for idx, line := range lines {
+		mnemonic, operands, _ := strings.Cut(line, " ")
+		if mnemonic == "lock" {
+			var name string
+			name, operands, _ = strings.Cut(operands, " ")
+			mnemonic += " " + name
+		}
+		if mnemonic != "xchg" && mnemonic != "lock xchg" && mnemonic != "test" {
+			continue
+		}
+
+		dest, src, ok := strings.Cut(operands, ", ")
+		if ok && strings.Contains(src, "[") && !strings.Contains(dest, "[") {
+			lines[idx] = mnemonic + " " + src + ", " + dest
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestCourseMode(t *testing.T) {
+	files := []string{
+		part1("listing_0037_single_register_mov"),
+		part1("listing_0038_many_register_mov"),
+		part1("listing_0039_more_movs"),
+		part1("listing_0040_challenge_movs"),
+		part1("listing_0041_add_sub_cmp_jnz"),
+		part1("listing_0042_completionist_decode"),
+	}
+
+	for _, filename := range files {
+		t.Run(path.Base(filename), func(t *testing.T) {
+			source, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("%s = %v", filename, err)
+			}
+
+			contents, err := CourseMode(source).Decode()
+			if err != nil {
+				t.Fatalf("%s = %v", filename, err)
+			}
+			if expected, contents := courseText(t, filename), commutativeOrder(string(contents)); contents != expected {
+				t.Errorf("expected %q, got %q", expected, contents)
+			}
+		})
+	}
+}
+
+func TestCourseOptions(t *testing.T) {
+	source := []byte{
+		0b10111001, 0b11110100, 0b11111111, // mov cx, 65524
+		0b00100100, 0b11101111, // and al, 239
+		0b10000000, 0b00000111, 0b00100010, // add [bx], byte 34
+		0b11111111, 0b00010110, 0b00100001, 0b10011001, // call word [39201]
+		0b10001011, 0b01010110, 0b00000000, // mov dx, [bp + 0]
+		0b11110011, 0b10100110, // repz cmpsb
+		0b01110100, 0b11111110, // jz label__17
+		0b11101011, 0b11101011, // jmp short label__0
+	}
+
+	d := NewDecoder(source)
+	d.HideComments = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	expected := "label__0:\nmov cx, 65524\nand al, 239\nadd [bx], byte 34\ncall word [39201]\nmov dx, [bp + 0]\n" +
+		"repz cmpsb\nlabel__17:\njz label__17\njmp short label__0\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	d.SignedImmediates = true
+	d.MinimalSizeKeywords = true
+	d.OmitBPDisplacement = true
+	d.CourseMnemonics = true
+	d.OrdinalLabels = true
+	expected = "label0:\nmov cx, -12\nand al, 239\nadd byte [bx], 34\ncall [39201]\nmov dx, [bp]\n" +
+		"rep cmpsb\nlabel1:\nje label1\njmp label0\n"
+	if contents := string(d.GetDecoded()); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+	if labels := d.Labels(); labels[0] != "label0" || labels[17] != "label1" {
+		t.Errorf("expected the numbered labels, got %v", labels)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	source := []byte{
+		0b11010110,             // salc, undocumented
+		0b10001001, 0b11011000, // mov ax, bx
+		0b00100110, 0b11010110, // es: salc
+	}
+
+	d := NewDecoder(source)
+	d.Override(0b11010110, func(operation byte, d *Decoder) (Instruction, error) {
+		return Instruction{Mnemonic: "salc"}, nil
+	})
+	// the override takes precedence over the built-in mov
+	d.Override(0b10001001, func(operation byte, d *Decoder) (Instruction, error) {
+		operand, err := d.ReadByte()
+		if err != nil {
+			return Instruction{}, err
+		}
+		return Instruction{Mnemonic: "db", Operands: []Operand{immediateOperand(uint16(operation), ImmediateByte), immediateOperand(uint16(operand), ImmediateByte)}}, nil
+	})
+
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if expected := "salc\ndb 137, 216\nsalc\n"; string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+	if instruction := d.Instructions()[2]; instruction.Size != 2 || instruction.Category != "OVERRIDE: 11010110" {
+		t.Errorf("expected the prefix to be a part of the overridden instruction, got %+v", instruction)
+	}
+
+	// the errors of the handler are returned like the ones of the built-in handlers
+	d = NewDecoder([]byte{0b10001001})
+	d.Override(0b10001001, func(operation byte, d *Decoder) (Instruction, error) {
+		_, err := d.ReadByte()
+		return Instruction{}, err
+	})
+	if _, err := d.Decode(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestLines(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011001, // mov cx, bx
+		0b01110101, 0b11111100, // jnz label__0
+	}
+
+	d := NewDecoder(source)
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := []Line{
+		{Offset: 0, Text: "label__0:"},
+		{Offset: 0, Text: "mov cx, bx"},
+		{Offset: 2, Text: "jnz label__0 ; jne"},
+	}
+	lines := d.Lines()
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %v, got %v", expected, lines)
+	}
+
+	text := ""
+	for _, line := range lines {
+		text += line.Text + "\n"
+	}
+	if text != string(contents) {
+		t.Errorf("expected the lines to match the output %q, got %q", string(contents), text)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	filename := part1("listing_0041_add_sub_cmp_jnz")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	var builder strings.Builder
+	d := NewDecoder(source)
+	written, err := d.WriteTo(&builder)
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	// the backward jumps have their labels too
+	expected := decodeText(t, source)
+	if builder.String() != expected {
+		t.Errorf("expected %q, got %q", expected, builder.String())
+	}
+	if written != int64(len(expected)) {
+		t.Errorf("expected %d bytes to be written, got %d", len(expected), written)
+	}
+	if len(d.Instructions()) != 0 {
+		t.Errorf("expected the instructions not to be kept, got %d", len(d.Instructions()))
+	}
+}
+
+func TestDoubleSegmentOverride(t *testing.T) {
+	source := []byte{
+		0b00100110, 0b00111110, 0b10001011, 0b00000111, // es: ds: mov ax, [bx]
+	}
+
+	_, err := NewDecoder(source).Decode()
+	if err == nil || !strings.Contains(err.Error(), "single segment override") {
+		t.Fatalf("expected the segment override error, got %v", err)
+	}
+
+	d := NewDecoder(source)
+	d.EmitDataOnError = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "db 0x26\nmov ax, ds:[bx]\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+}
+
+func TestAnnotateCategory(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10111001, 0b11111111, 0b11111111, // mov cx, -1
+	}
+
+	d := NewDecoder(source)
+	d.AnnotateCategory = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "mov ax, bx ; MOV: Register/memory to/from register\n" +
+		"mov cx, 65535 ; or -1; MOV: Immediate to register\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	d.AnnotateCategory = false
+	expected = "mov ax, bx\nmov cx, 65535 ; or -1\n"
+	if contents := string(d.GetDecoded()); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+// TestEncodeRoundTrip decodes the listings, encodes the instructions back and decodes the result again.
+// The instructions must be the same, so the test doesn't depend on nasm
+func TestEncodeRoundTrip(t *testing.T) {
+	files := []string{
+		part1("listing_0037_single_register_mov"),
+		part1("listing_0038_many_register_mov"),
+		part1("reg-memory-with-displacement"),
+		part1("listing_0039_more_movs"),
+		part1("signed-displacement"),
+		part1("listing_0040_challenge_movs"),
+		part1("listing_0041_add_sub_cmp_jnz"),
+		part1("listing_0043_immediate_movs"),
+		part1("listing_0044_register_movs"),
+		part1("listing_0045_challenge_register_movs"),
+		part1("listing_0048_ip_register"),
+	}
+
+	for _, filename := range files {
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("%s = %v", filename, err)
+		}
+
+		d := NewDecoder(source)
+		if _, err := d.Decode(); err != nil {
+			t.Fatalf("%s = %v", filename, err)
+		}
+
+		encoded := make([]byte, 0, len(source))
+		for _, instruction := range d.Instructions() {
+			bytes, err := Encode(instruction)
+			if err != nil {
+				t.Fatalf("%s: %s = %v", filename, instruction, err)
+			}
+			encoded = append(encoded, bytes...)
+		}
+
+		roundTrip := NewDecoder(encoded)
+		if _, err := roundTrip.Decode(); err != nil {
+			t.Fatalf("%s: the encoded instructions failed to decode = %v", filename, err)
+		}
+
+		if !reflect.DeepEqual(d.Instructions(), roundTrip.Instructions()) {
+			t.Errorf("%s: the instructions differ after the round trip\n%s\n%s", filename, d.GetDecoded(), roundTrip.GetDecoded())
+		}
+	}
+}
+
+func TestDisplacementWidth(t *testing.T) {
+	cases := []struct {
+		source []byte
+		disp   int16
+		width  int
+	}{
+		{[]byte{0b10001011, 0b00000111}, 0, 0},                              // mov ax, [bx]
+		{[]byte{0b10001011, 0b01000111, 0b00000000}, 0, 1},                  // mov ax, [bx + 0]
+		{[]byte{0b10001011, 0b10000111, 0b00000000, 0b00000000}, 0, 2},      // mov ax, [bx + word 0]
+		{[]byte{0b10001011, 0b01000111, 0b11111101}, -3, 1},                 // mov ax, [bx - 3]
+		{[]byte{0b10001011, 0b10000111, 0b00000000, 0b10000000}, -32768, 2}, // mov ax, [bx - 32768]
+		{[]byte{0b10001011, 0b00001110, 0b11101000, 0b00000011}, 1000, 2},   // mov cx, [1000]
+	}
+
+	for _, c := range cases {
+		d := NewDecoder(c.source)
+		instruction, _, err := d.Next()
+		if err != nil {
+			t.Fatalf("% x: unexpected error = %v", c.source, err)
+		}
+
+		memory := instruction.Operands[1].Memory
+		if memory.Disp() != c.disp || memory.DispWidth() != c.width {
+			t.Errorf("% x: expected the displacement %d of %d bytes, got %d of %d", c.source, c.disp, c.width, memory.Disp(), memory.DispWidth())
+		}
+
+		// the structured form keeps the exact encoding
+		encoded, err := Encode(instruction)
+		if err != nil {
+			t.Fatalf("% x: unexpected error = %v", c.source, err)
+		}
+		if !reflect.DeepEqual(encoded, c.source) {
+			t.Errorf("expected % x, got % x", c.source, encoded)
+		}
+	}
+}
+
+func TestAssembleLine(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []byte
+	}{
+		{"mov ax, bx", []byte{0b10001001, 0b11011000}},
+		{"mov cl, 12", []byte{0b10110001, 0b00001100}},
+		{"mov cx, -12", []byte{0b10111001, 0b11110100, 0b11111111}},
+		{"add cx, 5 ; sign-extended", []byte{0b10000011, 0b11000001, 0b00000101}},
+		{"add ax, 1000", []byte{0b00000101, 0b11101000, 0b00000011}},
+		{"sub al, 0x10", []byte{0b00101100, 0b00010000}},
+		{"CMP BX, CX", []byte{0b00111001, 0b11001011}},
+		{"\tmov\tax, bx", []byte{0b10001001, 0b11011000}},
+		{"sub  cx,\t5", []byte{0b10000011, 0b11101001, 0b00000101}},
+	}
+
+	for _, c := range cases {
+		encoded, err := Assemble(c.line)
+		if err != nil {
+			t.Errorf("%s: unexpected error = %v", c.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(encoded, c.expected) {
+			t.Errorf("%s: expected %08b, got %08b", c.line, c.expected, encoded)
+		}
+	}
+
+	unsupported := map[string]string{
+		"mov ax, [bx]":  "memory operands",
+		"mov ax":        "expected 2 operands",
+		"inc ax":        "isn't supported",
+		"mov al, 300":   "doesn't fit into a byte",
+		"mov ax, bl":    "of the same size",
+		"add 5, ax":     "invalid destination",
+		"sub cx, label": "expected a register or a number",
+	}
+	for line, message := range unsupported {
+		if _, err := Assemble(line); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected the error with %q, got %v", line, message, err)
+		}
+	}
+}
+
+func TestMaxInstructionSize(t *testing.T) {
+	source := []byte{
+		0b11110000, 0b00101110, 0b10000001, 0b10000111, 0b00110100, 0b00010010, 0b00001100, 0b00000000, // lock add cs:[bx + 4660], word 12
+	}
+
+	// the longest instruction fits the default limit
+	expected := "lock add cs:[bx + 4660], word 12\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+
+	d := NewDecoder(source)
+	d.MaxInstructionSize = 6
+	if _, err := d.Decode(); err == nil || !strings.Contains(err.Error(), "8 bytes long") {
+		t.Errorf("expected the instruction size error, got %v", err)
+	}
+}
+
+func TestHexLabels(t *testing.T) {
+	source := []byte{
+		0b01110101, 0b00000010, // jnz label_0x4
+		0b10001001, 0b11011001, // mov cx, bx
+		0b11101011, 0b11111010, // jmp short label_0x0
+	}
+
+	d := NewDecoder(source)
+	d.HexLabels = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "label_0x0:\njnz label_0x4 ; jne\nmov cx, bx\nlabel_0x4:\njmp short label_0x0\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	d.Syntax = SyntaxATT
+	if contents := string(d.GetDecoded()); !strings.Contains(contents, "jmp label_0x0") {
+		t.Errorf("expected the AT&T syntax to use the hex labels, got %q", contents)
+	}
+}
+
+func TestPopCS(t *testing.T) {
+	source := []byte{
+		0b00001111, // pop cs
+		0b00011111, // pop ds
+	}
+
+	// the 286+ two-byte opcode escape by default
+	if _, err := NewDecoder(source).Decode(); err == nil || !strings.Contains(err.Error(), "set PopCS") {
+		t.Errorf("expected 0x0f to be rejected without PopCS, got %v", err)
+	}
+
+	d := NewDecoder(source)
+	d.EmitDataOnError = true
+	if contents, _ := d.Decode(); string(contents) != "db 0x0f\npop ds\n" {
+		t.Errorf("expected 0x0f to be emitted as data without PopCS, got %q", contents)
+	}
+
+	expected := "pop cs\npop ds\n"
+	d = NewDecoder(source)
+	d.PopCS = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	d = NewDecoder(source)
+	d.PopCS = true
+	d.Strict = true
+	contents, err = d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error in the strict mode = %v", err)
+	}
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+}
+
+func TestAllow386Segments(t *testing.T) {
+	source := []byte{
+		0b01100100, 0b10001011, 0b00000111, // mov ax, fs:[bx]
+		0b01100101, 0b10001000, 0b01000111, 0b00000100, // mov gs:[bx + 4], al
+	}
+
+	d := NewDecoder(source)
+	d.EmitDataOnError = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if !strings.HasPrefix(string(contents), "db 0x64\n") {
+		t.Errorf("expected fs: to be data on the 8086, got %q", contents)
+	}
+
+	d = NewDecoder(source)
+	d.Allow386Segments = true
+	contents, err = d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "mov ax, fs:[bx]\nmov gs:[bx + 4], al\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+}
+
+func TestOnInstruction(t *testing.T) {
+	source := []byte{
+		0b11110000, 0b10000111, 0b00000111, // lock xchg [bx], ax
+		0b10001001, 0b11011001, // mov cx, bx
+	}
+
+	observed := make([]string, 0)
+	d := NewDecoder(source)
+	d.OnInstruction = func(offset int, instruction Instruction) {
+		observed = append(observed, fmt.Sprintf("%d: %s", offset, instruction))
+	}
+
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := []string{"0: lock xchg [bx], ax", "3: mov cx, bx"}
+	if !reflect.DeepEqual(observed, expected) {
+		t.Errorf("expected %q, got %q", expected, observed)
+	}
+
+	// every instruction is observed once
+	observed = observed[:0]
+	if _, err := d.WriteTo(io.Discard); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(observed, expected) {
+		t.Errorf("expected %q, got %q", expected, observed)
+	}
+}
+
+// TestJumpAfterPrefixes checks that the targets are relative to the end of the jump,
+// not affected by the prefixes of the preceding instructions
+func TestJumpAfterPrefixes(t *testing.T) {
+	source := []byte{
+		0b00100110, 0b10001011, 0b00000111, // mov ax, es:[bx]
+		0b11110000, 0b10000111, 0b00000111, // lock xchg [bx], ax
+		0b01110101, 0b11111000, // jnz label__0
+		0b01110100, 0b11111001, // jz label__3
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	instructions := d.Instructions()
+	if target := instructions[2].Operands[0].Target; instructions[2].Offset != 6 || target != 0 {
+		t.Errorf("expected the jump at 6 to target 0, got the jump at %d to %d", instructions[2].Offset, target)
+	}
+	if target := instructions[3].Operands[0].Target; target != 3 {
+		t.Errorf("expected the jump to target 3, got %d", target)
+	}
+
+	expected := "label__0:\nmov ax, es:[bx]\nlabel__3:\nlock xchg [bx], ax\njnz label__0 ; jne\njz label__3 ; je\n"
+	if contents := string(d.GetDecoded()); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestRepeatPrefixOffsets(t *testing.T) {
+	source := []byte{
+		0b11110011, 0b10100100, // rep movsb
+		0b11110010, 0b10101110, // repnz scasb
+		0b01110101, 0b11111010, // jnz label__0
+		0b01110100, 0b11111010, // jz label__2
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	// the prefix is a part of the instruction, the offsets and the sizes include it
+	offsets := make([]int, 0)
+	for _, instruction := range d.Instructions() {
+		offsets = append(offsets, instruction.Offset, instruction.Size)
+	}
+	if expected := []int{0, 2, 2, 2, 4, 2, 6, 2}; !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("expected the offsets and the sizes %v, got %v", expected, offsets)
+	}
+
+	expected := "label__0:\nrep movsb\nlabel__2:\nrepnz scasb\njnz label__0 ; jne\njz label__2 ; je\n"
+	if contents := string(d.GetDecoded()); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011001, // mov cx, bx
+		0b00000001, 0b11011000, // add ax, bx
+		0b10001001, 0b11000011, // mov bx, ax
+		0b01110101, 0b11111000, // jnz label__0
+	}
+
+	d := NewDecoder(source)
+	d.Filter = func(instruction Instruction) bool {
+		return instruction.Mnemonic == "mov"
+	}
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "label__0:\nmov cx, bx\nmov bx, ax\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	var builder strings.Builder
+	if _, err := d.WriteTo(&builder); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if builder.String() != expected {
+		t.Errorf("expected %q, got %q", expected, builder.String())
+	}
+
+	if len(d.Instructions()) != 4 {
+		t.Errorf("expected the skipped instructions to be kept, got %d instructions", len(d.Instructions()))
+	}
+
+	d.Filter = nil
+	if contents := string(d.GetDecoded()); !strings.Contains(contents, "add ax, bx") {
+		t.Errorf("expected all the instructions without the filter, got %q", contents)
+	}
+}
+
+func TestIsNoOp(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11000000, // mov ax, ax
+		0b10000111, 0b11001001, // xchg cx, cx
+		0b10000011, 0b11000010, 0b00000000, // add dx, 0
+		0b00001001, 0b11011011, // or bx, bx
+		0b10010000,             // nop
+		0b10001001, 0b11011000, // mov ax, bx
+		0b00110001, 0b11000000, // xor ax, ax
+		0b10000011, 0b00000111, 0b00000000, // add word [bx], 0
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	noOps := make([]bool, 0)
+	for _, instruction := range d.Instructions() {
+		noOps = append(noOps, instruction.IsNoOp())
+	}
+	if expected := []bool{true, true, true, true, true, false, false, false}; !reflect.DeepEqual(noOps, expected) {
+		t.Errorf("expected %v, got %v", expected, noOps)
+	}
+}
+
+func TestIndexOf(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011001, // mov cx, bx
+		0b10111001, 0b00001100, 0b00000000, // mov cx, 12
+		0b11110100, // hlt
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	tests := []struct {
+		offset int
+		index  int
+		ok     bool
+	}{
+		{offset: 0, index: 0, ok: true},
+		{offset: 2, index: 1, ok: true},
+		{offset: 4, index: 1, ok: true}, // inside mov cx, 12
+		{offset: 5, index: 2, ok: true},
+		{offset: 6, ok: false},
+		{offset: -1, ok: false},
+	}
+
+	for _, test := range tests {
+		index, ok := d.IndexOf(test.offset)
+		if ok != test.ok || index != test.index {
+			t.Errorf("offset %d: expected (%d, %v), got (%d, %v)", test.offset, test.index, test.ok, index, ok)
+		}
+	}
+}
+
+// TestStandaloneWait checks that WAIT is a separate instruction unless it's followed by ESC
+func TestStandaloneWait(t *testing.T) {
+	source := []byte{
+		0b10011011,             // wait
+		0b10001001, 0b11011000, // mov ax, bx
+		0b10011011,                         // wait
+		0b10011011,                         // wait
+		0b00100110, 0b10001011, 0b00000111, // mov ax, es:[bx]
+		0b10011011,                         // wait
+		0b11110000, 0b10000111, 0b00000111, // lock xchg [bx], ax
+	}
+
+	d := NewDecoder(source)
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "wait\nmov ax, bx\nwait\nwait\nmov ax, es:[bx]\nwait\nlock xchg [bx], ax\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	for _, instruction := range d.Instructions() {
+		if instruction.Mnemonic == "wait" && instruction.Size != 1 {
+			t.Errorf("expected the wait at %d to be 1 byte, got %d", instruction.Offset, instruction.Size)
+		}
+	}
+}
+
+// TestCapabilities makes sure the implemented features don't silently regress
+func TestCapabilities(t *testing.T) {
+	capabilities := Capabilities()
+	implemented := map[string]bool{
+		"Coprocessor":      capabilities.Coprocessor,
+		"SegmentMove":      capabilities.SegmentMove,
+		"Return":           capabilities.Return,
+		"StringPrefixes":   capabilities.StringPrefixes,
+		"Segments386":      capabilities.Segments386,
+		"Instructions186":  capabilities.Instructions186,
+		"RecursiveDescent": capabilities.RecursiveDescent,
+		"SyntaxATT":        capabilities.SyntaxATT,
+		"SyntaxObjdump":    capabilities.SyntaxObjdump,
+		"Encode":           capabilities.Encode,
+	}
+
+	for name, ok := range implemented {
+		if !ok {
+			t.Errorf("expected the '%s' capability", name)
+		}
+	}
+
+	if capabilities.NearJumps386 {
+		t.Errorf("expected the 386 near jumps not to be decoded")
+	}
+
+	// the capabilities match the opcode table
+	supported := make(map[string]bool)
+	for _, mnemonic := range SupportedInstructions() {
+		supported[mnemonic] = true
+	}
+	table := map[string]bool{
+		"Coprocessor":     supported["fstcw"] && supported["fnstcw"],
+		"Return":          supported["ret"] && supported["retf"],
+		"StringPrefixes":  supported["rep"] && supported["repz"] && supported["repnz"],
+		"Instructions186": supported["enter"] && supported["leave"] && supported["pusha"] && supported["bound"],
+	}
+	for name, ok := range table {
+		if ok != implemented[name] {
+			t.Errorf("expected the '%s' capability to be %v like the opcode table", name, ok)
+		}
+	}
+
+	// a decoder only reports the options it has
+	d := NewDecoder(nil)
+	if features := d.Capabilities(); features.Segments386 || features.Instructions186 || !features.SegmentMove {
+		t.Errorf("expected the default decoder to reject fs/gs and the 80186 instructions, got %+v", features)
+	}
+	d.Allow186, d.Allow386Segments = true, true
+	if features := d.Capabilities(); features != capabilities {
+		t.Errorf("expected %+v with every option, got %+v", capabilities, features)
+	}
+}
+
+func TestAbsSigned(t *testing.T) {
+	for value, expected := range map[int8]uint8{0: 0, -1: 1, -128: 128, 127: 127, -5: 5} {
+		if abs := absSigned8(value); abs != expected {
+			t.Errorf("absSigned8(%d): expected %d, got %d", value, expected, abs)
+		}
+	}
+
+	for value, expected := range map[int16]uint16{0: 0, -1: 1, -128: 128, 127: 127, -32768: 32768, 32767: 32767} {
+		if abs := absSigned16(value); abs != expected {
+			t.Errorf("absSigned16(%d): expected %d, got %d", value, expected, abs)
+		}
+	}
+
+	source := []byte{
+		0b10001011, 0b01000111, 0b10000000, // mov ax, [bx - 128]
+		0b10001011, 0b10000111, 0b00000000, 0b10000000, // mov ax, [bx - 32768]
+	}
+
+	expected := "mov ax, [bx - 128]\nmov ax, [bx - 32768]\n"
+	if contents := decodeText(t, source); contents != expected {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+}
+
+func TestEnterLeave(t *testing.T) {
+	source := []byte{
+		0b11001000, 0b00010000, 0b00000000, 0b00000001, // enter 16, 1
+		0b11001001, // leave
+	}
+
+	_, err := NewDecoder(source).Decode()
+	if err == nil || !strings.Contains(err.Error(), "80186 instruction") {
+		t.Errorf("expected the 80186 instruction error, got %v", err)
+	}
+
+	d := NewDecoder(source)
+	d.Allow186 = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	expected := "enter 16, 1\nleave\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	// gas doesn't reverse the two immediates of enter
+	syntaxes := []struct {
+		syntax   Syntax
+		expected string
+	}{
+		{SyntaxATT, "enter $16, $1\nleave\n"},
+		{SyntaxObjdump, "enter  $0x10,$0x1\nleave\n"},
+	}
+	for _, syntax := range syntaxes {
+		d.Syntax = syntax.syntax
+		if contents := string(d.GetDecoded()); contents != syntax.expected {
+			t.Errorf("expected %q, got %q", syntax.expected, contents)
+		}
+	}
+}
+
+func TestInstructions186(t *testing.T) {
+	filename := part1("instructions-186")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	if _, err := NewDecoder(source).Decode(); err == nil || !strings.Contains(err.Error(), "80186 instruction") {
+		t.Errorf("expected the 80186 instruction error, got %v", err)
+	}
+
+	d := NewDecoder(source)
+	d.Allow186 = true
+	contents, err := d.Decode()
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	expected := "pusha\npopa\nbound ax, [bx]\nbound si, [bp + 4]\n" +
+		"imul ax, bx, 1000\nimul cx, [bx + si], 65534 ; or -2\nimul dx, di, 5\n" +
+		"enter 16, 1\nleave\n"
+	if string(contents) != expected {
+		t.Errorf("expected %q, got %q", expected, string(contents))
+	}
+
+	// gas doesn't reverse the operands of bound and enter
+	d.Syntax = SyntaxATT
+	expectedATT := "pusha\npopa\nbound %ax, (%bx)\nbound %si, 4(%bp)\n" +
+		"imul $1000, %bx, %ax\nimul $65534, (%bx,%si), %cx # or -2\nimul $5, %di, %dx\n" +
+		"enter $16, $1\nleave\n"
+	if contents := string(d.GetDecoded()); contents != expectedATT {
+		t.Errorf("expected %q, got %q", expectedATT, contents)
+	}
+	d.Syntax = SyntaxIntel
+
+	if _, err := exec.LookPath("nasm"); err != nil {
+		t.Skip("nasm is required to verify the 80186 instructions")
+	}
+
+	verifyAssembled(t, append([]byte(d.Header("")), contents...), source, filename)
+}
+
+func TestDecodeAll(t *testing.T) {
+	source := []byte{
+		0b10001001, 0b11011001, // mov cx, bx
+		0b11110001,             // undefined
+		0b10001001, 0b11011001, // mov cx, bx
+		0b10001001, 0b11011001, // mov cx, bx
+		0b10001001, 0b11011001, // mov cx, bx
+		0b01100000,             // pusha - 80186
+		0b10001001, 0b11011001, // mov cx, bx
+		0b10001001, 0b11011001, // mov cx, bx
+		0b10001001, 0b11011001, // mov cx, bx
+		0b10111001, 0b00001100, // mov cx, 12 - truncated
+	}
+
+	d := NewDecoder(source)
+	instructions, decodeErrors := d.DecodeAll()
+
+	offsets := make([]int, 0)
+	for _, decodeError := range decodeErrors {
+		offsets = append(offsets, decodeError.Offset)
+	}
+	if !reflect.DeepEqual(offsets, []int{2, 9, 16}) {
+		t.Errorf("expected the errors at 2, 9 and 16, got %v", decodeErrors)
+	}
+	if !strings.Contains(decodeErrors[1].Error(), "80186 instruction") {
+		t.Errorf("expected the 80186 instruction error, got %v", decodeErrors[1])
+	}
+	if consumed := decodeErrors[1].Consumed; !reflect.DeepEqual(consumed, []byte{0b01100000}) {
+		t.Errorf("expected pusha to be consumed, got %08b", consumed)
+	}
+
+	mnemonics := make([]string, 0)
+	for _, instruction := range instructions {
+		mnemonics = append(mnemonics, instruction.Mnemonic)
+	}
+	expected := []string{"mov", "db", "mov", "mov", "mov", "db", "mov", "mov", "mov", "db", "db"}
+	if !reflect.DeepEqual(mnemonics, expected) {
+		t.Errorf("expected %v, got %v", expected, mnemonics)
+	}
+
+	if d.Resync {
+		t.Errorf("expected Resync to be restored")
+	}
+
+	// the instructions decoded before are replaced rather than appended to
+	again, decodeErrors := d.DecodeAll()
+	if len(again) != len(expected) || len(decodeErrors) != 3 {
+		t.Errorf("expected %d instructions and 3 errors the second time, got %d and %d", len(expected), len(again), len(decodeErrors))
+	}
+
+	d = NewDecoder(source[:2])
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if instructions, _ := d.DecodeAll(); len(instructions) != 1 || len(d.Instructions()) != 1 {
+		t.Errorf("expected a single instruction after Decode and DecodeAll, got %d", len(instructions))
+	}
+}
+
+func TestEncodeBinary(t *testing.T) {
+	filename := part1("listing_0041_add_sub_cmp_jnz")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%s = %v", filename, err)
+	}
+
+	d := NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	var encoded strings.Builder
+	if err := d.EncodeBinary(&encoded); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	instructions, err := DecodeBinary(strings.NewReader(encoded.String()))
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if len(instructions) != len(d.Instructions()) {
+		t.Fatalf("expected %d instructions, got %d", len(d.Instructions()), len(instructions))
+	}
+
+	for idx, instruction := range instructions {
+		expected := d.Instructions()[idx]
+		if instruction.Offset != expected.Offset || instruction.Size != expected.Size || instruction.Category != expected.Category {
+			t.Errorf("expected %+v, got %+v", expected, instruction)
+		}
+		if instruction.String() != expected.String() {
+			t.Errorf("expected %q, got %q", expected.String(), instruction.String())
+		}
+	}
+
+	if _, err := DecodeBinary(strings.NewReader("garbage")); err == nil {
+		t.Errorf("expected an error for the malformed input")
+	}
+}
@@ -0,0 +1,329 @@
+package decoder
+
+import "fmt"
+
+// arithmeticOperations is the op field of the arithmetic and logic instructions.
+// [00|op|0dw] reg/mem with reg, [00|op|10w] immediate to accumulator, [100000sw] [mod|op|r/m] immediate to reg/mem
+var arithmeticOperations = map[string]byte{
+	"add": 0b000,
+	"or":  0b001,
+	"adc": 0b010,
+	"sbb": 0b011,
+	"and": 0b100,
+	"sub": 0b101,
+	"xor": 0b110,
+	"cmp": 0b111,
+}
+
+// singleByteInstructions have no operands
+var singleByteInstructions = map[string]byte{
+	"hlt":   0b11110100,
+	"cbw":   0b10011000,
+	"cwd":   0b10011001,
+	"lahf":  0b10011111,
+	"sahf":  0b10011110,
+	"pushf": 0b10011100,
+	"popf":  0b10011101,
+	"clc":   0b11111000,
+	"stc":   0b11111001,
+	"cmc":   0b11110101,
+	"cld":   0b11111100,
+	"std":   0b11111101,
+	"cli":   0b11111010,
+	"sti":   0b11111011,
+	"int3":  0b11001100,
+	"into":  0b11001110,
+	"iret":  0b11001111,
+	"xlat":  0b11010111,
+}
+
+var prefixEncoding = map[string]byte{
+	"lock":  0b11110000,
+	"rep":   0b11110011,
+	"repz":  0b11110011,
+	"repnz": 0b11110010,
+}
+
+// Encode turns the instruction back into the machine code. The instruction is placed at its Offset,
+// which the jumps are relative to. The encoding follows the one NASM picks: the accumulator and the short forms
+// are preferred, and Immediate.Encoding decides between the word and the sign-extended byte.
+// Only a subset of the instruction set is supported: mov, the arithmetic and logic instructions,
+// the jumps, the loops, call and the instructions without operands
+func Encode(instruction Instruction) ([]byte, error) {
+	encoded := make([]byte, 0, 6)
+	if instruction.Prefix != "" {
+		prefix, ok := prefixEncoding[instruction.Prefix]
+		if !ok {
+			return nil, fmt.Errorf("unknown prefix '%s' of the '%s' instruction", instruction.Prefix, instruction.Mnemonic)
+		}
+		encoded = append(encoded, prefix)
+	}
+
+	for _, operand := range instruction.Operands {
+		if operand.Type == OperandMemory && operand.Memory.Segment != "" {
+			segment, ok := registerIndex(SegmentRegisterFieldEncoding, operand.Memory.Segment)
+			if !ok {
+				return nil, fmt.Errorf("unknown segment '%s' of the '%s' instruction", operand.Memory.Segment, instruction.Mnemonic)
+			}
+			// [001|reg|110]
+			encoded = append(encoded, 0b00100110|segment<<3)
+		}
+	}
+
+	// the jumps are relative to the end of the instruction
+	end := instruction.Offset + len(encoded)
+
+	var body []byte
+	var err error
+	operands := instruction.Operands
+	mnemonic := instruction.Mnemonic
+
+	if opcode, ok := singleByteInstructions[mnemonic]; ok && len(operands) == 0 {
+		body = []byte{opcode}
+	} else if op, ok := arithmeticOperations[mnemonic]; ok && len(operands) == 2 {
+		body, err = encodeArithmetic(op, operands[0], operands[1])
+	} else if mnemonic == "mov" && len(operands) == 2 {
+		body, err = encodeMove(operands[0], operands[1])
+	} else if len(operands) == 1 && operands[0].Type == OperandLabel {
+		body, err = encodeShortJump(mnemonic, operands[0].Target-(end+2))
+	} else if len(operands) == 1 && operands[0].Type == OperandOffset && (mnemonic == "jmp" || mnemonic == "call") {
+		// [11101001|11101000] [ip-inc-lo] [ip-inc-hi]
+		opcode := byte(0b11101001)
+		if mnemonic == "call" {
+			opcode = 0b11101000
+		}
+		increment := uint16(operands[0].Target - (end + 3))
+		body = []byte{opcode, byte(increment), byte(increment >> 8)}
+	} else {
+		err = fmt.Errorf("the '%s' instruction can't be encoded", Format(instruction, FormatOptions{HideComments: true}))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(encoded, body...), nil
+}
+
+// [opcode] [rel8]
+func encodeShortJump(mnemonic string, increment int) ([]byte, error) {
+	if increment < -128 || increment > 127 {
+		return nil, fmt.Errorf("the target of the '%s' instruction is out of the short jump range: %d", mnemonic, increment)
+	}
+
+	if mnemonic == "jmp" {
+		return []byte{0b11101011, byte(int8(increment))}, nil
+	}
+
+	for opcode, name := range JumpNames {
+		if name == mnemonic {
+			return []byte{opcode, byte(int8(increment))}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("the '%s' instruction can't be encoded as a short jump", mnemonic)
+}
+
+// [00|op|0dw] [mod|reg|r/m], [00|op|10w] [data], [100000sw] [mod|op|r/m] [data]
+func encodeArithmetic(op byte, dest Operand, src Operand) ([]byte, error) {
+	if src.Type == OperandImmediate {
+		isWord, err := operandIsWord(dest, src)
+		if err != nil {
+			return nil, err
+		}
+
+		if isAccumulator(dest) && src.Immediate.Encoding != ImmediateSignExtendedByte {
+			return append([]byte{op<<3 | 0b100 | boolBit(isWord)}, encodeImmediate(src.Immediate.Value, isWord)...), nil
+		}
+
+		signExtended := src.Immediate.Encoding == ImmediateSignExtendedByte
+		modrm, err := encodeRegOrMem(op, dest)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded := append([]byte{0b10000000 | boolBit(signExtended)<<1 | boolBit(isWord)}, modrm...)
+		return append(encoded, encodeImmediate(src.Immediate.Value, isWord && !signExtended)...), nil
+	}
+
+	encoded, err := encodeRegWithRegOrMem(dest, src)
+	if err != nil {
+		return nil, err
+	}
+	encoded[0] |= op << 3
+	return encoded, nil
+}
+
+// the forms of mov NASM picks: the accumulator with the direct address, the immediate to register,
+// the immediate to memory, the segment registers and the register/memory to/from register
+func encodeMove(dest Operand, src Operand) ([]byte, error) {
+	switch {
+	// [101000|d|w] [addr-lo] [addr-hi]
+	case isAccumulator(dest) && isDirectAddressOperand(src):
+		address := src.Memory.Displacement
+		return []byte{0b10100000 | boolBit(isWordRegister(dest.Register)), byte(address), byte(address >> 8)}, nil
+	case isDirectAddressOperand(dest) && isAccumulator(src):
+		address := dest.Memory.Displacement
+		return []byte{0b10100010 | boolBit(isWordRegister(src.Register)), byte(address), byte(address >> 8)}, nil
+
+	// [1011|w|reg] [data]
+	case dest.Type == OperandRegister && src.Type == OperandImmediate:
+		isWord := isWordRegister(dest.Register)
+		reg, ok := generalRegisterIndex(dest.Register)
+		if !ok {
+			return nil, fmt.Errorf("expected a general register as the destination of the 'mov' instruction, got '%s'", dest.Register)
+		}
+		return append([]byte{0b10110000 | boolBit(isWord)<<3 | reg}, encodeImmediate(src.Immediate.Value, isWord)...), nil
+
+	// [1100011|w] [mod|000|r/m] [data]
+	case src.Type == OperandImmediate:
+		isWord, err := operandIsWord(dest, src)
+		if err != nil {
+			return nil, err
+		}
+		modrm, err := encodeRegOrMem(0b000, dest)
+		if err != nil {
+			return nil, err
+		}
+		encoded := append([]byte{0b11000110 | boolBit(isWord)}, modrm...)
+		return append(encoded, encodeImmediate(src.Immediate.Value, isWord)...), nil
+
+	// [10001110] [mod|0|sr|r/m], [10001100] [mod|0|sr|r/m]
+	case dest.Type == OperandRegister && isSegmentRegister(dest.Register):
+		segment, _ := registerIndex(SegmentRegisterFieldEncoding, dest.Register)
+		modrm, err := encodeRegOrMem(segment, src)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{0b10001110}, modrm...), nil
+	case src.Type == OperandRegister && isSegmentRegister(src.Register):
+		segment, _ := registerIndex(SegmentRegisterFieldEncoding, src.Register)
+		modrm, err := encodeRegOrMem(segment, dest)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{0b10001100}, modrm...), nil
+	}
+
+	encoded, err := encodeRegWithRegOrMem(dest, src)
+	if err != nil {
+		return nil, err
+	}
+	encoded[0] |= 0b10001000
+	return encoded, nil
+}
+
+// encodeRegWithRegOrMem returns [000000|d|w] [mod|reg|r/m] [disp-lo?] [disp-hi?] without the opcode bits.
+// Between two registers d = 0, the destination is in the r/m field
+func encodeRegWithRegOrMem(dest Operand, src Operand) ([]byte, error) {
+	reg, regOrMem, direction := src, dest, byte(RegIsSource)
+	if src.Type != OperandRegister {
+		reg, regOrMem, direction = dest, src, RegIsDestination
+	}
+
+	if reg.Type != OperandRegister {
+		return nil, fmt.Errorf("expected one of the operands to be a register")
+	}
+
+	index, ok := generalRegisterIndex(reg.Register)
+	if !ok {
+		return nil, fmt.Errorf("expected a general register, got '%s'", reg.Register)
+	}
+
+	modrm, err := encodeRegOrMem(index, regOrMem)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{direction<<1 | boolBit(isWordRegister(reg.Register))}, modrm...), nil
+}
+
+// encodeRegOrMem returns [mod|reg|r/m] [disp-lo?] [disp-hi?]
+func encodeRegOrMem(reg byte, operand Operand) ([]byte, error) {
+	switch operand.Type {
+	case OperandRegister:
+		rm, ok := generalRegisterIndex(operand.Register)
+		if !ok {
+			return nil, fmt.Errorf("expected a general register, got '%s'", operand.Register)
+		}
+		return []byte{RegisterModeFieldEncoding<<6 | reg<<3 | rm}, nil
+	case OperandMemory:
+		address := operand.Memory
+		encoded := []byte{address.Mod<<6 | reg<<3 | address.Rm}
+		switch {
+		case address.Mod == MemoryModeNoDisplacementFieldEncoding && address.Rm == 0b110,
+			address.Mod == MemoryMode16DisplacementFieldEncoding:
+			encoded = append(encoded, byte(address.Displacement), byte(address.Displacement>>8))
+		case address.Mod == MemoryMode8DisplacementFieldEncoding:
+			encoded = append(encoded, byte(address.Displacement))
+		}
+		return encoded, nil
+	default:
+		return nil, fmt.Errorf("expected a register or a memory operand, got the operand type %d", operand.Type)
+	}
+}
+
+// [data-lo] [data-hi?]
+func encodeImmediate(value uint16, isWord bool) []byte {
+	if isWord {
+		return []byte{byte(value), byte(value >> 8)}
+	}
+	return []byte{byte(value)}
+}
+
+// operandIsWord is the width of the operation with an immediate: the register or the size specifier decides it
+func operandIsWord(dest Operand, src Operand) (bool, error) {
+	switch {
+	case dest.Type == OperandRegister:
+		return isWordRegister(dest.Register), nil
+	case dest.Specifier != "":
+		return dest.Specifier == "word", nil
+	case src.Specifier != "":
+		return src.Specifier == "word", nil
+	}
+
+	return false, fmt.Errorf("expected the size of the memory operand to be specified")
+}
+
+func generalRegisterIndex(name string) (byte, bool) {
+	if index, ok := registerIndex(WordOperationRegisterFieldEncoding, name); ok {
+		return index, true
+	}
+	return registerIndex(ByteOperationRegisterFieldEncoding, name)
+}
+
+func registerIndex(encoding map[byte]string, name string) (byte, bool) {
+	for index, register := range encoding {
+		if register == name {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+func isWordRegister(name string) bool {
+	_, ok := registerIndex(WordOperationRegisterFieldEncoding, name)
+	return ok
+}
+
+func isSegmentRegister(name string) bool {
+	_, ok := registerIndex(SegmentRegisterFieldEncoding, name)
+	return ok
+}
+
+func isAccumulator(operand Operand) bool {
+	return operand.Type == OperandRegister && (operand.Register == "ax" || operand.Register == "al")
+}
+
+func isDirectAddressOperand(operand Operand) bool {
+	return operand.Type == OperandMemory &&
+		operand.Memory.Mod == MemoryModeNoDisplacementFieldEncoding &&
+		operand.Memory.Rm == 0b110
+}
+
+func boolBit(value bool) byte {
+	if value {
+		return 1
+	}
+	return 0
+}
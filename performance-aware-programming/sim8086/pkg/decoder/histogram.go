@@ -0,0 +1,11 @@
+package decoder
+
+// Histogram counts how often each mnemonic appears in the instructions
+func Histogram(instructions []Instruction) map[string]int {
+	histogram := make(map[string]int)
+	for _, instruction := range instructions {
+		histogram[instruction.Mnemonic] += 1
+	}
+
+	return histogram
+}
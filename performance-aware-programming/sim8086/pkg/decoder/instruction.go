@@ -0,0 +1,467 @@
+package decoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type OperandType byte
+
+const (
+	OperandNone       OperandType = iota
+	OperandRegister               // ax, cl, ds
+	OperandMemory                 // [bx + si + 4]
+	OperandImmediate              // 42
+	OperandLabel                  // label__12 - short jumps
+	OperandOffset                 // 11804 - near jumps and calls, an offset within the code segment
+	OperandFarPointer             // 123:456 - segment:offset of the intersegment jumps and calls
+)
+
+// EffectiveAddress of a memory operand.
+// Mod and Rm are kept as they are encoded, so the address can be rendered exactly the way it was written.
+type EffectiveAddress struct {
+	Mod          byte   // definitions.MOD; defines the width of the displacement
+	Rm           byte   // definitions.R_M; the EffectiveAddressEquation. Mod = 00 and Rm = 110 is the direct address
+	Displacement uint16 // the direct address or the displacement. With Mod = 01 only the low byte is meaningful
+	Segment      string // segment override, empty if none
+}
+
+// DispWidth is the size of the displacement field in bytes: 0, 1 or 2. [bx], [bx + 0] and [bx + word 0] differ by it.
+// The direct address is the 2-byte field as well
+func (a EffectiveAddress) DispWidth() int {
+	switch {
+	case a.Mod == MemoryMode8DisplacementFieldEncoding:
+		return 1
+	case a.Mod == MemoryMode16DisplacementFieldEncoding, a.Mod == MemoryModeNoDisplacementFieldEncoding && a.Rm == 0b110:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Disp is the displacement as a signed number of the DispWidth, the 8-bit one is sign-extended
+func (a EffectiveAddress) Disp() int16 {
+	switch a.DispWidth() {
+	case 1:
+		return int16(int8(uint8(a.Displacement)))
+	case 2:
+		return int16(a.Displacement)
+	default:
+		return 0
+	}
+}
+
+// ImmediateEncoding is how the immediate is stored in the instruction bytes
+type ImmediateEncoding byte
+
+const (
+	ImmediateImplied          ImmediateEncoding = iota // not stored in the instruction, e.g. the shift count 1
+	ImmediateByte                                      // 8-bit data
+	ImmediateWord                                      // 16-bit data
+	ImmediateSignExtendedByte                          // 8-bit data sign-extended to 16 bits. [s|w = 1|1]
+)
+
+// Immediate is a constant operand
+type Immediate struct {
+	Value    uint16 // byte values are sign-extended if the instruction does so
+	Hex      bool   // the value is displayed as a hex number
+	Encoding ImmediateEncoding
+}
+
+// Width is the size of the operand in bytes: 2 for the word and the sign-extended byte, 1 for the byte and implied ones
+func (i Immediate) Width() int {
+	if i.Encoding == ImmediateWord || i.Encoding == ImmediateSignExtendedByte {
+		return 2
+	}
+	return 1
+}
+
+// Unsigned is the value as an unsigned number of the Width
+func (i Immediate) Unsigned() uint16 {
+	if i.Width() == 1 {
+		return i.Value & 0x00ff
+	}
+	return i.Value
+}
+
+// Signed is the value as a two's complement number of the Width: the byte 255 is -1, the word 255 is 255
+func (i Immediate) Signed() int16 {
+	if i.Width() == 1 {
+		return int16(int8(uint8(i.Value)))
+	}
+	return int16(i.Value)
+}
+
+// FarPointer is the segment:offset target of the direct intersegment call and jmp
+type FarPointer struct {
+	Segment uint16
+	Offset  uint16
+}
+
+type Operand struct {
+	Type      OperandType
+	Register  string
+	Memory    EffectiveAddress
+	Immediate Immediate
+	Target    int // OperandLabel & OperandOffset; the position the control is transferred to
+	Pointer   FarPointer
+
+	// Specifier is the NASM keyword written before the operand: byte, word, short, near or far.
+	// The size is only specified when it can't be inferred from the other operands.
+	Specifier string
+}
+
+// Instruction is a decoded instruction together with its location in the binary
+type Instruction struct {
+	Offset   int    // position of the first byte, including the prefixes
+	Size     int    // number of bytes, including the prefixes
+	Prefix   string // lock, rep, repz, repnz
+	Mnemonic string
+	Operands []Operand
+	Comment  string // an alternative representation of the instruction, e.g. the negative value of the immediate
+	Category string // the row of the encoding table the instruction was decoded with, e.g. "MOV: Immediate to register"
+}
+
+func registerOperand(name string) Operand {
+	return Operand{Type: OperandRegister, Register: name}
+}
+
+// directAddressOperand is the memory operand with the 16-bit direct address. [mod = 00|r/m = 110]
+func (d *Decoder) directAddressOperand(address uint16) Operand {
+	return d.calculateEffectiveAddress(0b110, address, MemoryModeNoDisplacementFieldEncoding)
+}
+
+func immediateOperand(value uint16, encoding ImmediateEncoding) Operand {
+	return Operand{Type: OperandImmediate, Immediate: Immediate{Value: value, Encoding: encoding}}
+}
+
+// immediateEncoding is the encoding of the data field that is as wide as the operation
+func immediateEncoding(isWord bool) ImmediateEncoding {
+	if isWord {
+		return ImmediateWord
+	}
+	return ImmediateByte
+}
+
+// dataInstruction is the raw byte that isn't decoded as an instruction - db 0xNN
+func dataInstruction(offset int, value byte) Instruction {
+	return Instruction{
+		Offset:   offset,
+		Size:     1,
+		Mnemonic: "db",
+		Operands: []Operand{{Type: OperandImmediate, Immediate: Immediate{Value: uint16(value), Hex: true, Encoding: ImmediateByte}}},
+	}
+}
+
+// signedComment shows the signed interpretation of a value when it differs from the unsigned one.
+// Empty if the decoder doesn't show the signed comments
+func (d *Decoder) signedComment(value uint16) string {
+	if !d.ShowSignedComment {
+		return ""
+	}
+
+	signed := int16(value)
+	if signed >= 0 {
+		return ""
+	}
+	// small negative numbers are likely the intent, the big ones are more likely the unsigned data
+	if d.SignedCommentThreshold > 0 && -int(signed) >= d.SignedCommentThreshold {
+		return ""
+	}
+
+	return fmt.Sprintf("or %d", signed)
+}
+
+func sizeSpecifier(isWord bool) string {
+	if isWord {
+		return "word"
+	} else {
+		return "byte"
+	}
+}
+
+// FormatOptions control the rendering of the instructions
+type FormatOptions struct {
+	Syntax Syntax
+
+	// CommentPrefix starts the comments. Empty = the one of the Syntax: ";" for NASM, "#" for AT&T and objdump
+	CommentPrefix string
+
+	// HideComments drops the comments
+	HideComments bool
+
+	// HexLabels names the labels after their hex offset: label_0x1a instead of label__26
+	HexLabels bool
+
+	// AnnotateCategory appends the encoding category to the comment: mov ax, bx ; MOV: Register/memory to/from register
+	AnnotateCategory bool
+
+	// Origin is added to the targets of the jumps and calls, see Decoder.Origin
+	Origin int
+
+	// Labels names the labels by their absolute address instead of label__26, see Decoder.OrdinalLabels
+	Labels map[int]string
+
+	// SignedImmediates writes the immediates of mov, the arithmetic instructions and ret that are negative
+	// as signed numbers: mov cx, -12 instead of mov cx, 65524. The NASM syntax only
+	SignedImmediates bool
+
+	// MinimalSizeKeywords writes the size keyword before the memory operand and only where NASM needs it:
+	// add byte [bx], 34 instead of add [bx], byte 34, call [bp] instead of call word [bp], jmp label instead of
+	// jmp short label. The NASM syntax only
+	MinimalSizeKeywords bool
+
+	// OmitBPDisplacement writes [bp + 0] as [bp]. The NASM syntax only
+	OmitBPDisplacement bool
+
+	// CourseMnemonics writes the mnemonics the course listings use: je, jne, jnl and jnb instead of jz, jnz, jge
+	// and jae, rep instead of repz. The NASM syntax only
+	CourseMnemonics bool
+}
+
+// labelName is the name of the label at the absolute address
+func (o FormatOptions) labelName(address int) string {
+	if name, ok := o.Labels[address]; ok {
+		return name
+	}
+	return createLabelName(address, o.HexLabels)
+}
+
+// Format renders the instruction without the line break
+func Format(instruction Instruction, options FormatOptions) string {
+	if options.HideComments {
+		instruction.Comment = ""
+	}
+
+	if options.AnnotateCategory && instruction.Category != "" {
+		if instruction.Comment != "" {
+			instruction.Comment += "; "
+		}
+		instruction.Comment += instruction.Category
+	}
+
+	switch options.Syntax {
+	case SyntaxATT:
+		return instruction.formatATT(options)
+	case SyntaxObjdump:
+		return instruction.formatObjdump(options)
+	}
+
+	return instruction.format(options)
+}
+
+// String renders the instruction in the NASM syntax without the line break
+func (i Instruction) String() string {
+	return i.format(FormatOptions{})
+}
+
+// IsNoOp reports whether the instruction leaves the registers and the memory as they are:
+// nop, mov ax, ax, xchg cx, cx, add dx, 0, and bx, bx etc. The flags may still be updated
+func (i Instruction) IsNoOp() bool {
+	if i.Mnemonic == "nop" {
+		return true
+	}
+	if len(i.Operands) != 2 || i.Operands[0].Type != OperandRegister {
+		return false
+	}
+
+	dest, src := i.Operands[0], i.Operands[1]
+	sameRegister := src.Type == OperandRegister && src.Register == dest.Register
+	zero := src.Type == OperandImmediate && src.Immediate.Value == 0
+	switch i.Mnemonic {
+	case "mov", "xchg", "and":
+		return sameRegister
+	case "or":
+		return sameRegister || zero
+	case "add", "sub", "xor":
+		return zero
+	}
+
+	return false
+}
+
+// format renders the instruction in the NASM syntax without the line break.
+// The comment prefix is ";" if empty
+func (i Instruction) format(options FormatOptions) string {
+	var builder strings.Builder
+
+	if options.CourseMnemonics {
+		i = i.courseMnemonics()
+	}
+	if options.MinimalSizeKeywords {
+		i.Operands = i.minimalSizeKeywords()
+	}
+	if !signedImmediateMnemonics[i.Mnemonic] {
+		options.SignedImmediates = false
+	}
+
+	if i.Prefix != "" {
+		builder.WriteString(i.Prefix + " ")
+	}
+
+	builder.WriteString(i.Mnemonic)
+
+	for idx, operand := range i.Operands {
+		if idx == 0 {
+			builder.WriteString(" ")
+		} else {
+			builder.WriteString(", ")
+		}
+
+		builder.WriteString(operand.format(options))
+	}
+
+	if i.Comment != "" {
+		commentPrefix := options.CommentPrefix
+		if commentPrefix == "" {
+			commentPrefix = ";"
+		}
+		builder.WriteString(" " + commentPrefix + " " + i.Comment)
+	}
+
+	return builder.String()
+}
+
+// signedImmediateMnemonics are the instructions whose immediates are numbers rather than bits or ports,
+// see FormatOptions.SignedImmediates
+var signedImmediateMnemonics = map[string]bool{
+	"mov": true, "add": true, "adc": true, "sub": true, "sbb": true, "cmp": true, "imul": true, "ret": true, "retf": true,
+}
+
+// courseMnemonics are the names the course listings give to the instructions the decoder calls differently
+var courseMnemonics = map[string]string{
+	"jz":   "je",
+	"jnz":  "jne",
+	"jge":  "jnl",
+	"jae":  "jnb",
+	"repz": "rep",
+}
+
+// courseMnemonics renames the instruction and its prefix, see FormatOptions.CourseMnemonics.
+// The alias comment that becomes the name is dropped: je ; je
+func (i Instruction) courseMnemonics() Instruction {
+	if name, ok := courseMnemonics[i.Mnemonic]; ok {
+		i.Mnemonic = name
+		if i.Comment == name {
+			i.Comment = ""
+		}
+	}
+	if name, ok := courseMnemonics[i.Prefix]; ok {
+		i.Prefix = name
+	}
+
+	return i
+}
+
+// minimalSizeKeywords moves the size keyword of the immediate to the memory operand and drops the ones NASM infers,
+// see FormatOptions.MinimalSizeKeywords. The operands of the instruction are left as they are
+func (i Instruction) minimalSizeKeywords() []Operand {
+	operands := append([]Operand(nil), i.Operands...)
+	for idx := range operands {
+		// the indirect call and jmp are near without the far keyword, NASM picks the short jmp when it can
+		isNear := (i.Mnemonic == "call" || i.Mnemonic == "jmp") && operands[idx].Specifier == "word"
+		if isNear || operands[idx].Specifier == "short" {
+			operands[idx].Specifier = ""
+		}
+	}
+
+	if len(operands) == 2 && operands[0].Type == OperandMemory && operands[0].Specifier == "" && operands[1].Type == OperandImmediate {
+		operands[0].Specifier, operands[1].Specifier = operands[1].Specifier, ""
+	}
+
+	return operands
+}
+
+func (o Operand) format(options FormatOptions) string {
+	value := ""
+
+	switch o.Type {
+	case OperandRegister:
+		value = o.Register
+	case OperandMemory:
+		value = o.Memory.format(options)
+	case OperandImmediate:
+		switch {
+		case o.Immediate.Hex:
+			value = fmt.Sprintf("0x%02x", o.Immediate.Value)
+		case options.SignedImmediates:
+			value = strconv.Itoa(int(o.Immediate.Signed()))
+		default:
+			value = strconv.Itoa(int(o.Immediate.Value))
+		}
+	case OperandLabel:
+		value = options.labelName(o.Target + options.Origin)
+	case OperandOffset:
+		value = strconv.Itoa(o.Target + options.Origin)
+	case OperandFarPointer:
+		value = fmt.Sprintf("%d:%d", o.Pointer.Segment, o.Pointer.Offset)
+	default:
+		panic(fmt.Errorf("AssertionError: unknown operand type %d", o.Type))
+	}
+
+	if o.Specifier != "" {
+		return o.Specifier + " " + value
+	}
+
+	return value
+}
+
+// absSigned8 is the magnitude of the two's complement value: the sign is removed by inverting the bits and adding 1,
+// 1111 1011 -> 0000 0101. The result is unsigned, so -128 doesn't overflow
+func absSigned8(value int8) uint8 {
+	if value < 0 {
+		return ^uint8(value) + 1
+	}
+	return uint8(value)
+}
+
+// absSigned16 is absSigned8 for the words, -32768 -> 32768
+func absSigned16(value int16) uint16 {
+	if value < 0 {
+		return ^uint16(value) + 1
+	}
+	return uint16(value)
+}
+
+func (a EffectiveAddress) format(options FormatOptions) string {
+	address := ""
+	if a.Mod == MemoryModeNoDisplacementFieldEncoding {
+		equation := ""
+		// the exception for the direct address - 16-bit displacement for the direct address
+		if a.Rm == 0b110 {
+			equation = strconv.Itoa(int(a.Displacement))
+		} else {
+			equation = EffectiveAddressEquation[a.Rm]
+		}
+
+		address = fmt.Sprintf("[%s]", equation)
+	} else if a.Mod == MemoryMode8DisplacementFieldEncoding {
+		equation := EffectiveAddressEquation[a.Rm]
+		signed := int8(uint8(a.Displacement))
+		if signed < 0 {
+			address = fmt.Sprintf("[%s - %d]", equation, absSigned8(signed))
+		} else if signed == 0 && a.Rm == 0b110 && options.OmitBPDisplacement {
+			// the 8086 has no [bp] without the displacement, so NASM encodes it with the zero 8-bit one
+			address = "[bp]"
+		} else {
+			address = fmt.Sprintf("[%s + %d]", equation, signed)
+		}
+	} else if a.Mod == MemoryMode16DisplacementFieldEncoding {
+		equation := EffectiveAddressEquation[a.Rm]
+		signed := int16(a.Displacement)
+		if signed < 0 {
+			address = fmt.Sprintf("[%s - %d]", equation, absSigned16(signed))
+		} else {
+			address = fmt.Sprintf("[%s + %d]", equation, a.Displacement)
+		}
+	} else {
+		panic(fmt.Errorf("AssertionError: Unknown mod for effective address calculation. %.3b", a.Mod))
+	}
+
+	if a.Segment != "" {
+		return fmt.Sprintf("%s:%s", a.Segment, address)
+	} else {
+		return address
+	}
+}
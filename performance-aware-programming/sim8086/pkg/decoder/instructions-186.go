@@ -0,0 +1,130 @@
+package decoder
+
+import "fmt"
+
+// require186 rejects the 80186 instruction unless Decoder.Allow186 is set
+func (d *Decoder) require186(instructionName string) error {
+	if !d.Allow186 {
+		return fmt.Errorf("'%s' is an 80186 instruction, set Allow186 to decode it", instructionName)
+	}
+
+	return nil
+}
+
+// [11001000] [data-lo] [data-hi] [level]
+// ENTER creates the stack frame of the procedure: the size of the locals and the nesting level
+func enter(operation byte, d *Decoder) (Instruction, error) {
+	if err := d.require186("ENTER: Make stack frame"); err != nil {
+		return Instruction{}, err
+	}
+
+	size, err := d.decodeImmediate("ENTER: Make stack frame", true)
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	level, err := d.decodeImmediate("ENTER: Make stack frame", false)
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	return Instruction{
+		Mnemonic: "enter",
+		Operands: []Operand{immediateOperand(size, ImmediateWord), immediateOperand(level, ImmediateByte)},
+	}, nil
+}
+
+// [11001001]
+// LEAVE releases the stack frame created by ENTER
+func leave(operation byte, d *Decoder) (Instruction, error) {
+	if err := d.require186("LEAVE: High level procedure exit"); err != nil {
+		return Instruction{}, err
+	}
+
+	return Instruction{Mnemonic: "leave"}, nil
+}
+
+// [01100000]
+// PUSHA pushes ax, cx, dx, bx, the original sp, bp, si and di
+func pusha(operation byte, d *Decoder) (Instruction, error) {
+	if err := d.require186("PUSHA: Push all"); err != nil {
+		return Instruction{}, err
+	}
+
+	return Instruction{Mnemonic: "pusha"}, nil
+}
+
+// [01100001]
+// POPA pops the registers PUSHA pushed, the saved sp is discarded
+func popa(operation byte, d *Decoder) (Instruction, error) {
+	if err := d.require186("POPA: Pop all"); err != nil {
+		return Instruction{}, err
+	}
+
+	return Instruction{Mnemonic: "popa"}, nil
+}
+
+// [01100010] [mod|reg|r/m] [disp-lo?] [disp-hi?]
+// BOUND checks the register against the lower and the upper bound, the two words in the memory
+func bound(operation byte, d *Decoder) (Instruction, error) {
+	const instructionName = "BOUND: Check array index against bounds"
+	if err := d.require186(instructionName); err != nil {
+		return Instruction{}, err
+	}
+
+	operand, ok := d.next()
+	if ok == false {
+		return Instruction{}, fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
+	}
+
+	mod, reg, rm := decodeOperand(operand)
+	if mod == RegisterModeFieldEncoding {
+		return Instruction{}, fmt.Errorf("expected the bounds to be in the memory for the '%s' instruction", instructionName)
+	}
+
+	dest, src, err := d.decodeBinaryRegOrMem(instructionName, mod, WordOperationRegisterFieldEncoding[reg], rm, true, RegIsDestination)
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	return Instruction{Mnemonic: "bound", Operands: []Operand{dest, src}}, nil
+}
+
+// [011010|s|1] [mod|reg|r/m] [disp-lo?] [disp-hi?] [data] [data if s = 0]
+// IMUL reg, r/m, immediate. The byte immediate (s = 1) is sign-extended
+func imulImmediate(operation byte, d *Decoder) (Instruction, error) {
+	const instructionName = "IMUL: Signed multiply by immediate"
+	if err := d.require186(instructionName); err != nil {
+		return Instruction{}, err
+	}
+
+	isSigned := (operation>>1)&0b00000001 == SignExtension
+
+	operand, ok := d.next()
+	if ok == false {
+		return Instruction{}, fmt.Errorf("expected to get an operand for the '%s' instruction", instructionName)
+	}
+
+	mod, reg, rm := decodeOperand(operand)
+	dest, src, err := d.decodeBinaryRegOrMem(instructionName, mod, WordOperationRegisterFieldEncoding[reg], rm, true, RegIsDestination)
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	immediateValue, err := d.decodeImmediate(instructionName, !isSigned)
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	encoding := ImmediateWord
+	if isSigned {
+		immediateValue = uint16(int16(int8(uint8(immediateValue))))
+		encoding = ImmediateSignExtendedByte
+	}
+
+	return Instruction{
+		Mnemonic: "imul",
+		Operands: []Operand{dest, src, immediateOperand(immediateValue, encoding)},
+		Comment:  d.signedComment(immediateValue),
+	}, nil
+}
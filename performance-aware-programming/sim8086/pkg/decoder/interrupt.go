@@ -3,26 +3,26 @@ package decoder
 import "fmt"
 
 // [11001101] [data]
-func interruptWithType(operation byte, d *Decoder) (string, error) {
+func interruptWithType(operation byte, d *Decoder) (Instruction, error) {
 	data, ok := d.next()
 	if ok == false {
-		return "", fmt.Errorf("expected to get a type for the 'INT: type specified' instruction")
+		return Instruction{}, fmt.Errorf("expected to get a type for the 'INT: type specified' instruction")
 	}
 
-	return fmt.Sprintf("int %d\n", data), nil
+	return Instruction{Mnemonic: "int", Operands: []Operand{immediateOperand(uint16(data), ImmediateByte)}}, nil
 }
 
 // [11001100]
-func interruptType3(operation byte, d *Decoder) (string, error) {
-	return "int3\n", nil
+func interruptType3(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "int3"}, nil
 }
 
 // [11001110]
-func interruptOnOverflow(operation byte, d *Decoder) (string, error) {
-	return "into\n", nil
+func interruptOnOverflow(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "into"}, nil
 }
 
 // [11001111]
-func interruptReturn(operation byte, d *Decoder) (string, error) {
-	return "iret\n", nil
+func interruptReturn(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "iret"}, nil
 }
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package decoder
+
+import "os"
+
+// NewDecoderFromMmap falls back to reading the whole file on the platforms without mmap support.
+func NewDecoderFromMmap(path string) (*Decoder, func() error, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return NewDecoder(bytes), func() error { return nil }, nil
+}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package decoder
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// NewDecoderFromMmap maps the file read-only and decodes directly from the mapped region,
+// so large binaries don't have to be loaded into the heap.
+// The returned closer unmaps the file; the decoder must not be used after calling it.
+func NewDecoderFromMmap(path string) (*Decoder, func() error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the mapping stays valid after the file is closed
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := info.Size()
+	if size == 0 {
+		// mmap doesn't accept an empty mapping
+		return NewDecoder([]byte{}), func() error { return nil }, nil
+	}
+	if size != int64(int(size)) {
+		return nil, nil, fmt.Errorf("the file %s is too large to be mapped (%d bytes)", path, size)
+	}
+
+	// the slice length is exactly the file size, so d.next() and d.peekForward() can't read past the mapping
+	// even though the mapping itself is rounded up to the page size
+	mapped, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to mmap the file %s. Error = %w", path, err)
+	}
+
+	unmap := func() error {
+		return syscall.Munmap(mapped)
+	}
+
+	return NewDecoder(mapped), unmap, nil
+}
@@ -0,0 +1,273 @@
+package decoder
+
+import (
+	"sort"
+	"sync"
+)
+
+type handler func(operation byte, d *Decoder) (Instruction, error)
+
+// opcode is a row of the instruction table.
+// pattern - 0b10011dwx, where any char except 0 or 1 is a wildcard; see matchPattern
+type opcode struct {
+	name    string
+	pattern string
+	handler handler
+}
+
+// opcodes are matched in order, the first matching row decodes the instruction.
+// Table 4-12. 8086 Instruction Encoding
+var opcodes = []opcode{
+	// MOV = Move
+	{"MOV: Register/memory to/from register", "0b100010dw", moveRegMemToReg},
+	{"MOV: Immediate to register/memory", "0b1100011w", moveImmediateToRegOrMem},
+	{"MOV: Immediate to register", "0b1011wreg", moveImmediateToReg},
+	{"MOV: Memory to accumulator", "0b1010000w", moveMemoryToAccumulator},
+	{"MOV: Accumulator to memory", "0b1010001w", moveAccumulatorToMemory},
+	{"MOV: Register/memory to segment register", "0b10001110", moveRegOrMemToSegment},
+	{"MOV: Segment register to register/memory", "0b10001100", moveSegmentToRegOrMem},
+
+	// PUSH
+	{"PUSH: Register/memory", "0b11111111|0b__110___", pushRegOrMem},
+	{"PUSH: Register", "0b01010reg", pushReg},
+	{"PUSH: segment register", "0b000__110", pushSegmentReg},
+
+	// POP
+	{"POP: Register/memory", "0b10001111|0b__000___", popRegOrMem},
+	{"POP: Register", "0b01011reg", popReg},
+	{"POP: segment register", "0b000__111", popSegmentReg},
+
+	// XCHG = Exchange
+	{"XCHG: Register/memory with register", "0b1000011w", exchangeRegOrMemWithReg},
+	{"XCHG: register with accumulator", "0b10010reg", exchangeRegWithAccumulator},
+
+	// IN = Input from
+	{"IN: Fixed port", "0b1110010w", inputFromFixedPort},
+	{"IN: Variable port", "0b1110110w", inputFromVariablePort},
+
+	// OUT = Output to
+	{"OUT: Fixed port", "0b1110011w", outputToFixedPort},
+	{"OUT: Variable port", "0b1110111w", outputToVariablePort},
+
+	{"XLAT - Translate byte to AL", "0b11010111", xlat},
+
+	// Address Object Transfers
+	{"LEA - Load effective address to register", "0b10001101", lea},
+	{"LDS - Load pointer to DS", "0b11000101", lds},
+	{"LES - Load pointer to ES", "0b11000100", les},
+
+	// Flag Transfers
+	{"LAHF - Load AH with flags", "0b10011111", lahf},
+	{"SAHF - Store AH into flags", "0b10011110", sahf},
+	{"PUSHF - Push flags", "0b10011100", pushf},
+	{"POPF - Pop flags", "0b10011101", popf},
+
+	// ADD
+	{"ADD: Reg/memory with register to either", "0b000000dw", addRegOrMemToReg},
+	{"ADD: Immediate to register/memory", "0b100000sw|0b__000___", addImmediateToRegOrMem},
+	{"ADD: Immediate to accumulator", "0b0000010w", addImmediateToAccumulator},
+
+	// ADC = Add with carry
+	{"ADC: Reg/memory with register to either", "0b000100dw", adcRegOrMemToReg},
+	{"ADC: Immediate to register/memory", "0b100000sw|0b__010___", adcImmediateToRegOrMem},
+	{"ADC: Immediate to accumulator", "0b0001010w", adcImmediateToAccumulator},
+
+	// INC = Increment
+	{"INC: Register/memory", "0b1111111w|0b__000___", incRegOrMem},
+	{"INC: Register", "0b01000reg", incReg},
+
+	{"AAA: ASCII adjust for add", "0b00110111", aaa},
+	{"DAA: Decimal adjust for add", "0b00100111", daa},
+
+	// SUB = Subtract
+	{"SUB: Reg/memory and register to either", "0b001010dw", subRegOrMemFromReg},
+	{"SUB: Immediate to register/memory", "0b100000sw|0b__101___", subImmediateFromRegOrMem},
+	{"SUB: Immediate from accumulator", "0b0010110w", subImmediateFromAccumulator},
+
+	// SBB = Subtract with borrow
+	{"SBB: Reg/memory and register to either", "0b000110dw", sbbRegOrMemFromReg},
+	{"SBB: Immediate to register/memory", "0b100000sw|0b__011___", sbbImmediateFromRegOrMem},
+	{"SBB: Immediate from accumulator", "0b0001110w", sbbImmediateFromAccumulator},
+
+	// DEC = Decrement
+	{"DEC: Register/memory", "0b1111111w|0b__001___", decRegOrMem},
+	{"DEC: Register", "0b01001reg", decReg},
+
+	{"NEG: Change sign", "0b1111011w|0b__011___", neg},
+
+	// CMP = Compare
+	{"CMP: Reg/memory and register", "0b001110dw", cmpRegOrMemWithReg},
+	{"CMP: Immediate with register/memory", "0b100000sw|0b__111___", cmpImmediateWithRegOrMem},
+	{"CMP: Immediate from accumulator", "0b0011110w", cmpImmediateWithAccumulator},
+
+	{"AAS: ASCII adjust for subtract", "0b00111111", aas},
+	{"DAS: decimal adjust for subtract", "0b00101111", das},
+
+	{"MUL: Unsigned multiply", "0b1111011w|0b__100___", mul},
+	{"IMUL: Signed multiply", "0b1111011w|0b__101___", imul},
+	{"AAM: ASCII adjust for multiply", "0b11010100|0b00001010", aam},
+
+	{"DIV: Unsigned divide", "0b1111011w|0b__110___", div},
+	{"IDIV: Signed divide", "0b1111011w|0b__111___", idiv},
+	{"AAD: ASCII adjust for divide", "0b11010101|0b00001010", aad},
+	{"CBW: convert byte to word", "0b10011000", cbw},
+	{"CWD: convert word to double word", "0b10011001", cwd},
+
+	// LOGIC
+	{"NOT: Invert", "0b1111011w|0b__010___", not},
+	{"SHL/SAL: Shift logical/arithmetic left", "0b110100vw|0b__100___", shl},
+	{"SHR: Shift logical right", "0b110100vw|0b__101___", shr},
+	{"SAR: Shift arithmetic right", "0b110100vw|0b__111___", sar},
+	{"ROL: Rotate left", "0b110100vw|0b__000___", rol},
+	{"ROR: Rotate right", "0b110100vw|0b__001___", ror},
+	{"RCL: Rotate through carry left", "0b110100vw|0b__010___", rcl},
+	{"RCR: Rotate through carry right", "0b110100vw|0b__011___", rcr},
+
+	// AND
+	{"AND: Logical AND reg/mem with reg", "0b001000dw", andRegOrMemWithReg},
+	{"AND: Logical AND immediate with reg/mem", "0b1000000w|0b__100___", andImmediateWithRegOrMem},
+	{"AND: Logical AND immediate with accumulator", "0b0010010w", andImmediateWithAccumulator},
+
+	// TEST
+	// NOTE(Kostia): for some reason, the "Instruction reference" says that test is [000100|d|w], but when using nasm v2.16.03, the opcode is different. Moreover, the table 4-13 aligns with the nasm, but 4-12 doesn't
+	// There is no d bit: 100001|1|w is XCHG, and 000100|d|w is ADC. See TestTestEncoding
+	{"TEST: Logical compare reg/mem with reg", "0b1000010w", testRegOrMemWithReg},
+	{"TEST: Logical compare immediate with reg/mem", "0b1111011w|0b__000___", testImmediateWithRegOrMem},
+	{"TEST: Logical compare immediate with accumulator", "0b1010100w", testImmediateWithAccumulator},
+
+	// OR
+	{"OR: Logical OR reg/mem with reg", "0b000010dw", orRegOrMemWithReg},
+	{"OR: Logical OR immediate with reg/mem", "0b1000000w|0b__001___", orImmediateWithRegOrMem},
+	{"OR: Logical OR immediate with accumulator", "0b0000110w", orImmediateWithAccumulator},
+
+	// XOR
+	{"XOR: Logical XOR reg/mem with reg", "0b001100dw", xorRegOrMemWithReg},
+	// NOTE(Kostia): for some reason, the "Instruction reference" says that xor is [0011010|w] [data] [disp-lo?] [disp-hi?] [data] [data if w=1], but when using nasm v2.16.03, the opcode is different and the [data] seems to be wrong. Moreover, the table 4-13 aligns with the nasm, but 4-12 doesn't
+	{"XOR: Logical XOR immediate with reg/mem", "0b1000000w|0b__110___", xorImmediateWithRegOrMem},
+	{"XOR: Logical XOR immediate with accumulator", "0b0011010w", xorImmediateWithAccumulator},
+
+	// STRING
+	{"MOVS: move byte/word", "0b1010010w", movs},
+	{"CMPS: compare byte/word", "0b1010011w", cmps},
+	{"SCAS: scan byte/word", "0b1010111w", scas},
+	{"LODS: load byte/word", "0b1010110w", lods},
+	{"STOS: store byte/word", "0b1010101w", stos},
+
+	// CALL
+	{"CALL: Direct within segment", "0b11101000", callDirectWithinSegment},
+	{"CALL: Indirect within segment", "0b11111111|0b__010___", callIndirectWithinSegment},
+	{"CALL: Direct intersegment", "0b10011010", callDirectIntersegment},
+	{"CALL: Indirect intersegment", "0b11111111|0b__011___", callIndirectIntersegment},
+
+	// JMP = Unconditional jump
+	{"JMP: Direct within segment", "0b11101001", jumpDirectWithinSegment},
+	{"JMP: Direct within segment-short", "0b11101011", jumpDirectWithinSegmentShort},
+	{"JMP: Indirect within segment", "0b11111111|0b__100___", jumpIndirectWithinSegment},
+	{"JMP: Direct intersegment", "0b11101010", jumpDirectIntersegment},
+	{"JMP: Indirect intersegment", "0b11111111|0b__101___", jumpIndirectIntersegment},
+
+	// RET = Return from CALL
+	{"RET: Within segment", "0b11000011", returnWithinSegment},
+	{"RET: Within seg adding immed to SP", "0b11000010", returnWithinSegmentAddingImmedToSP},
+	{"RET: Intersegment", "0b11001011", returnIntersegment},
+	{"RET: Intersegment adding immed to SP", "0b11001010", returnIntersegmentAddingImmedToSP},
+
+	// Jumps
+	{"JE/JZ: Jump on equal/zero", "0b01110100", jumpConditionally},
+	{"JL/JNGE: Jump on less/not greater or equal", "0b01111100", jumpConditionally},
+	{"JLE/JNG: Jump on less or equal/not greater", "0b01111110", jumpConditionally},
+	{"JB/JNAE: Jump on below/not above or equal", "0b01110010", jumpConditionally},
+	{"JBE/JNA: Jump on below or equal/not above", "0b01110110", jumpConditionally},
+	{"JP/JPE: Jump on parity/even", "0b01111010", jumpConditionally},
+	{"JO: Jump on overflow", "0b01110000", jumpConditionally},
+	{"JS: Jump on sign", "0b01111000", jumpConditionally},
+	{"JNE/JNZ: Jump on not equal/not zero", "0b01110101", jumpConditionally},
+	{"JNL/JGE: Jump on not less/greater or equal", "0b01111101", jumpConditionally},
+	{"JNLE/JG: Jump on not less nor equal/greater", "0b01111111", jumpConditionally},
+	{"JNB/JAE: Jump on not below/above or equal", "0b01110011", jumpConditionally},
+	{"JNBE/JA: Jump on not below nor equal/above", "0b01110111", jumpConditionally},
+	{"JNP/JPO: Jump on not parity/odd", "0b01111011", jumpConditionally},
+	{"JNO: Jump on not overflow", "0b01110001", jumpConditionally},
+	{"JNS: Jump on not sign", "0b01111001", jumpConditionally},
+	{"JCXZ: Jump if CX register is zero", "0b11100011", jumpConditionally},
+
+	// Loops
+	{"LOOP: Loop CX times", "0b11100010", jumpConditionally},
+	{"LOOPZ/LOOPE: Loop while zero/equal", "0b11100001", jumpConditionally},
+	{"LOOPNZ/LOOPNE: Loop while not zero/not equal", "0b11100000", jumpConditionally},
+
+	// Interrupts
+	{"INT: Type specified", "0b11001101", interruptWithType},
+	{"INT: type 3", "0b11001100", interruptType3}, // Breakpoint
+	{"INTO: interrupt on overflow", "0b11001110", interruptOnOverflow},
+	{"IRET: Interrupt return", "0b11001111", interruptReturn},
+
+	// Processor control
+	{"CLC: Clear carry", "0b11111000", clc},
+	{"CMC: Complement carry", "0b11110101", cmc},
+	{"STC: Set carry", "0b11111001", stc},
+	{"CLD: Clear direction", "0b11111100", cld},
+	{"STD: set direction", "0b11111101", std},
+	{"CLI: Clear interrupt", "0b11111010", cli},
+	{"STI: Set interrupt", "0b11111011", sti},
+	{"HLT: Halt", "0b11110100", hlt},
+	{"WAIT: Wait", "0b10011011", wait},
+	{"ESC: Escape (to external device)", "0b11011xxx", escape},
+
+	// 80186, see Decoder.Allow186
+	{"ENTER: Make stack frame", "0b11001000", enter},
+	{"LEAVE: High level procedure exit", "0b11001001", leave},
+	{"PUSHA: Push all", "0b01100000", pusha},
+	{"POPA: Pop all", "0b01100001", popa},
+	{"BOUND: Check array index against bounds", "0b01100010", bound},
+	{"IMUL: Signed multiply by immediate", "0b011010s1", imulImmediate},
+}
+
+// SupportedInstructions returns the sorted mnemonics the decoder can produce, including the prefixes (lock, rep, ...).
+// The set is collected by decoding every combination of the first two bytes of an instruction,
+// and of the byte after WAIT for the coprocessor instructions it's merged with. The 80186 instructions are included
+func SupportedInstructions() []string {
+	supportedOnce.Do(func() {
+		set := make(map[string]bool)
+		collect := func(source []byte) {
+			d := NewDecoder(source)
+			d.EmitDataOnError = true
+			d.Allow186 = true
+			instruction, ok, err := d.Next()
+			if err != nil || !ok || instruction.Mnemonic == "db" {
+				return
+			}
+
+			set[instruction.Mnemonic] = true
+			if instruction.Prefix != "" {
+				set[instruction.Prefix] = true
+			}
+		}
+
+		for first := 0; first <= 0xff; first++ {
+			for second := 0; second <= 0xff; second++ {
+				collect([]byte{byte(first), byte(second), 0, 0, 0, 0})
+			}
+		}
+
+		// WAIT followed by the no-wait coprocessor instruction: fnstcw -> fstcw
+		for esc := 0b11011000; esc <= 0b11011111; esc++ {
+			for operand := 0; operand <= 0xff; operand++ {
+				collect([]byte{0b10011011, byte(esc), byte(operand), 0, 0, 0})
+			}
+		}
+
+		supported = make([]string, 0, len(set))
+		for mnemonic := range set {
+			supported = append(supported, mnemonic)
+		}
+		sort.Strings(supported)
+	})
+
+	return append([]string(nil), supported...)
+}
+
+var (
+	supportedOnce sync.Once
+	supported     []string
+)
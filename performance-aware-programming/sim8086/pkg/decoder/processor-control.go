@@ -1,52 +1,284 @@
 package decoder
 
+import "fmt"
+
 // [11111000]
-func clc(operation byte, d *Decoder) (string, error) {
-	return "clc\n", nil
+func clc(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "clc"}, nil
 }
 
 // [11110101]
-func cmc(operation byte, d *Decoder) (string, error) {
-	return "cmc\n", nil
+func cmc(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "cmc"}, nil
 }
 
 // [11111001]
-func stc(operation byte, d *Decoder) (string, error) {
-	return "stc\n", nil
+func stc(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "stc"}, nil
 }
 
 // [11111100]
-func cld(operation byte, d *Decoder) (string, error) {
-	return "cld\n", nil
+func cld(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "cld"}, nil
 }
 
 // [11111101]
-func std(operation byte, d *Decoder) (string, error) {
-	return "std\n", nil
+func std(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "std"}, nil
 }
 
 // [11111010]
-func cli(operation byte, d *Decoder) (string, error) {
-	return "cli\n", nil
+func cli(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "cli"}, nil
 }
 
 // [11111011]
-func sti(operation byte, d *Decoder) (string, error) {
-	return "sti\n", nil
+func sti(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "sti"}, nil
 }
 
 // [11110100]
-func hlt(operation byte, d *Decoder) (string, error) {
-	return "hlt\n", nil
-}
-
-// [10011011]
-func wait(operation byte, d *Decoder) (string, error) {
-	return "wait\n", nil
+func hlt(operation byte, d *Decoder) (Instruction, error) {
+	return Instruction{Mnemonic: "hlt"}, nil
 }
 
 // [001|reg|110]
 func segmentPrefix(operation byte, d *Decoder) string {
+	// the 386 overrides, see Decoder.Allow386Segments
+	switch operation {
+	case 0b01100100:
+		return "fs"
+	case 0b01100101:
+		return "gs"
+	}
+
 	reg := (operation >> 3) & 0b00000011
 	return SegmentRegisterFieldEncoding[reg]
 }
+
+// coprocessorInstruction is the 8087 instruction the ESC opcode is decoded into
+type coprocessorInstruction struct {
+	mnemonic  string
+	specifier string // the size of the memory operand; empty if it can't be specified
+}
+
+// CoprocessorMemoryInstructions for the ESC with a memory operand.
+// [11011|xxx] [mod|yyy|r/m]; key: xxxyyy
+var CoprocessorMemoryInstructions = map[byte]coprocessorInstruction{
+	0b000000: {"fadd", "dword"},
+	0b000001: {"fmul", "dword"},
+	0b000010: {"fcom", "dword"},
+	0b000011: {"fcomp", "dword"},
+	0b000100: {"fsub", "dword"},
+	0b000101: {"fsubr", "dword"},
+	0b000110: {"fdiv", "dword"},
+	0b000111: {"fdivr", "dword"},
+
+	0b001000: {"fld", "dword"},
+	0b001010: {"fst", "dword"},
+	0b001011: {"fstp", "dword"},
+	0b001100: {"fldenv", ""},
+	0b001101: {"fldcw", "word"},
+	0b001110: {"fnstenv", ""},
+	0b001111: {"fnstcw", "word"},
+
+	0b010000: {"fiadd", "dword"},
+	0b010001: {"fimul", "dword"},
+	0b010010: {"ficom", "dword"},
+	0b010011: {"ficomp", "dword"},
+	0b010100: {"fisub", "dword"},
+	0b010101: {"fisubr", "dword"},
+	0b010110: {"fidiv", "dword"},
+	0b010111: {"fidivr", "dword"},
+
+	0b011000: {"fild", "dword"},
+	0b011010: {"fist", "dword"},
+	0b011011: {"fistp", "dword"},
+	0b011101: {"fld", "tword"},
+	0b011111: {"fstp", "tword"},
+
+	0b100000: {"fadd", "qword"},
+	0b100001: {"fmul", "qword"},
+	0b100010: {"fcom", "qword"},
+	0b100011: {"fcomp", "qword"},
+	0b100100: {"fsub", "qword"},
+	0b100101: {"fsubr", "qword"},
+	0b100110: {"fdiv", "qword"},
+	0b100111: {"fdivr", "qword"},
+
+	0b101000: {"fld", "qword"},
+	0b101010: {"fst", "qword"},
+	0b101011: {"fstp", "qword"},
+	0b101100: {"frstor", ""},
+	0b101110: {"fnsave", ""},
+	0b101111: {"fnstsw", "word"},
+
+	0b110000: {"fiadd", "word"},
+	0b110001: {"fimul", "word"},
+	0b110010: {"ficom", "word"},
+	0b110011: {"ficomp", "word"},
+	0b110100: {"fisub", "word"},
+	0b110101: {"fisubr", "word"},
+	0b110110: {"fidiv", "word"},
+	0b110111: {"fidivr", "word"},
+
+	0b111000: {"fild", "word"},
+	0b111010: {"fist", "word"},
+	0b111011: {"fistp", "word"},
+	0b111100: {"fbld", "tword"},
+	0b111101: {"fild", "qword"},
+	0b111110: {"fbstp", "tword"},
+	0b111111: {"fistp", "qword"},
+}
+
+// CoprocessorStackInstructions for the ESC with a stack register operand, st(i) = r/m.
+// [11011|xxx] [11|yyy|r/m]; key: xxxyyy
+var CoprocessorStackInstructions = map[byte]string{
+	0b000000: "fadd",  // st0, st(i)
+	0b000001: "fmul",  // st0, st(i)
+	0b000010: "fcom",  // st(i)
+	0b000011: "fcomp", // st(i)
+	0b000100: "fsub",  // st0, st(i)
+	0b000101: "fsubr", // st0, st(i)
+	0b000110: "fdiv",  // st0, st(i)
+	0b000111: "fdivr", // st0, st(i)
+
+	0b001000: "fld",  // st(i)
+	0b001001: "fxch", // st(i)
+
+	0b100000: "fadd",  // st(i), st0
+	0b100001: "fmul",  // st(i), st0
+	0b100100: "fsubr", // st(i), st0
+	0b100101: "fsub",  // st(i), st0
+	0b100110: "fdivr", // st(i), st0
+	0b100111: "fdiv",  // st(i), st0
+
+	0b101000: "ffree", // st(i)
+	0b101010: "fst",   // st(i)
+	0b101011: "fstp",  // st(i)
+
+	0b110000: "faddp",  // st(i), st0
+	0b110001: "fmulp",  // st(i), st0
+	0b110100: "fsubrp", // st(i), st0
+	0b110101: "fsubp",  // st(i), st0
+	0b110110: "fdivrp", // st(i), st0
+	0b110111: "fdivp",  // st(i), st0
+}
+
+// CoprocessorInstructions without operands. [11011|xxx] [11|yyy|r/m]; key: both bytes
+var CoprocessorInstructions = map[uint16]string{
+	0xd9d0: "fnop",
+	0xd9e0: "fchs",
+	0xd9e1: "fabs",
+	0xd9e4: "ftst",
+	0xd9e5: "fxam",
+	0xd9e8: "fld1",
+	0xd9e9: "fldl2t",
+	0xd9ea: "fldl2e",
+	0xd9eb: "fldpi",
+	0xd9ec: "fldlg2",
+	0xd9ed: "fldln2",
+	0xd9ee: "fldz",
+	0xd9f0: "f2xm1",
+	0xd9f1: "fyl2x",
+	0xd9f2: "fptan",
+	0xd9f3: "fpatan",
+	0xd9f4: "fxtract",
+	0xd9f6: "fdecstp",
+	0xd9f7: "fincstp",
+	0xd9f8: "fprem",
+	0xd9f9: "fyl2xp1",
+	0xd9fa: "fsqrt",
+	0xd9fc: "frndint",
+	0xd9fd: "fscale",
+	0xdbe0: "fneni",
+	0xdbe1: "fndisi",
+	0xdbe2: "fnclex",
+	0xdbe3: "fninit",
+	0xded9: "fcompp",
+}
+
+// CoprocessorWaitInstructions are the instructions that are written as WAIT + the no-wait form
+// no-wait form: wait form
+var CoprocessorWaitInstructions = map[string]string{
+	"fnstenv": "fstenv",
+	"fnstcw":  "fstcw",
+	"fnsave":  "fsave",
+	"fnstsw":  "fstsw",
+	"fneni":   "feni",
+	"fndisi":  "fdisi",
+	"fnclex":  "fclex",
+	"fninit":  "finit",
+}
+
+// [10011011]
+// The WAIT followed by the no-wait form of a coprocessor instruction is merged into the wait form: wait + fnstcw = fstcw
+func wait(operation byte, d *Decoder) (Instruction, error) {
+	next, ok := d.peekNext()
+	if ok == false || next>>3 != 0b11011 {
+		return Instruction{Mnemonic: "wait"}, nil
+	}
+
+	pos := d.pos
+	d.next()
+	instruction, err := escape(next, d)
+	if err == nil {
+		if mnemonic, ok := CoprocessorWaitInstructions[instruction.Mnemonic]; ok {
+			instruction.Mnemonic = mnemonic
+			return instruction, nil
+		}
+	}
+
+	// the ESC is decoded on its own
+	d.pos = pos
+	return Instruction{Mnemonic: "wait"}, nil
+}
+
+// [11011|xxx] [mod|yyy|r/m] [disp-lo?] [disp-hi?]
+// The ESC hands the instruction to the coprocessor, so it's written as the 8087 instruction
+func escape(operation byte, d *Decoder) (Instruction, error) {
+	operand, ok := d.next()
+	if ok == false {
+		return Instruction{}, fmt.Errorf("expected to get an operand for the 'ESC: Escape' instruction")
+	}
+
+	mod := operand >> 6
+	reg := (operand >> 3) & 0b00000111
+	rm := operand & 0b00000111
+	key := (operation&0b00000111)<<3 | reg
+
+	if mod != RegisterModeFieldEncoding {
+		coprocessor, ok := CoprocessorMemoryInstructions[key]
+		if ok == false {
+			return Instruction{}, fmt.Errorf("unknown coprocessor instruction %.8b %.8b for the 'ESC: Escape' instruction", operation, operand)
+		}
+
+		address, err := d.decodeUnaryRegOrMem("ESC: Escape", mod, rm, true)
+		if err != nil {
+			return Instruction{}, err
+		}
+		address.Specifier = coprocessor.specifier
+
+		return Instruction{Mnemonic: coprocessor.mnemonic, Operands: []Operand{address}}, nil
+	}
+
+	if mnemonic, ok := CoprocessorInstructions[uint16(operation)<<8|uint16(operand)]; ok {
+		return Instruction{Mnemonic: mnemonic}, nil
+	}
+
+	mnemonic, ok := CoprocessorStackInstructions[key]
+	if ok == false {
+		return Instruction{}, fmt.Errorf("unknown coprocessor instruction %.8b %.8b for the 'ESC: Escape' instruction", operation, operand)
+	}
+
+	stack := registerOperand(fmt.Sprintf("st%d", rm))
+	top := registerOperand("st0")
+	switch {
+	case key&0b111000 == 0b000000 && reg != 0b010 && reg != 0b011:
+		return Instruction{Mnemonic: mnemonic, Operands: []Operand{top, stack}}, nil
+	case key&0b111000 == 0b100000 || key&0b111000 == 0b110000:
+		return Instruction{Mnemonic: mnemonic, Operands: []Operand{stack, top}}, nil
+	default:
+		return Instruction{Mnemonic: mnemonic, Operands: []Operand{stack}}, nil
+	}
+}
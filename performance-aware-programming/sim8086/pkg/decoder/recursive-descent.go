@@ -0,0 +1,74 @@
+package decoder
+
+import "sort"
+
+// decodeRecursively decodes the instructions reachable from the offset 0 by following the jumps and calls.
+// Linear sweep happily decodes the embedded data (tables, strings) as instructions, this doesn't.
+// The indirect jumps and calls can't be followed, so the code reached only through them is treated as data.
+func (d *Decoder) decodeRecursively() error {
+	covered := make([]bool, len(d.bytes))
+	instructions := make([]Instruction, 0)
+	pending := []int{0}
+
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		d.pos = offset
+		for d.pos < len(d.bytes) && !covered[d.pos] {
+			instruction, ok, err := d.decodeNext()
+			if err != nil {
+				return err
+			}
+			if ok == false {
+				break
+			}
+
+			end := instruction.Offset + instruction.Size
+			if overlaps(covered, instruction.Offset, end) {
+				// the flow runs into the middle of an already decoded instruction, the first decoding wins
+				break
+			}
+
+			for i := instruction.Offset; i < end; i++ {
+				covered[i] = true
+			}
+			instructions = append(instructions, instruction)
+
+			if target, ok := jumpTarget(instruction); ok && target >= 0 && target < len(d.bytes) {
+				pending = append(pending, target)
+			}
+
+			if endsFlow(instruction) {
+				break
+			}
+		}
+	}
+
+	for offset, isCovered := range covered {
+		if !isCovered {
+			instructions = append(instructions, dataInstruction(offset, d.bytes[offset]))
+		}
+	}
+
+	sort.Slice(instructions, func(i, j int) bool {
+		return instructions[i].Offset < instructions[j].Offset
+	})
+
+	if d.Limit > 0 && len(instructions) > d.Limit {
+		instructions = instructions[:d.Limit]
+	}
+
+	d.nodes = append(d.nodes, instructions...)
+	return nil
+}
+
+func overlaps(covered []bool, start int, end int) bool {
+	for i := start; i < end && i < len(covered); i++ {
+		if covered[i] {
+			return true
+		}
+	}
+
+	return false
+}
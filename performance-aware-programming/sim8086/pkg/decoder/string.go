@@ -44,61 +44,63 @@ func repeatPrefix(operation byte, d *Decoder) string {
 }
 
 // [1010010|w]
-func movs(operation byte, d *Decoder) (string, error) {
-	operationType := operation & 0b00000001
-	verifyOperationType(operationType)
-
-	if operationType == WordOperation {
-		return "movsw\n", nil
-	} else {
-		return "movsb\n", nil
-	}
+func movs(operation byte, d *Decoder) (Instruction, error) {
+	return d.stringInstruction("movs", operation, d.stringOperand(0b101), d.stringOperand(0b100)), nil
 }
 
 // [1010011|w]
-func cmps(operation byte, d *Decoder) (string, error) {
-	operationType := operation & 0b00000001
-	verifyOperationType(operationType)
-
-	if operationType == WordOperation {
-		return "cmpsw\n", nil
-	} else {
-		return "cmpsb\n", nil
-	}
+func cmps(operation byte, d *Decoder) (Instruction, error) {
+	return d.stringInstruction("cmps", operation, d.stringOperand(0b100), d.stringOperand(0b101)), nil
 }
 
 // [1010111|w]
-func scas(operation byte, d *Decoder) (string, error) {
-	operationType := operation & 0b00000001
-	verifyOperationType(operationType)
-
-	if operationType == WordOperation {
-		return "scasw\n", nil
-	} else {
-		return "scasb\n", nil
-	}
+func scas(operation byte, d *Decoder) (Instruction, error) {
+	return d.stringInstruction("scas", operation, d.stringOperand(0b101)), nil
 }
 
 // [1010110|w]
-func lods(operation byte, d *Decoder) (string, error) {
+func lods(operation byte, d *Decoder) (Instruction, error) {
+	return d.stringInstruction("lods", operation, d.stringOperand(0b100)), nil
+}
+
+// [1010101|w]
+func stos(operation byte, d *Decoder) (Instruction, error) {
+	return d.stringInstruction("stos", operation, d.stringOperand(0b101)), nil
+}
+
+// stringInstruction is movsb/movsw by default,
+// or movs byte [di], [si] with the implied operands when the decoder has ExplicitStringOperands
+func (d *Decoder) stringInstruction(mnemonic string, operation byte, operands ...Operand) Instruction {
 	operationType := operation & 0b00000001
 	verifyOperationType(operationType)
+	isWord := operationType == WordOperation
 
-	if operationType == WordOperation {
-		return "lodsw\n", nil
-	} else {
-		return "lodsb\n", nil
+	if !d.ExplicitStringOperands {
+		if isWord {
+			return Instruction{Mnemonic: mnemonic + "w"}
+		} else {
+			return Instruction{Mnemonic: mnemonic + "b"}
+		}
 	}
+
+	operands[0].Specifier = sizeSpecifier(isWord)
+	return Instruction{Mnemonic: mnemonic, Operands: operands}
 }
 
-// [1010101|w]
-func stos(operation byte, d *Decoder) (string, error) {
-	operationType := operation & 0b00000001
-	verifyOperationType(operationType)
+// stringOperand is the memory operand the string instructions address implicitly: [si] (r/m = 100) or [di] (r/m = 101).
+// [di] is always in the extra segment, [si] can have the segment override
+func (d *Decoder) stringOperand(rm byte) Operand {
+	operand := Operand{
+		Type: OperandMemory,
+		Memory: EffectiveAddress{
+			Mod: MemoryModeNoDisplacementFieldEncoding,
+			Rm:  rm,
+		},
+	}
 
-	if operationType == WordOperation {
-		return "stosw\n", nil
-	} else {
-		return "stosb\n", nil
+	if rm == 0b100 {
+		operand.Memory.Segment = d.segment
 	}
+
+	return operand
 }
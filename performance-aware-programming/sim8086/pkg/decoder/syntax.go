@@ -0,0 +1,276 @@
+package decoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Syntax of the decoded output
+type Syntax byte
+
+const (
+	SyntaxIntel   Syntax = iota // NASM: mov ax, word [bx + si + 4]
+	SyntaxATT                   // gas/objdump: movw 4(%bx,%si), %ax
+	SyntaxObjdump               // objdump -d -m i8086 without the address and the bytes: movw   $0x1,0x4(%bx,%si)
+)
+
+// formatATT renders the instruction in the AT&T syntax without the line break.
+// The operands are written in the source, destination order. The comment prefix is "#" if empty
+func (i Instruction) formatATT(options FormatOptions) string {
+	var builder strings.Builder
+
+	if i.Prefix != "" {
+		builder.WriteString(i.Prefix + " ")
+	}
+
+	if i.Mnemonic == "db" {
+		builder.WriteString(".byte")
+	} else {
+		builder.WriteString(i.mnemonicATT())
+	}
+
+	indirect := i.Mnemonic == "jmp" || i.Mnemonic == "call"
+	for idx := range i.Operands {
+		operand := i.Operands[idx]
+		if i.reversedATT() {
+			operand = i.Operands[len(i.Operands)-1-idx]
+		}
+
+		if idx == 0 {
+			builder.WriteString(" ")
+		} else {
+			builder.WriteString(", ")
+		}
+
+		if indirect && (operand.Type == OperandRegister || operand.Type == OperandMemory) {
+			builder.WriteString("*")
+		}
+
+		if i.Mnemonic == "db" {
+			builder.WriteString(fmt.Sprintf("0x%02x", operand.Immediate.Value))
+		} else {
+			builder.WriteString(operand.formatATT(options))
+		}
+	}
+
+	if i.Comment != "" {
+		commentPrefix := options.CommentPrefix
+		if commentPrefix == "" {
+			commentPrefix = "#"
+		}
+		builder.WriteString(" " + commentPrefix + " " + i.Comment)
+	}
+
+	return builder.String()
+}
+
+// reversedATT reports whether the operands are written in the source, destination order.
+// AT&T keeps the far pointer in the segment, offset order, and gas doesn't reverse enter and bound
+func (i Instruction) reversedATT() bool {
+	if len(i.Operands) > 0 && i.Operands[0].Type == OperandFarPointer {
+		return false
+	}
+
+	return i.Mnemonic != "enter" && i.Mnemonic != "bound"
+}
+
+// mnemonicATT moves the size and distance keywords of the operands into the mnemonic:
+// mov word [bx], 1 -> movw $1, (%bx); jmp 123:456 -> ljmp $123, $456
+func (i Instruction) mnemonicATT() string {
+	for _, operand := range i.Operands {
+		if operand.Type == OperandFarPointer {
+			return "l" + i.Mnemonic
+		}
+
+		switch operand.Specifier {
+		case "byte":
+			return i.Mnemonic + "b"
+		case "word":
+			return i.Mnemonic + "w"
+		case "far":
+			return "l" + i.Mnemonic
+		}
+	}
+
+	return i.Mnemonic
+}
+
+func (o Operand) formatATT(options FormatOptions) string {
+	switch o.Type {
+	case OperandRegister:
+		return "%" + o.Register
+	case OperandMemory:
+		return o.Memory.formatATT()
+	case OperandImmediate:
+		if o.Immediate.Hex {
+			return fmt.Sprintf("$0x%02x", o.Immediate.Value)
+		} else {
+			return "$" + strconv.Itoa(int(o.Immediate.Value))
+		}
+	case OperandLabel:
+		return options.labelName(o.Target + options.Origin)
+	case OperandOffset:
+		return strconv.Itoa(o.Target + options.Origin)
+	case OperandFarPointer:
+		return fmt.Sprintf("$%d, $%d", o.Pointer.Segment, o.Pointer.Offset)
+	default:
+		panic(fmt.Errorf("AssertionError: unknown operand type %d", o.Type))
+	}
+}
+
+// formatATT renders the address as segment:disp(base,index)
+func (a EffectiveAddress) formatATT() string {
+	address := ""
+	if a.Mod == MemoryModeNoDisplacementFieldEncoding && a.Rm == 0b110 {
+		// the direct address has neither the base nor the index
+		address = strconv.Itoa(int(a.Displacement))
+	} else {
+		registers := strings.Split(EffectiveAddressEquation[a.Rm], " + ")
+		for idx := range registers {
+			registers[idx] = "%" + registers[idx]
+		}
+
+		displacement := ""
+		if a.Mod == MemoryMode8DisplacementFieldEncoding {
+			displacement = strconv.Itoa(int(int8(uint8(a.Displacement))))
+		} else if a.Mod == MemoryMode16DisplacementFieldEncoding {
+			displacement = strconv.Itoa(int(int16(a.Displacement)))
+		} else if a.Mod != MemoryModeNoDisplacementFieldEncoding {
+			panic(fmt.Errorf("AssertionError: Unknown mod for effective address calculation. %.3b", a.Mod))
+		}
+
+		address = fmt.Sprintf("%s(%s)", displacement, strings.Join(registers, ","))
+	}
+
+	if a.Segment != "" {
+		return fmt.Sprintf("%%%s:%s", a.Segment, address)
+	} else {
+		return address
+	}
+}
+
+// objdumpMnemonics are the names objdump gives to the instructions the decoder calls differently
+var objdumpMnemonics = map[string]string{
+	"jz":     "je",
+	"jnz":    "jne",
+	"loopz":  "loope",
+	"loopnz": "loopne",
+	"retf":   "lret",
+	"cbw":    "cbtw",
+	"cwd":    "cwtd",
+}
+
+// formatObjdump renders the instruction the way objdump prints it after the bytes column: the AT&T syntax with
+// the mnemonic padded to 6 characters, no spaces after the commas, hex numbers and the absolute jump targets
+// instead of the labels. The implied shift count 1 is omitted and the port in dx is written as (%dx).
+// The operands of the string instructions aren't spelled out. The comment prefix is "#" if empty
+func (i Instruction) formatObjdump(options FormatOptions) string {
+	var builder strings.Builder
+
+	if i.Prefix != "" {
+		builder.WriteString(i.Prefix + " ")
+	}
+
+	mnemonic := ".byte"
+	if i.Mnemonic != "db" {
+		renamed := i
+		if name, ok := objdumpMnemonics[i.Mnemonic]; ok {
+			renamed.Mnemonic = name
+			// the alias is the name already: jz ; je
+			if i.Comment == name {
+				i.Comment = ""
+			}
+		}
+		mnemonic = renamed.mnemonicATT()
+	}
+
+	operands := make([]string, 0, len(i.Operands))
+	indirect := i.Mnemonic == "jmp" || i.Mnemonic == "call"
+	for idx := range i.Operands {
+		operand := i.Operands[idx]
+		if i.reversedATT() {
+			operand = i.Operands[len(i.Operands)-1-idx]
+		}
+
+		if operand.Type == OperandImmediate && operand.Immediate.Encoding == ImmediateImplied && operand.Immediate.Value == 1 {
+			continue
+		}
+
+		text := ""
+		switch {
+		case i.Mnemonic == "db":
+			text = fmt.Sprintf("0x%02x", operand.Immediate.Value)
+		case (i.Mnemonic == "in" || i.Mnemonic == "out") && operand.Type == OperandRegister && operand.Register == "dx":
+			text = "(%dx)"
+		default:
+			text = operand.formatObjdump(options)
+		}
+
+		if indirect && (operand.Type == OperandRegister || operand.Type == OperandMemory) {
+			text = "*" + text
+		}
+		operands = append(operands, text)
+	}
+
+	if len(operands) == 0 {
+		builder.WriteString(mnemonic)
+	} else {
+		builder.WriteString(fmt.Sprintf("%-6s %s", mnemonic, strings.Join(operands, ",")))
+	}
+
+	if i.Comment != "" {
+		commentPrefix := options.CommentPrefix
+		if commentPrefix == "" {
+			commentPrefix = "#"
+		}
+		builder.WriteString(" " + commentPrefix + " " + i.Comment)
+	}
+
+	return builder.String()
+}
+
+func (o Operand) formatObjdump(options FormatOptions) string {
+	switch o.Type {
+	case OperandRegister:
+		return "%" + o.Register
+	case OperandMemory:
+		return o.Memory.formatObjdump()
+	case OperandImmediate:
+		return fmt.Sprintf("$0x%x", o.Immediate.Unsigned())
+	case OperandLabel, OperandOffset:
+		return fmt.Sprintf("0x%x", o.Target+options.Origin)
+	case OperandFarPointer:
+		return fmt.Sprintf("$0x%x,$0x%x", o.Pointer.Segment, o.Pointer.Offset)
+	default:
+		panic(fmt.Errorf("AssertionError: unknown operand type %d", o.Type))
+	}
+}
+
+// formatObjdump renders the address as segment:disp(base,index) with the hex displacement: %es:-0x5(%bp)
+func (a EffectiveAddress) formatObjdump() string {
+	address := ""
+	if a.Mod == MemoryModeNoDisplacementFieldEncoding && a.Rm == 0b110 {
+		address = fmt.Sprintf("0x%x", a.Displacement)
+	} else {
+		registers := strings.Split(EffectiveAddressEquation[a.Rm], " + ")
+		for idx := range registers {
+			registers[idx] = "%" + registers[idx]
+		}
+
+		displacement := ""
+		if disp := a.Disp(); disp < 0 {
+			displacement = fmt.Sprintf("-0x%x", absSigned16(disp))
+		} else if a.DispWidth() != 0 {
+			displacement = fmt.Sprintf("0x%x", disp)
+		}
+
+		address = fmt.Sprintf("%s(%s)", displacement, strings.Join(registers, ","))
+	}
+
+	if a.Segment != "" {
+		return fmt.Sprintf("%%%s:%s", a.Segment, address)
+	} else {
+		return address
+	}
+}
@@ -0,0 +1,53 @@
+package simulator
+
+import "fmt"
+
+// condition reports whether the conditional jump or the loop is taken, based on the flags
+func (s *Simulator) condition(mnemonic string) bool {
+	flags := s.Registers.Flags
+	carry := flags.Has(FlagCarry)
+	zero := flags.Has(FlagZero)
+	// less = the signed result is negative, taking the overflow into account
+	less := flags.Has(FlagSign) != flags.Has(FlagOverflow)
+
+	switch mnemonic {
+	case "jz", "loopz":
+		return zero
+	case "jnz", "loopnz":
+		return !zero
+	case "jl":
+		return less
+	case "jge":
+		return !less
+	case "jle":
+		return less || zero
+	case "jg":
+		return !less && !zero
+	case "jb":
+		return carry
+	case "jae":
+		return !carry
+	case "jbe":
+		return carry || zero
+	case "ja":
+		return !carry && !zero
+	case "jp":
+		return flags.Has(FlagParity)
+	case "jnp":
+		return !flags.Has(FlagParity)
+	case "jo":
+		return flags.Has(FlagOverflow)
+	case "jno":
+		return !flags.Has(FlagOverflow)
+	case "js":
+		return flags.Has(FlagSign)
+	case "jns":
+		return !flags.Has(FlagSign)
+	case "jcxz":
+		return s.Registers.CX == 0
+	case "loop":
+		return true
+	default:
+		panic(fmt.Errorf("AssertionError: unknown condition %s", mnemonic))
+	}
+}
@@ -0,0 +1,268 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/doichev-kostia/computer-enhance/sim8086/pkg/decoder"
+)
+
+// transferPenalty is the number of clocks added to each word transfer
+// at an odd address on the 8086, and to every word transfer on the 8088 (8-bit bus)
+const transferPenalty = 4
+
+// multiplyDivideClocks with a register operand. mnemonic: byte, word
+var multiplyDivideClocks = map[string][2]int{
+	"mul":  {70, 118},
+	"imul": {80, 128},
+	"div":  {80, 144},
+	"idiv": {101, 165},
+}
+
+// stringClocks of the single execution and of each repetition with the rep prefix, and the transfers per element.
+// mnemonic without the size suffix: {clocks, clocks per repetition, transfers}
+var stringClocks = map[string][3]int{
+	"movs": {18, 17, 2},
+	"cmps": {22, 22, 2},
+	"scas": {15, 15, 1},
+	"lods": {12, 13, 1},
+	"stos": {11, 10, 1},
+}
+
+// EstimateCycles returns the 8086 clocks of the instruction, including the effective address calculation,
+// and the number of memory transfers it makes. Table 2-21 in the "Instruction reference".
+// taken tells whether the conditional jump or the loop transfers the control
+func EstimateCycles(instruction decoder.Instruction, taken bool) (clocks int, transfers int, err error) {
+	operands := instruction.Operands
+	ea := 0
+	for _, operand := range operands {
+		if operand.Type == decoder.OperandMemory {
+			ea = effectiveAddressClocks(operand.Memory)
+		}
+	}
+
+	// kinds of the destination and the source: r = register, m = memory, i = immediate
+	form := ""
+	for _, operand := range operands {
+		switch operand.Type {
+		case decoder.OperandRegister:
+			form += "r"
+		case decoder.OperandMemory:
+			form += "m"
+		case decoder.OperandImmediate:
+			form += "i"
+		}
+	}
+
+	accumulator := len(operands) > 0 && operands[0].Type == decoder.OperandRegister &&
+		(operands[0].Register == "ax" || operands[0].Register == "al")
+
+	switch instruction.Mnemonic {
+	case "mov":
+		switch {
+		case form == "rm" && accumulator && isDirectAddress(operands[1]):
+			return 10, 1, nil
+		case form == "mr" && isDirectAddress(operands[0]) && (operands[1].Register == "ax" || operands[1].Register == "al"):
+			return 10, 1, nil
+		case form == "rr":
+			return 2, 0, nil
+		case form == "rm":
+			return 8 + ea, 1, nil
+		case form == "mr":
+			return 9 + ea, 1, nil
+		case form == "ri":
+			return 4, 0, nil
+		case form == "mi":
+			return 10 + ea, 1, nil
+		}
+
+	case "add", "adc", "sub", "sbb", "and", "or", "xor":
+		switch form {
+		case "rr":
+			return 3, 0, nil
+		case "rm":
+			return 9 + ea, 1, nil
+		case "mr":
+			return 16 + ea, 2, nil
+		case "ri":
+			return 4, 0, nil
+		case "mi":
+			return 17 + ea, 2, nil
+		}
+
+	case "cmp":
+		switch form {
+		case "rr":
+			return 3, 0, nil
+		case "rm", "mr":
+			return 9 + ea, 1, nil
+		case "ri":
+			return 4, 0, nil
+		case "mi":
+			return 10 + ea, 1, nil
+		}
+
+	case "test":
+		switch {
+		case form == "rr":
+			return 3, 0, nil
+		case form == "rm" || form == "mr":
+			return 9 + ea, 1, nil
+		case form == "ri" && accumulator:
+			return 4, 0, nil
+		case form == "ri":
+			return 5, 0, nil
+		case form == "mi":
+			return 11 + ea, 1, nil
+		}
+
+	case "not", "neg":
+		switch form {
+		case "r":
+			return 3, 0, nil
+		case "m":
+			return 16 + ea, 2, nil
+		}
+
+	case "inc", "dec":
+		switch {
+		// the one-byte form of the word registers
+		case form == "r" && instruction.Size == 1:
+			return 2, 0, nil
+		case form == "r":
+			return 3, 0, nil
+		case form == "m":
+			return 15 + ea, 2, nil
+		}
+
+	// the clocks depend on the operands, the lower bound is used
+	case "mul", "imul", "div", "idiv":
+		clocks := multiplyDivideClocks[instruction.Mnemonic]
+
+		isWord := 0
+		if isWordOperation(instruction) {
+			isWord = 1
+		}
+
+		switch form {
+		case "r":
+			return clocks[isWord], 0, nil
+		case "m":
+			return clocks[isWord] + 6 + ea, 1, nil
+		}
+
+	case "jmp":
+		return 15, 0, nil
+
+	case "jz", "jl", "jle", "jb", "jbe", "jp", "jo", "js", "jnz", "jge", "jg", "jae", "ja", "jnp", "jno", "jns":
+		if taken {
+			return 16, 0, nil
+		}
+		return 4, 0, nil
+
+	case "loop":
+		if taken {
+			return 17, 0, nil
+		}
+		return 5, 0, nil
+
+	case "loopz", "jcxz":
+		if taken {
+			return 18, 0, nil
+		}
+		return 6, 0, nil
+
+	case "loopnz":
+		if taken {
+			return 19, 0, nil
+		}
+		return 5, 0, nil
+
+	case "hlt":
+		return 2, 0, nil
+
+	// the clocks of the interrupt handler aren't counted
+	case "int":
+		return 51, 5, nil
+	case "int3":
+		return 52, 5, nil
+	case "into":
+		if taken {
+			return 53, 5, nil
+		}
+		return 4, 0, nil
+
+	case "lahf", "sahf":
+		return 4, 0, nil
+
+	case "cld", "std":
+		return 2, 0, nil
+
+	// the repetitions are only known once the instruction is executed, see stringRepetitionCycles
+	case "movsb", "movsw", "cmpsb", "cmpsw", "scasb", "scasw", "lodsb", "lodsw", "stosb", "stosw":
+		clocks := stringClocks[instruction.Mnemonic[:4]]
+		if strings.HasPrefix(instruction.Prefix, "rep") {
+			return 9, 0, nil
+		}
+		return clocks[0], clocks[2], nil
+
+	case "cbw":
+		return 2, 0, nil
+	case "cwd":
+		return 5, 0, nil
+	}
+
+	return 0, 0, fmt.Errorf("the clocks of the '%s' instruction with the '%s' operands are unknown", instruction.Mnemonic, form)
+}
+
+// stringRepetitionCycles are the clocks and the transfers the string instruction with the rep prefix
+// adds for the number of the repetitions, on top of the EstimateCycles
+func stringRepetitionCycles(instruction decoder.Instruction, repetitions int) (clocks int, transfers int) {
+	perRepetition, ok := stringClocks[strings.TrimRight(instruction.Mnemonic, "bw")]
+	if !ok {
+		return 0, 0
+	}
+
+	return perRepetition[1] * repetitions, perRepetition[2] * repetitions
+}
+
+// effectiveAddressClocks is the time the 8086 spends on calculating the effective address. Table 2-20
+func effectiveAddressClocks(address decoder.EffectiveAddress) int {
+	clocks := 0
+	hasDisplacement := address.Mod != decoder.MemoryModeNoDisplacementFieldEncoding
+
+	switch {
+	case address.Mod == decoder.MemoryModeNoDisplacementFieldEncoding && address.Rm == 0b110:
+		clocks = 6 // displacement only
+	case address.Rm >= 0b100:
+		// base or index: si, di, bp, bx
+		clocks = 5
+		if hasDisplacement {
+			clocks = 9
+		}
+	case address.Rm == 0b000 || address.Rm == 0b011:
+		// bx + si, bp + di
+		clocks = 7
+		if hasDisplacement {
+			clocks = 11
+		}
+	default:
+		// bx + di, bp + si
+		clocks = 8
+		if hasDisplacement {
+			clocks = 12
+		}
+	}
+
+	if address.Segment != "" {
+		clocks += 2
+	}
+
+	return clocks
+}
+
+func isDirectAddress(operand decoder.Operand) bool {
+	return operand.Type == decoder.OperandMemory &&
+		operand.Memory.Mod == decoder.MemoryModeNoDisplacementFieldEncoding &&
+		operand.Memory.Rm == 0b110
+}
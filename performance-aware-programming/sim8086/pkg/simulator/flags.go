@@ -0,0 +1,76 @@
+package simulator
+
+import "strings"
+
+// Flags is the 8086 flags register. Figure 2-32 in the "Instruction reference"
+// | 15 14 13 12 | 11 | 10 | 9  | 8  | 7  | 6  | 5 | 4  | 3 | 2  | 1 | 0  |
+// | x  x  x  x  | OF | DF | IF | TF | SF | ZF | x | AF | x | PF | x | CF |
+type Flags uint16
+
+const (
+	FlagCarry     Flags = 1 << 0
+	FlagParity    Flags = 1 << 2
+	FlagAuxiliary Flags = 1 << 4
+	FlagZero      Flags = 1 << 6
+	FlagSign      Flags = 1 << 7
+	FlagTrap      Flags = 1 << 8
+	FlagInterrupt Flags = 1 << 9
+	FlagDirection Flags = 1 << 10
+	FlagOverflow  Flags = 1 << 11
+)
+
+// flagsReserved is the bit 1 that always reads as 1 on the 8086
+const flagsReserved Flags = 1 << 1
+
+// flagsLowByte are the flags that LAHF/SAHF transfer (the 8080 flags)
+const flagsLowByte = FlagSign | FlagZero | FlagAuxiliary | FlagParity | FlagCarry
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag != 0
+}
+
+func (f *Flags) Set(flag Flags, value bool) {
+	if value {
+		*f |= flag
+	} else {
+		*f &^= flag
+	}
+}
+
+// Low returns the byte LAHF loads into AH: SF ZF x AF x PF x CF
+func (f Flags) Low() byte {
+	return byte((f & flagsLowByte) | flagsReserved)
+}
+
+// SetLow replaces SF, ZF, AF, PF and CF with the ones from the byte, the way SAHF does
+func (f *Flags) SetLow(value byte) {
+	*f = (*f &^ flagsLowByte) | (Flags(value) & flagsLowByte)
+}
+
+// flagNames in the order they are displayed
+var flagNames = []struct {
+	flag Flags
+	name string
+}{
+	{FlagCarry, "C"},
+	{FlagParity, "P"},
+	{FlagAuxiliary, "A"},
+	{FlagZero, "Z"},
+	{FlagSign, "S"},
+	{FlagTrap, "T"},
+	{FlagInterrupt, "I"},
+	{FlagDirection, "D"},
+	{FlagOverflow, "O"},
+}
+
+// String lists the flags that are set: CPAZSTIDO
+func (f Flags) String() string {
+	var builder strings.Builder
+	for _, flag := range flagNames {
+		if f.Has(flag.flag) {
+			builder.WriteString(flag.name)
+		}
+	}
+
+	return builder.String()
+}
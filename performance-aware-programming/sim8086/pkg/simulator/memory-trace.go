@@ -0,0 +1,53 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// MemoryAccess is a read or a write of the data memory. The instruction fetches aren't recorded
+type MemoryAccess struct {
+	Address uint32 // the 20-bit physical address
+	Size    int    // 1 or 2 bytes
+	Write   bool
+}
+
+// MemoryTrace records the memory accesses of the simulated program, see Simulator.MemTrace
+type MemoryTrace struct {
+	Accesses []MemoryAccess
+}
+
+func (t *MemoryTrace) record(address uint32, isWord bool, write bool) {
+	size := 1
+	if isWord {
+		size = 2
+	}
+
+	t.Accesses = append(t.Accesses, MemoryAccess{Address: address, Size: size, Write: write})
+}
+
+// WriteCSV writes the accesses in the order they happened: address,size,access
+// 0x003e8,2,read
+func (t *MemoryTrace) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"address", "size", "access"}); err != nil {
+		return err
+	}
+
+	for _, access := range t.Accesses {
+		kind := "read"
+		if access.Write {
+			kind = "write"
+		}
+
+		record := []string{fmt.Sprintf("0x%05x", access.Address), strconv.Itoa(access.Size), kind}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
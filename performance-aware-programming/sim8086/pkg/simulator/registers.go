@@ -0,0 +1,90 @@
+package simulator
+
+import "fmt"
+
+type Registers struct {
+	AX uint16
+	BX uint16
+	CX uint16
+	DX uint16
+	SP uint16
+	BP uint16
+	SI uint16
+	DI uint16
+
+	ES uint16
+	CS uint16
+	SS uint16
+	DS uint16
+
+	IP    uint16
+	Flags Flags
+}
+
+// word returns the 16-bit register the name refers to; for the 8-bit registers it's the register that contains them
+func (r *Registers) word(name string) *uint16 {
+	switch name {
+	case "ax", "al", "ah":
+		return &r.AX
+	case "bx", "bl", "bh":
+		return &r.BX
+	case "cx", "cl", "ch":
+		return &r.CX
+	case "dx", "dl", "dh":
+		return &r.DX
+	case "sp":
+		return &r.SP
+	case "bp":
+		return &r.BP
+	case "si":
+		return &r.SI
+	case "di":
+		return &r.DI
+	case "es":
+		return &r.ES
+	case "cs":
+		return &r.CS
+	case "ss":
+		return &r.SS
+	case "ds":
+		return &r.DS
+	default:
+		panic(fmt.Errorf("AssertionError: unknown register %s", name))
+	}
+}
+
+// isByteRegister reports whether the name refers to an 8-bit register. al, ah, bl, ...
+func isByteRegister(name string) bool {
+	return len(name) == 2 && (name[1] == 'l' || name[1] == 'h')
+}
+
+// Get reads the register by its name. The 8-bit registers are the low and the high byte of ax, bx, cx and dx
+func (r *Registers) Get(name string) uint16 {
+	value := *r.word(name)
+
+	if !isByteRegister(name) {
+		return value
+	}
+
+	if name[1] == 'h' {
+		return value >> 8
+	} else {
+		return value & 0x00ff
+	}
+}
+
+// Set writes the register by its name. Writing an 8-bit register keeps the other half intact
+func (r *Registers) Set(name string, value uint16) {
+	word := r.word(name)
+
+	if !isByteRegister(name) {
+		*word = value
+		return
+	}
+
+	if name[1] == 'h' {
+		*word = (*word & 0x00ff) | (value&0x00ff)<<8
+	} else {
+		*word = (*word & 0xff00) | (value & 0x00ff)
+	}
+}
@@ -0,0 +1,715 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+
+	"github.com/doichev-kostia/computer-enhance/sim8086/pkg/decoder"
+)
+
+// ErrDivideError is the interrupt 0 the 8086 raises when the divisor is 0 or the quotient doesn't fit the destination
+var ErrDivideError = errors.New("divide error (interrupt 0)")
+
+// ErrStepBudgetExceeded is returned by Run when the program executes more than MaxSteps instructions
+var ErrStepBudgetExceeded = errors.New("the step budget is exceeded")
+
+// ExecutionError is the failure to execute the instruction, located by the CS:IP it was fetched from.
+// The Instruction is empty when the bytes at CS:IP failed to decode
+type ExecutionError struct {
+	Instruction decoder.Instruction
+	CS          uint16
+	IP          uint16
+	Err         error
+}
+
+func (e *ExecutionError) Error() string {
+	if e.Instruction.Mnemonic == "" {
+		return fmt.Sprintf("at %04x:%04x: %v", e.CS, e.IP, e.Err)
+	}
+	return fmt.Sprintf("at %04x:%04x '%s': %v", e.CS, e.IP, e.Instruction, e.Err)
+}
+
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// MemorySize is the 1MB address space of the 8086 (20-bit addresses)
+const MemorySize = 1 << 20
+
+type Simulator struct {
+	Registers Registers
+	Memory    []byte
+
+	// InterruptHandler is invoked when an interrupt is raised: int N, int3, into, or the divide error (0).
+	// It's where the embedder implements the system calls, e.g. int 21h. An error aborts the run.
+	// Without the handler the interrupts are errors, the divide error is ErrDivideError
+	InterruptHandler func(vector byte, registers *Registers) error
+
+	// CPU8088 also counts the clocks of the 8088, which transfers the words a byte at a time
+	CPU8088 bool
+
+	// Cycles is the number of the 8086 clocks spent by the executed instructions
+	Cycles int
+
+	// Cycles8088 is the number of the 8088 clocks, counted when CPU8088 is set
+	Cycles8088 int
+
+	// MemTrace records every read and write of the memory operands when it's not nil
+	MemTrace *MemoryTrace
+
+	// MaxSteps stops Run with ErrStepBudgetExceeded after the number of instructions, e.g. in an infinite loop.
+	// 0 = unlimited
+	MaxSteps int
+
+	// Halted is set by hlt, Run doesn't execute anything until it's cleared. Load clears it
+	Halted bool
+
+	imageEnd    int // IP right after the loaded program
+	repetitions int // the number of times the last string instruction with the rep prefix was repeated
+}
+
+func NewSimulator() *Simulator {
+	return &Simulator{
+		Registers: Registers{},
+		Memory:    make([]byte, MemorySize),
+	}
+}
+
+// Load copies the program to the linear address and points CS:IP at it.
+// CS is the 64K block the address is in, so IP is the address within the block: 0x100 is 0000:0100 like a COM file.
+// The image has to fit into the memory and into the code segment, it's never truncated and IP never wraps
+func (s *Simulator) Load(image []byte, at uint32) error {
+	if at >= MemorySize || len(image) > MemorySize-int(at) {
+		return fmt.Errorf("the image of %d bytes at 0x%05x doesn't fit into the memory of 0x%05x bytes", len(image), at, MemorySize)
+	}
+	if int(at&0xffff)+len(image) > 0x10000 {
+		return fmt.Errorf("the image of %d bytes at 0x%05x crosses the end of the code segment at 0x%05x", len(image), at, (at&^0xffff)+0x10000)
+	}
+
+	copy(s.Memory[at:], image)
+	s.Registers.CS = uint16((at &^ 0xffff) >> 4)
+	s.Registers.IP = uint16(at & 0xffff)
+	s.imageEnd = int(s.Registers.IP) + len(image)
+	s.Halted = false
+	return nil
+}
+
+// Step decodes the instruction at CS:IP from the memory and executes it.
+// The instruction is decoded on demand, so the jumps and the code that modifies itself work
+func (s *Simulator) Step() (decoder.Instruction, error) {
+	base := uint32(s.Registers.CS) << 4
+	end := min(base+0x10000, MemorySize) // the code segment
+
+	// the decoder counts the offsets from CS:0, so they are the values of IP
+	d := decoder.NewDecoder(s.Memory[base:end])
+	instruction, err := d.DecodeAt(int(s.Registers.IP))
+	if errors.Is(err, io.EOF) {
+		err = errors.New("expected an instruction before the end of the memory")
+	}
+	if err != nil {
+		return decoder.Instruction{}, &ExecutionError{CS: s.Registers.CS, IP: s.Registers.IP, Err: err}
+	}
+
+	// the targets wrap around within the segment
+	for idx, operand := range instruction.Operands {
+		if operand.Type == decoder.OperandLabel || operand.Type == decoder.OperandOffset {
+			instruction.Operands[idx].Target = int(uint16(operand.Target))
+		}
+	}
+
+	// the address must be calculated before the registers it's based on are changed
+	oddAddress := false
+	for _, operand := range instruction.Operands {
+		if operand.Type == decoder.OperandMemory {
+			oddAddress = s.physicalAddress(operand.Memory)%2 == 1
+		}
+	}
+
+	ip := s.Registers.IP
+	next := s.Registers.IP + uint16(instruction.Size)
+	if err := s.Execute(instruction); err != nil {
+		return instruction, err
+	}
+
+	clocks, transfers, err := EstimateCycles(instruction, s.Registers.IP != next)
+	if err != nil {
+		return instruction, &ExecutionError{Instruction: instruction, CS: s.Registers.CS, IP: ip, Err: err}
+	}
+	if isRepeated(instruction) {
+		repetitionClocks, repetitionTransfers := stringRepetitionCycles(instruction, s.repetitions)
+		clocks += repetitionClocks
+		transfers += repetitionTransfers
+	}
+
+	isWord := len(instruction.Operands) > 0 && isWordOperation(instruction)
+	if isWord && oddAddress {
+		s.Cycles += clocks + transfers*transferPenalty
+	} else {
+		s.Cycles += clocks
+	}
+
+	if s.CPU8088 {
+		if isWord {
+			s.Cycles8088 += clocks + transfers*transferPenalty
+		} else {
+			s.Cycles8088 += clocks
+		}
+	}
+
+	return instruction, nil
+}
+
+// Run executes the instructions until hlt or until IP goes past the loaded program, at most MaxSteps of them
+func (s *Simulator) Run() error {
+	for steps := 0; !s.Halted && int(s.Registers.IP) < s.imageEnd; steps++ {
+		if s.MaxSteps > 0 && steps == s.MaxSteps {
+			return ErrStepBudgetExceeded
+		}
+		if _, err := s.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Execute applies the instruction to the registers and the memory.
+// IP is advanced past the instruction before it's executed, the way the 8086 does.
+// The errors are *ExecutionError with the CS:IP of the instruction
+func (s *Simulator) Execute(instruction decoder.Instruction) error {
+	cs, ip := s.Registers.CS, s.Registers.IP
+	if err := s.execute(instruction); err != nil {
+		return &ExecutionError{Instruction: instruction, CS: cs, IP: ip, Err: err}
+	}
+
+	return nil
+}
+
+func (s *Simulator) execute(instruction decoder.Instruction) error {
+	s.Registers.IP += uint16(instruction.Size)
+
+	switch instruction.Mnemonic {
+	case "mov":
+		isWord := isWordOperation(instruction)
+		s.write(instruction.Operands[0], isWord, s.read(instruction.Operands[1], isWord))
+
+	case "add", "adc", "sub", "sbb", "cmp":
+		isWord := isWordOperation(instruction)
+		dest := s.read(instruction.Operands[0], isWord)
+		src := s.read(instruction.Operands[1], isWord)
+
+		// adc adds the carry in, sbb subtracts the borrow
+		carry := uint16(0)
+		if (instruction.Mnemonic == "adc" || instruction.Mnemonic == "sbb") && s.Registers.Flags.Has(FlagCarry) {
+			carry = 1
+		}
+
+		result := uint16(0)
+		if instruction.Mnemonic == "add" || instruction.Mnemonic == "adc" {
+			result = s.add(dest, src, carry, isWord)
+		} else {
+			result = s.subtract(dest, src, carry, isWord)
+		}
+
+		// cmp only sets the flags
+		if instruction.Mnemonic != "cmp" {
+			s.write(instruction.Operands[0], isWord, result)
+		}
+
+	case "and", "or", "xor", "test":
+		isWord := isWordOperation(instruction)
+		dest := s.read(instruction.Operands[0], isWord)
+		src := s.read(instruction.Operands[1], isWord)
+
+		result := uint16(0)
+		switch instruction.Mnemonic {
+		case "and", "test":
+			result = dest & src
+		case "or":
+			result = dest | src
+		case "xor":
+			result = dest ^ src
+		}
+
+		// AF is undefined and left intact
+		s.Registers.Flags.Set(FlagCarry, false)
+		s.Registers.Flags.Set(FlagOverflow, false)
+		s.setResultFlags(result, isWord)
+
+		// test only sets the flags
+		if instruction.Mnemonic != "test" {
+			s.write(instruction.Operands[0], isWord, result)
+		}
+
+	case "not":
+		// not doesn't affect the flags
+		isWord := isWordOperation(instruction)
+		s.write(instruction.Operands[0], isWord, truncate(^s.read(instruction.Operands[0], isWord), isWord))
+
+	case "neg":
+		// 0 - operand: CF is set unless the operand is 0, OF is set for the minimal signed value (-128 or -32768)
+		isWord := isWordOperation(instruction)
+		s.write(instruction.Operands[0], isWord, s.subtract(0, s.read(instruction.Operands[0], isWord), 0, isWord))
+
+	case "inc", "dec":
+		// the same as add/sub 1 except that CF is left intact
+		isWord := isWordOperation(instruction)
+		dest := s.read(instruction.Operands[0], isWord)
+		carry := s.Registers.Flags.Has(FlagCarry)
+
+		result := uint16(0)
+		if instruction.Mnemonic == "inc" {
+			result = s.add(dest, 1, 0, isWord)
+		} else {
+			result = s.subtract(dest, 1, 0, isWord)
+		}
+
+		s.Registers.Flags.Set(FlagCarry, carry)
+		s.write(instruction.Operands[0], isWord, result)
+
+	case "jmp":
+		target := instruction.Operands[0]
+		if target.Type != decoder.OperandLabel && target.Type != decoder.OperandOffset {
+			return fmt.Errorf("only the direct 'jmp' is supported by the simulator")
+		}
+		s.Registers.IP = uint16(target.Target)
+
+	case "jz", "jl", "jle", "jb", "jbe", "jp", "jo", "js", "jnz", "jge", "jg", "jae", "ja", "jnp", "jno", "jns", "jcxz":
+		if s.condition(instruction.Mnemonic) {
+			s.Registers.IP = uint16(instruction.Operands[0].Target)
+		}
+
+	case "loop", "loopz", "loopnz":
+		s.Registers.CX -= 1
+		if s.Registers.CX != 0 && s.condition(instruction.Mnemonic) {
+			s.Registers.IP = uint16(instruction.Operands[0].Target)
+		}
+
+	case "hlt":
+		// the 8086 waits for an interrupt, the simulator stops
+		s.Halted = true
+
+	case "mul", "imul":
+		isWord := isWordOperation(instruction)
+		s.multiply(instruction.Mnemonic == "imul", s.read(instruction.Operands[0], isWord), isWord)
+
+	case "div", "idiv":
+		isWord := isWordOperation(instruction)
+		err := s.divide(instruction.Mnemonic == "idiv", s.read(instruction.Operands[0], isWord), isWord)
+		if errors.Is(err, ErrDivideError) && s.InterruptHandler != nil {
+			return s.interrupt(0)
+		} else if err != nil {
+			return err
+		}
+
+	case "int":
+		return s.interrupt(byte(instruction.Operands[0].Immediate.Value))
+	case "int3":
+		return s.interrupt(3)
+	case "into":
+		if s.Registers.Flags.Has(FlagOverflow) {
+			return s.interrupt(4)
+		}
+
+	case "cbw":
+		// sign-extends al into ax
+		s.Registers.AX = uint16(int16(int8(uint8(s.Registers.AX))))
+	case "cwd":
+		// sign-extends ax into dx:ax
+		if s.Registers.AX&0x8000 != 0 {
+			s.Registers.DX = 0xffff
+		} else {
+			s.Registers.DX = 0
+		}
+
+	// the direction of the string instructions: DF = 0 increments SI and DI, DF = 1 decrements them
+	case "cld":
+		s.Registers.Flags.Set(FlagDirection, false)
+	case "std":
+		s.Registers.Flags.Set(FlagDirection, true)
+
+	case "movsb", "movsw", "cmpsb", "cmpsw", "scasb", "scasw", "lodsb", "lodsw", "stosb", "stosw":
+		s.repetitions = s.executeString(instruction)
+
+	case "lahf":
+		s.Registers.Set("ah", uint16(s.Registers.Flags.Low()))
+	case "sahf":
+		s.Registers.Flags.SetLow(byte(s.Registers.Get("ah")))
+
+	default:
+		return fmt.Errorf("the '%s' instruction isn't supported by the simulator", instruction.Mnemonic)
+	}
+
+	return nil
+}
+
+// Dump renders the final state in the format of the computer-enhance reference simulator:
+// the registers that aren't zero (ax, bx, cx, dx, sp, bp, si, di, es, cs, ss, ds), ip and the flags that are set.
+// The clocks counted by Step follow, and the 8088 clocks with CPU8088
+func (s *Simulator) Dump() string {
+	var builder strings.Builder
+
+	builder.WriteString("Final registers:\n")
+
+	registers := []string{"ax", "bx", "cx", "dx", "sp", "bp", "si", "di", "es", "cs", "ss", "ds"}
+	for _, name := range registers {
+		if value := s.Registers.Get(name); value != 0 {
+			builder.WriteString(fmt.Sprintf("      %s: 0x%04x (%d)\n", name, value, value))
+		}
+	}
+
+	if s.Registers.IP != 0 {
+		builder.WriteString(fmt.Sprintf("      ip: 0x%04x (%d)\n", s.Registers.IP, s.Registers.IP))
+	}
+
+	if flags := s.Registers.Flags.String(); flags != "" {
+		builder.WriteString(fmt.Sprintf("   flags: %s\n", flags))
+	}
+
+	if s.Cycles != 0 {
+		builder.WriteString(fmt.Sprintf("  clocks: %d\n", s.Cycles))
+	}
+	if s.CPU8088 && s.Cycles8088 != 0 {
+		builder.WriteString(fmt.Sprintf("    8088: %d\n", s.Cycles8088))
+	}
+
+	return builder.String()
+}
+
+// executeString runs the string instruction once, or CX times with the rep prefix.
+// repz and repnz also stop cmps and scas as soon as ZF is cleared or set. Returns the number of the repetitions
+func (s *Simulator) executeString(instruction decoder.Instruction) int {
+	isWord := strings.HasSuffix(instruction.Mnemonic, "w")
+	if !isRepeated(instruction) {
+		s.stringStep(instruction.Mnemonic[:4], isWord)
+		return 0
+	}
+
+	repetitions := 0
+	for s.Registers.CX != 0 {
+		s.stringStep(instruction.Mnemonic[:4], isWord)
+		s.Registers.CX -= 1
+		repetitions += 1
+
+		if instruction.Prefix == "repz" && !s.Registers.Flags.Has(FlagZero) {
+			break
+		}
+		if instruction.Prefix == "repnz" && s.Registers.Flags.Has(FlagZero) {
+			break
+		}
+	}
+
+	return repetitions
+}
+
+// stringStep processes a single element: the source is ds:[si], the destination is es:[di].
+// SI and DI move to the next element, backwards when DF is set
+func (s *Simulator) stringStep(mnemonic string, isWord bool) {
+	source := decoder.Operand{Type: decoder.OperandMemory, Memory: decoder.EffectiveAddress{Rm: 0b100}}
+	dest := decoder.Operand{Type: decoder.OperandMemory, Memory: decoder.EffectiveAddress{Rm: 0b101, Segment: "es"}}
+	accumulator := "ax"
+	if !isWord {
+		accumulator = "al"
+	}
+
+	delta := uint16(1)
+	if isWord {
+		delta = 2
+	}
+	if s.Registers.Flags.Has(FlagDirection) {
+		delta = -delta
+	}
+
+	switch mnemonic {
+	case "movs":
+		s.write(dest, isWord, s.read(source, isWord))
+		s.Registers.SI += delta
+		s.Registers.DI += delta
+	case "cmps":
+		// the destination is subtracted from the source, unlike cmp
+		s.subtract(s.read(source, isWord), s.read(dest, isWord), 0, isWord)
+		s.Registers.SI += delta
+		s.Registers.DI += delta
+	case "scas":
+		s.subtract(s.Registers.Get(accumulator), s.read(dest, isWord), 0, isWord)
+		s.Registers.DI += delta
+	case "lods":
+		s.Registers.Set(accumulator, s.read(source, isWord))
+		s.Registers.SI += delta
+	case "stos":
+		s.write(dest, isWord, s.Registers.Get(accumulator))
+		s.Registers.DI += delta
+	}
+}
+
+// isRepeated tells whether the string instruction has the rep, repz or repnz prefix
+func isRepeated(instruction decoder.Instruction) bool {
+	return strings.HasPrefix(instruction.Prefix, "rep")
+}
+
+// add sets CF, OF and AF in addition to the result flags
+func (s *Simulator) add(dest uint16, src uint16, carry uint16, isWord bool) uint16 {
+	full := uint32(dest) + uint32(src) + uint32(carry)
+	result := truncate(uint16(full), isWord)
+
+	s.Registers.Flags.Set(FlagCarry, full > uint32(truncate(0xffff, isWord)))
+	// the signs of the operands are the same, but the sign of the result is different
+	s.Registers.Flags.Set(FlagOverflow, (dest^result)&(src^result)&signBit(isWord) != 0)
+	s.Registers.Flags.Set(FlagAuxiliary, (dest^src^result)&0x10 != 0)
+	s.setResultFlags(result, isWord)
+
+	return result
+}
+
+// subtract sets CF, OF and AF in addition to the result flags. CF is the borrow
+func (s *Simulator) subtract(dest uint16, src uint16, borrow uint16, isWord bool) uint16 {
+	result := truncate(dest-src-borrow, isWord)
+
+	s.Registers.Flags.Set(FlagCarry, uint32(src)+uint32(borrow) > uint32(dest))
+	// the signs of the operands are different, and the sign of the result differs from the destination
+	s.Registers.Flags.Set(FlagOverflow, (dest^src)&(dest^result)&signBit(isWord) != 0)
+	s.Registers.Flags.Set(FlagAuxiliary, (dest^src^result)&0x10 != 0)
+	s.setResultFlags(result, isWord)
+
+	return result
+}
+
+func (s *Simulator) interrupt(vector byte) error {
+	if s.InterruptHandler == nil {
+		return fmt.Errorf("the interrupt %d has no handler", vector)
+	}
+
+	return s.InterruptHandler(vector, &s.Registers)
+}
+
+// multiply al by the byte into ax, or ax by the word into dx:ax.
+// CF and OF are set when the high half of the result is significant, the other flags are undefined and left intact
+func (s *Simulator) multiply(signed bool, src uint16, isWord bool) {
+	significant := false
+
+	if isWord {
+		product := uint32(0)
+		if signed {
+			signedProduct := int32(int16(s.Registers.AX)) * int32(int16(src))
+			product = uint32(signedProduct)
+			significant = signedProduct != int32(int16(signedProduct))
+		} else {
+			product = uint32(s.Registers.AX) * uint32(src)
+			significant = product > 0xffff
+		}
+
+		s.Registers.AX = uint16(product)
+		s.Registers.DX = uint16(product >> 16)
+	} else {
+		product := uint16(0)
+		if signed {
+			signedProduct := int16(int8(uint8(s.Registers.AX))) * int16(int8(uint8(src)))
+			product = uint16(signedProduct)
+			significant = signedProduct != int16(int8(signedProduct))
+		} else {
+			product = (s.Registers.AX & 0x00ff) * src
+			significant = product > 0xff
+		}
+
+		s.Registers.AX = product
+	}
+
+	s.Registers.Flags.Set(FlagCarry, significant)
+	s.Registers.Flags.Set(FlagOverflow, significant)
+}
+
+// divide ax by the byte into al (quotient) and ah (remainder), or dx:ax by the word into ax and dx.
+// The flags are undefined and left intact
+func (s *Simulator) divide(signed bool, src uint16, isWord bool) error {
+	if src == 0 {
+		return ErrDivideError
+	}
+
+	if isWord {
+		dividend := uint32(s.Registers.DX)<<16 | uint32(s.Registers.AX)
+
+		if signed {
+			quotient := int32(dividend) / int32(int16(src))
+			remainder := int32(dividend) % int32(int16(src))
+			if quotient > 0x7fff || quotient < -0x7fff {
+				return ErrDivideError
+			}
+			s.Registers.AX = uint16(quotient)
+			s.Registers.DX = uint16(remainder)
+		} else {
+			quotient := dividend / uint32(src)
+			if quotient > 0xffff {
+				return ErrDivideError
+			}
+			s.Registers.AX = uint16(quotient)
+			s.Registers.DX = uint16(dividend % uint32(src))
+		}
+
+		return nil
+	}
+
+	if signed {
+		quotient := int16(s.Registers.AX) / int16(int8(uint8(src)))
+		remainder := int16(s.Registers.AX) % int16(int8(uint8(src)))
+		if quotient > 0x7f || quotient < -0x7f {
+			return ErrDivideError
+		}
+		s.Registers.AX = uint16(uint8(remainder))<<8 | uint16(uint8(quotient))
+	} else {
+		quotient := s.Registers.AX / src
+		if quotient > 0xff {
+			return ErrDivideError
+		}
+		s.Registers.AX = (s.Registers.AX%src)<<8 | quotient
+	}
+
+	return nil
+}
+
+// setResultFlags sets ZF, SF and PF from the result of the operation
+func (s *Simulator) setResultFlags(result uint16, isWord bool) {
+	s.Registers.Flags.Set(FlagZero, result == 0)
+	s.Registers.Flags.Set(FlagSign, result&signBit(isWord) != 0)
+	// PF is set when the low byte has an even number of 1 bits
+	s.Registers.Flags.Set(FlagParity, bits.OnesCount8(uint8(result))%2 == 0)
+}
+
+func (s *Simulator) read(operand decoder.Operand, isWord bool) uint16 {
+	switch operand.Type {
+	case decoder.OperandRegister:
+		return s.Registers.Get(operand.Register)
+	case decoder.OperandImmediate:
+		return truncate(operand.Immediate.Value, isWord)
+	case decoder.OperandMemory:
+		segment, offset := s.effectiveAddress(operand.Memory)
+		address := linearAddress(segment, offset)
+		if s.MemTrace != nil {
+			s.MemTrace.record(address, isWord, false)
+		}
+		if isWord {
+			// the offset of the high byte wraps around within the segment as well
+			return uint16(s.Memory[address]) | uint16(s.Memory[linearAddress(segment, offset+1)])<<8
+		}
+		return uint16(s.Memory[address])
+	default:
+		panic(fmt.Errorf("AssertionError: operand type %d can't be read", operand.Type))
+	}
+}
+
+func (s *Simulator) write(operand decoder.Operand, isWord bool, value uint16) {
+	switch operand.Type {
+	case decoder.OperandRegister:
+		s.Registers.Set(operand.Register, value)
+	case decoder.OperandMemory:
+		segment, offset := s.effectiveAddress(operand.Memory)
+		address := linearAddress(segment, offset)
+		if s.MemTrace != nil {
+			s.MemTrace.record(address, isWord, true)
+		}
+		s.Memory[address] = byte(value)
+		if isWord {
+			s.Memory[linearAddress(segment, offset+1)] = byte(value >> 8)
+		}
+	default:
+		panic(fmt.Errorf("AssertionError: operand type %d can't be written", operand.Type))
+	}
+}
+
+// physicalAddress = segment * 16 + effective address
+func (s *Simulator) physicalAddress(address decoder.EffectiveAddress) uint32 {
+	return linearAddress(s.effectiveAddress(address))
+}
+
+// effectiveAddress returns the segment and the offset within it.
+// The offset is 16-bit, so it wraps around within the 64K segment: bx = 0xffff, si = 2 is the offset 1.
+// The addresses based on bp use the stack segment, the rest use the data segment, unless overridden
+func (s *Simulator) effectiveAddress(address decoder.EffectiveAddress) (segment uint16, offset uint16) {
+	if address.Mod == decoder.MemoryModeNoDisplacementFieldEncoding && address.Rm == 0b110 {
+		offset = address.Displacement
+	} else {
+		switch address.Rm {
+		case 0b000:
+			offset = s.Registers.BX + s.Registers.SI
+		case 0b001:
+			offset = s.Registers.BX + s.Registers.DI
+		case 0b010:
+			offset = s.Registers.BP + s.Registers.SI
+		case 0b011:
+			offset = s.Registers.BP + s.Registers.DI
+		case 0b100:
+			offset = s.Registers.SI
+		case 0b101:
+			offset = s.Registers.DI
+		case 0b110:
+			offset = s.Registers.BP
+		case 0b111:
+			offset = s.Registers.BX
+		}
+
+		if address.Mod == decoder.MemoryMode8DisplacementFieldEncoding {
+			offset += uint16(int16(int8(uint8(address.Displacement)))) // the 8-bit displacement is sign-extended
+		} else if address.Mod == decoder.MemoryMode16DisplacementFieldEncoding {
+			offset += address.Displacement
+		}
+	}
+
+	segmentName := ""
+	if address.Segment != "" {
+		segmentName = address.Segment
+	} else if address.Rm == 0b010 || address.Rm == 0b011 || (address.Rm == 0b110 && address.Mod != decoder.MemoryModeNoDisplacementFieldEncoding) {
+		segmentName = "ss"
+	} else {
+		segmentName = "ds"
+	}
+
+	return s.Registers.Get(segmentName), offset
+}
+
+func linearAddress(segment uint16, offset uint16) uint32 {
+	return (uint32(segment)<<4 + uint32(offset)) % MemorySize
+}
+
+// isWordOperation infers the width of the operation from the destination,
+// the size keyword, or the source register
+func isWordOperation(instruction decoder.Instruction) bool {
+	dest := instruction.Operands[0]
+	if dest.Type == decoder.OperandRegister {
+		return !isByteRegister(dest.Register)
+	}
+
+	for _, operand := range instruction.Operands {
+		if operand.Specifier == "byte" {
+			return false
+		}
+		if operand.Specifier == "word" {
+			return true
+		}
+	}
+
+	for _, operand := range instruction.Operands {
+		if operand.Type == decoder.OperandRegister {
+			return !isByteRegister(operand.Register)
+		}
+	}
+
+	return true
+}
+
+func signBit(isWord bool) uint16 {
+	if isWord {
+		return 0x8000
+	}
+
+	return 0x80
+}
+
+func truncate(value uint16, isWord bool) uint16 {
+	if isWord {
+		return value
+	}
+
+	return value & 0x00ff
+}
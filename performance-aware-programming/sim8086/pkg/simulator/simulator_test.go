@@ -0,0 +1,1047 @@
+package simulator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/doichev-kostia/computer-enhance/sim8086/pkg/decoder"
+)
+
+func part1(filename string) string {
+	return path.Join("../../../part-1", filename)
+}
+
+// execute decodes the source and executes the instructions one after another
+func execute(t *testing.T, s *Simulator, source []byte) {
+	t.Helper()
+
+	d := decoder.NewDecoder(source)
+	if _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected decoding error = %v", err)
+	}
+
+	for _, instruction := range d.Instructions() {
+		if err := s.Execute(instruction); err != nil {
+			t.Fatalf("unexpected execution error = %v", err)
+		}
+	}
+}
+
+func TestLahfSahf(t *testing.T) {
+	s := NewSimulator()
+	execute(t, s, []byte{
+		0b10110100, 0b11111111, // mov ah, 255
+		0b10011110,             // sahf
+		0b10110100, 0b00000000, // mov ah, 0
+		0b10011111, // lahf
+	})
+
+	// the undefined bits 3 & 5 are dropped, the bit 1 always reads as 1
+	if ah := s.Registers.Get("ah"); ah != 0b11010111 {
+		t.Errorf("expected ah = %08b, got %08b", 0b11010111, ah)
+	}
+
+	for _, flag := range []Flags{FlagSign, FlagZero, FlagAuxiliary, FlagParity, FlagCarry} {
+		if !s.Registers.Flags.Has(flag) {
+			t.Errorf("expected the flag %016b to be set", flag)
+		}
+	}
+	if s.Registers.Flags.Has(FlagOverflow) {
+		t.Errorf("expected SAHF to leave OF intact")
+	}
+}
+
+func TestAddSubCmpFlags(t *testing.T) {
+	s := NewSimulator()
+	execute(t, s, []byte{
+		0b10111011, 0b00000001, 0b00000000, // mov bx, 1
+		0b10000011, 0b11101011, 0b00000010, // sub bx, 2
+	})
+
+	if s.Registers.BX != 0xffff {
+		t.Errorf("expected bx = 0xffff, got 0x%04x", s.Registers.BX)
+	}
+	if !s.Registers.Flags.Has(FlagSign) || s.Registers.Flags.Has(FlagZero) {
+		t.Errorf("expected SF to be set and ZF to be clear, got flags %016b", s.Registers.Flags)
+	}
+
+	execute(t, s, []byte{
+		0b10000011, 0b11111011, 0b11111111, // cmp bx, -1
+	})
+
+	if s.Registers.BX != 0xffff {
+		t.Errorf("expected cmp to leave bx intact, got 0x%04x", s.Registers.BX)
+	}
+	if s.Registers.Flags.Has(FlagSign) || !s.Registers.Flags.Has(FlagZero) {
+		t.Errorf("expected ZF to be set and SF to be clear, got flags %016b", s.Registers.Flags)
+	}
+}
+
+func TestArithmeticFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   []byte
+		register string
+		result   uint16
+		flags    string
+	}{
+		{
+			name: "unsigned overflow",
+			source: []byte{
+				0b10110000, 0b11111111, // mov al, 255
+				0b00000100, 0b00000001, // add al, 1
+			},
+			register: "al",
+			result:   0x00,
+			flags:    "CPAZ",
+		},
+		{
+			name: "signed overflow",
+			source: []byte{
+				0b10110000, 0b01111111, // mov al, 127
+				0b00000100, 0b00000001, // add al, 1
+			},
+			register: "al",
+			result:   0x80,
+			flags:    "ASO",
+		},
+		{
+			name: "borrow",
+			source: []byte{
+				0b10111000, 0b00000001, 0b00000000, // mov ax, 1
+				0b00101101, 0b00000010, 0b00000000, // sub ax, 2
+			},
+			register: "ax",
+			result:   0xffff,
+			flags:    "CPAS",
+		},
+		{
+			name: "signed overflow on subtraction",
+			source: []byte{
+				0b10111000, 0b00000000, 0b10000000, // mov ax, 32768
+				0b00101101, 0b00000001, 0b00000000, // sub ax, 1
+			},
+			register: "ax",
+			result:   0x7fff,
+			flags:    "PAO",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSimulator()
+			execute(t, s, test.source)
+
+			if value := s.Registers.Get(test.register); value != test.result {
+				t.Errorf("expected %s = 0x%04x, got 0x%04x", test.register, test.result, value)
+			}
+			if flags := s.Registers.Flags.String(); flags != test.flags {
+				t.Errorf("expected the flags %q, got %q", test.flags, flags)
+			}
+		})
+	}
+}
+
+// TestMultiWordArithmetic adds and subtracts the 32-bit numbers held in dx:ax and cx:bx
+func TestMultiWordArithmetic(t *testing.T) {
+	s := NewSimulator()
+	execute(t, s, []byte{
+		0b10111000, 0b11111111, 0b11111111, // mov ax, 65535
+		0b10111010, 0b00000000, 0b10000000, // mov dx, 32768
+		0b10111011, 0b00000001, 0b00000000, // mov bx, 1
+		0b10111001, 0b00000000, 0b10000000, // mov cx, 32768
+		0b00000001, 0b11011000, // add ax, bx
+		0b00010001, 0b11001010, // adc dx, cx
+	})
+
+	// 0x8000ffff + 0x80000001 = 0x100010000
+	if s.Registers.DX != 0x0001 || s.Registers.AX != 0x0000 {
+		t.Errorf("expected dx:ax = 0x0001:0x0000, got 0x%04x:0x%04x", s.Registers.DX, s.Registers.AX)
+	}
+	if !s.Registers.Flags.Has(FlagCarry) {
+		t.Errorf("expected CF to be set by the carry out of the high word")
+	}
+
+	execute(t, s, []byte{
+		0b00101001, 0b11011000, // sub ax, bx
+		0b00011001, 0b11001010, // sbb dx, cx
+	})
+
+	// 0x00010000 - 0x80000001 = 0x8000ffff with a borrow
+	if s.Registers.DX != 0x8000 || s.Registers.AX != 0xffff {
+		t.Errorf("expected dx:ax = 0x8000:0xffff, got 0x%04x:0x%04x", s.Registers.DX, s.Registers.AX)
+	}
+	if !s.Registers.Flags.Has(FlagCarry) {
+		t.Errorf("expected CF to be set by the borrow into the high word")
+	}
+}
+
+func TestLogic(t *testing.T) {
+	s := NewSimulator()
+	execute(t, s, []byte{
+		0b10111000, 0b11110000, 0b00001111, // mov ax, 4080
+		0b10111011, 0b11111111, 0b00000000, // mov bx, 255
+		0b10111001, 0b00001111, 0b00000000, // mov cx, 15
+		0b00000001, 0b11011001, // add cx, bx ; sets AF
+		0b00100001, 0b11000011, // and bx, ax
+	})
+
+	if s.Registers.BX != 0x00f0 {
+		t.Errorf("expected bx = 0x00f0, got 0x%04x", s.Registers.BX)
+	}
+	if flags := s.Registers.Flags.String(); flags != "PA" {
+		t.Errorf("expected the flags %q, got %q", "PA", flags)
+	}
+
+	execute(t, s, []byte{
+		0b00001001, 0b11000011, // or bx, ax
+		0b10000101, 0b11000011, // test bx, ax
+		0b00110001, 0b11011011, // xor bx, bx
+	})
+
+	if s.Registers.BX != 0 {
+		t.Errorf("expected bx = 0x0000, got 0x%04x", s.Registers.BX)
+	}
+	if flags := s.Registers.Flags.String(); flags != "PAZ" {
+		t.Errorf("expected the flags %q, got %q", "PAZ", flags)
+	}
+
+	execute(t, s, []byte{
+		0b11110111, 0b11010011, // not bx
+		0b10101001, 0b00000000, 0b10000000, // test ax, 32768
+	})
+
+	if s.Registers.BX != 0xffff {
+		t.Errorf("expected bx = 0xffff, got 0x%04x", s.Registers.BX)
+	}
+	if s.Registers.AX != 0x0ff0 {
+		t.Errorf("expected test to leave ax intact, got 0x%04x", s.Registers.AX)
+	}
+	if flags := s.Registers.Flags.String(); flags != "PAZ" {
+		t.Errorf("expected the flags %q, got %q", "PAZ", flags)
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := NewSimulator()
+	if err := s.Load([]byte{
+		0b10111001, 0b00000011, 0b00000000, // mov cx, 3
+		0b10111011, 0b00000000, 0b00000000, // mov bx, 0
+		// label__6:
+		0b10000011, 0b11000011, 0b00001010, // add bx, 10
+		0b11100010, 0b11111011, // loop label__6
+		0b10000011, 0b11111011, 0b00011110, // cmp bx, 30
+		0b01110100, 0b00000001, // jz label__17
+		0b11110100, // hlt
+		// label__17:
+		0b10111000, 0b00000001, 0b00000000, // mov ax, 1
+		0b11110100,                         // hlt
+		0b10111000, 0b00000010, 0b00000000, // mov ax, 2
+	}, 0); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	if err := s.Run(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	if s.Registers.BX != 30 || s.Registers.AX != 1 {
+		t.Errorf("expected bx = 30 and ax = 1, got bx = %d and ax = %d", s.Registers.BX, s.Registers.AX)
+	}
+	if s.Registers.IP != 21 {
+		t.Errorf("expected ip = 21 after hlt, got %d", s.Registers.IP)
+	}
+	if !s.Halted {
+		t.Errorf("expected the simulator to be halted")
+	}
+
+	// the halted simulator stays at hlt until it's resumed
+	if err := s.Run(); err != nil || s.Registers.IP != 21 {
+		t.Errorf("expected Run to do nothing, got ip = %d, err = %v", s.Registers.IP, err)
+	}
+	s.Halted = false
+	if err := s.Run(); err != nil || s.Registers.AX != 2 {
+		t.Errorf("expected the resumed run to execute mov ax, 2, got ax = %d, err = %v", s.Registers.AX, err)
+	}
+}
+
+func TestRunPastTheImage(t *testing.T) {
+	s := NewSimulator()
+	if err := s.Load([]byte{
+		0b10111000, 0b00000001, 0b00000000, // mov ax, 1
+		0b11101011, 0b00000001, // jmp short label__6
+		0b11110100, // hlt
+		// label__6:
+		0b10000011, 0b11000000, 0b00000010, // add ax, 2
+	}, 0); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	if err := s.Run(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	if s.Registers.AX != 3 || s.Registers.IP != 9 {
+		t.Errorf("expected ax = 3 and ip = 9, got ax = %d and ip = %d", s.Registers.AX, s.Registers.IP)
+	}
+}
+
+func TestRunUndecodable(t *testing.T) {
+	s := NewSimulator()
+	if err := s.Load([]byte{
+		0b10111000, 0b00000001, 0b00000000, // mov ax, 1
+		0b01100011, // undefined on the 8086
+	}, 0); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	err := s.Run()
+	if !errors.Is(err, decoder.ErrUnknownOperation) {
+		t.Fatalf("expected decoder.ErrUnknownOperation, got %v", err)
+	}
+	if s.Registers.AX != 1 || s.Registers.IP != 3 {
+		t.Errorf("expected ax = 1 and ip = 3, got ax = %d and ip = %d", s.Registers.AX, s.Registers.IP)
+	}
+
+	var executionError *ExecutionError
+	if !errors.As(err, &executionError) {
+		t.Fatalf("expected *ExecutionError, got %v", err)
+	}
+	if executionError.CS != 0 || executionError.IP != 3 || executionError.Instruction.Mnemonic != "" {
+		t.Errorf("expected no instruction at 0000:0003, got '%s' at %04x:%04x", executionError.Instruction, executionError.CS, executionError.IP)
+	}
+	if expected := "at 0000:0003: at offset 0x3: unknown operation 01100011"; err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestLoadAddress(t *testing.T) {
+	program := []byte{
+		0b10111000, 0b00000001, 0b00000000, // mov ax, 1
+		0b11101001, 0b00000001, 0b00000000, // jmp 7
+		0b11110100,                         // hlt
+		0b10000011, 0b11000000, 0b00000010, // add ax, 2
+		0b11110100, // hlt
+	}
+
+	tests := []struct {
+		name string
+		at   uint32
+		cs   uint16
+		ip   uint16
+	}{
+		{name: "zero", at: 0, cs: 0, ip: 11},
+		{name: "COM file", at: 0x100, cs: 0, ip: 0x100 + 11},
+		{name: "segmented", at: 0x12340, cs: 0x1000, ip: 0x2340 + 11},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSimulator()
+			if err := s.Load(program, test.at); err != nil {
+				t.Fatalf("unexpected error = %v", err)
+			}
+
+			if err := s.Run(); err != nil {
+				t.Fatalf("unexpected error = %v", err)
+			}
+
+			if s.Registers.AX != 3 {
+				t.Errorf("expected the jump to be taken, got ax = %d", s.Registers.AX)
+			}
+			if s.Registers.CS != test.cs || s.Registers.IP != test.ip {
+				t.Errorf("expected cs:ip = %04x:%04x, got %04x:%04x", test.cs, test.ip, s.Registers.CS, s.Registers.IP)
+			}
+		})
+	}
+}
+
+func TestLoadOutOfMemory(t *testing.T) {
+	tests := []struct {
+		name  string
+		image []byte
+		at    uint32
+		err   string
+	}{
+		{name: "at the end", image: []byte{0b11110100}, at: MemorySize, err: "doesn't fit into the memory"},
+		{name: "beyond the end", image: []byte{0b11110100}, at: MemorySize + 0x100, err: "doesn't fit into the memory"},
+		{name: "overrun", image: []byte{0b10010000, 0b11110100}, at: MemorySize - 1, err: "doesn't fit into the memory"},
+		// IP would wrap to 0000:0000 and the program would never reach its end
+		{name: "segment overrun", image: bytes.Repeat([]byte{0b01000000}, 32), at: 0xfff0, err: "crosses the end of the code segment at 0x10000"}, // inc ax
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSimulator()
+			s.Registers.IP = 0x1234
+			err := s.Load(test.image, test.at)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected the error to contain %q, got %v", test.err, err)
+			}
+			if s.Registers.IP != 0x1234 || s.Memory[MemorySize-1] != 0 || s.Memory[0xfff0] != 0 {
+				t.Errorf("expected the simulator to stay unchanged")
+			}
+		})
+	}
+
+	// the last byte of the memory is still usable
+	s := NewSimulator()
+	if err := s.Load([]byte{0b11110100}, MemorySize-1); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if s.Memory[MemorySize-1] != 0b11110100 {
+		t.Errorf("expected the image at the end of the memory")
+	}
+}
+
+// TestDump compares the final state with the output of the computer-enhance reference simulator
+func TestDump(t *testing.T) {
+	files := []struct {
+		filename string
+		clocks   string // the reference output has no clocks
+	}{
+		{part1("listing_0048_ip_register"), "  clocks: 17\n    8088: 17\n"},
+	}
+
+	for _, file := range files {
+		filename := file.filename
+		t.Run(path.Base(filename), func(t *testing.T) {
+			program, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", filename, err)
+			}
+
+			expected, err := os.ReadFile(filename + ".txt")
+			if err != nil {
+				t.Fatalf("failed to read %s.txt: %v", filename, err)
+			}
+
+			s := NewSimulator()
+			s.CPU8088 = true
+			if err := s.Load(program, 0); err != nil {
+				t.Fatalf("unexpected error = %v", err)
+			}
+			if err := s.Run(); err != nil {
+				t.Fatalf("unexpected error = %v", err)
+			}
+
+			// the expected output ends with the final registers
+			idx := strings.Index(string(expected), "Final registers:")
+			if idx == -1 {
+				t.Fatalf("expected %s.txt to contain the final registers", filename)
+			}
+
+			final := strings.TrimRight(string(expected[idx:]), "\n") + "\n" + file.clocks
+			if dump := s.Dump(); dump != final {
+				t.Errorf("expected %q, got %q", final, dump)
+			}
+
+			s.CPU8088 = false
+			if dump := s.Dump(); strings.Contains(dump, "8088") {
+				t.Errorf("expected the 8088 clocks only with CPU8088, got %q", dump)
+			}
+		})
+	}
+}
+
+func TestCycles(t *testing.T) {
+	program := []byte{
+		0b10111011, 0b11101001, 0b00000011, // mov bx, 1001 ; 4
+		0b10111101, 0b11101000, 0b00000011, // mov bp, 1000 ; 4
+		0b10001001, 0b00011111, // mov [bx], bx ; 9 + 5 (ea) + 4 (odd address)
+		0b00000011, 0b01000110, 0b00000000, // add ax, [bp + 0] ; 9 + 9 (ea)
+		0b00000001, 0b00000111, // add [bx], ax ; 16 + 5 (ea) + 2 * 4 (odd address)
+		0b00100110, 0b10001010, 0b00000111, // mov al, es:[bx] ; 8 + 5 (ea) + 2 (segment)
+		0b10111001, 0b00000010, 0b00000000, // mov cx, 2 ; 4
+		// label__22:
+		0b11100010, 0b11111110, // loop label__22 ; 17 (taken) + 5
+		0b11110100, // hlt ; 2
+	}
+
+	s := NewSimulator()
+	s.CPU8088 = true
+	if err := s.Load(program, 0); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	if expected := 4 + 4 + 18 + 18 + 29 + 15 + 4 + 22 + 2; s.Cycles != expected {
+		t.Errorf("expected %d clocks on the 8086, got %d", expected, s.Cycles)
+	}
+	// the 8088 pays for every word transfer, odd address or not
+	if expected := 4 + 4 + 18 + 22 + 29 + 15 + 4 + 22 + 2; s.Cycles8088 != expected {
+		t.Errorf("expected %d clocks on the 8088, got %d", expected, s.Cycles8088)
+	}
+}
+
+func TestSignExtension(t *testing.T) {
+	s := NewSimulator()
+	execute(t, s, []byte{
+		0b10110000, 0b11111111, // mov al, 255
+		0b10011000, // cbw
+	})
+
+	if s.Registers.AX != 0xffff {
+		t.Errorf("expected ax = 0xffff, got 0x%04x", s.Registers.AX)
+	}
+
+	execute(t, s, []byte{
+		0b10011001,                         // cwd
+		0b10111000, 0b01111111, 0b00000000, // mov ax, 127
+		0b10011000, // cbw
+	})
+
+	if s.Registers.DX != 0xffff {
+		t.Errorf("expected dx = 0xffff, got 0x%04x", s.Registers.DX)
+	}
+	if s.Registers.AX != 0x007f {
+		t.Errorf("expected ax = 0x007f, got 0x%04x", s.Registers.AX)
+	}
+
+	execute(t, s, []byte{
+		0b10011001, // cwd
+	})
+
+	if s.Registers.DX != 0 {
+		t.Errorf("expected dx = 0x0000, got 0x%04x", s.Registers.DX)
+	}
+}
+
+func TestMultiplyDivide(t *testing.T) {
+	tests := []struct {
+		name   string
+		source []byte
+		ax     uint16
+		dx     uint16
+		flags  string
+	}{
+		{
+			name: "byte mul",
+			source: []byte{
+				0b10110000, 0b11001000, // mov al, 200
+				0b10110011, 0b00000011, // mov bl, 3
+				0b11110110, 0b11100011, // mul bl
+			},
+			ax:    600,
+			flags: "CO",
+		},
+		{
+			name: "word mul",
+			source: []byte{
+				0b10111000, 0b00000000, 0b00000001, // mov ax, 256
+				0b10111011, 0b00000000, 0b00000001, // mov bx, 256
+				0b11110111, 0b11100011, // mul bx
+			},
+			ax:    0,
+			dx:    1,
+			flags: "CO",
+		},
+		{
+			name: "byte imul that fits",
+			source: []byte{
+				0b10110000, 0b11111110, // mov al, -2
+				0b10110011, 0b00000011, // mov bl, 3
+				0b11110110, 0b11101011, // imul bl
+			},
+			ax:    0xfffa,
+			flags: "",
+		},
+		{
+			name: "word imul",
+			source: []byte{
+				0b10111000, 0b00000000, 0b10000000, // mov ax, -32768
+				0b10111011, 0b11111111, 0b11111111, // mov bx, -1
+				0b11110111, 0b11101011, // imul bx
+			},
+			ax:    0x8000,
+			dx:    0x0000,
+			flags: "CO",
+		},
+		{
+			name: "byte div",
+			source: []byte{
+				0b10111000, 0b11101011, 0b00000011, // mov ax, 1003
+				0b10110011, 0b00001010, // mov bl, 10
+				0b11110110, 0b11110011, // div bl
+			},
+			ax: 0x0364, // remainder 3, quotient 100
+		},
+		{
+			name: "word idiv",
+			source: []byte{
+				0b10111000, 0b11111001, 0b11111111, // mov ax, -7
+				0b10011001,                         // cwd
+				0b10111011, 0b00000010, 0b00000000, // mov bx, 2
+				0b11110111, 0b11111011, // idiv bx
+			},
+			ax: 0xfffd, // -3
+			dx: 0xffff, // -1
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSimulator()
+			execute(t, s, test.source)
+
+			if s.Registers.AX != test.ax || s.Registers.DX != test.dx {
+				t.Errorf("expected dx:ax = 0x%04x:0x%04x, got 0x%04x:0x%04x", test.dx, test.ax, s.Registers.DX, s.Registers.AX)
+			}
+			if flags := s.Registers.Flags.String(); flags != test.flags {
+				t.Errorf("expected the flags %q, got %q", test.flags, flags)
+			}
+		})
+	}
+}
+
+func TestDivideError(t *testing.T) {
+	sources := map[string][]byte{
+		"divide by zero": {
+			0b10111000, 0b00000001, 0b00000000, // mov ax, 1
+			0b11110110, 0b11110011, // div bl
+		},
+		"quotient overflow": {
+			0b10111000, 0b00000000, 0b00000001, // mov ax, 256
+			0b10110011, 0b00000001, // mov bl, 1
+			0b11110110, 0b11110011, // div bl
+		},
+	}
+
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			s := NewSimulator()
+			if err := s.Load(source, 0); err != nil {
+				t.Fatalf("unexpected error = %v", err)
+			}
+
+			if err := s.Run(); !errors.Is(err, ErrDivideError) {
+				t.Errorf("expected %v, got %v", ErrDivideError, err)
+			}
+		})
+	}
+}
+
+func TestInterruptHandler(t *testing.T) {
+	s := NewSimulator()
+	if err := s.Load([]byte{
+		0b10110100, 0b00000010, // mov ah, 2
+		0b10110010, 0b01101000, // mov dl, 104 ; 'h'
+		0b11001101, 0b00100001, // int 33 ; 21h
+		0b10110010, 0b01101001, // mov dl, 105 ; 'i'
+		0b11001101, 0b00100001, // int 33 ; 21h
+		0b10110100, 0b01001100, // mov ah, 76 ; 4ch - exit
+		0b11001101, 0b00100001, // int 33 ; 21h
+		0b10111000, 0b00000001, 0b00000000, // mov ax, 1
+	}, 0x100); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	output := ""
+	errExit := errors.New("exit")
+	s.InterruptHandler = func(vector byte, registers *Registers) error {
+		if vector != 0x21 {
+			return fmt.Errorf("unexpected interrupt %d", vector)
+		}
+
+		switch registers.Get("ah") {
+		case 0x02:
+			output += string(rune(registers.Get("dl")))
+			return nil
+		case 0x4c:
+			return errExit
+		default:
+			return fmt.Errorf("unexpected function %d", registers.Get("ah"))
+		}
+	}
+
+	if err := s.Run(); !errors.Is(err, errExit) {
+		t.Errorf("expected the handler error to abort the run, got %v", err)
+	}
+	if output != "hi" {
+		t.Errorf("expected %q, got %q", "hi", output)
+	}
+	if s.Registers.AX == 1 {
+		t.Errorf("expected the run to stop at the exit")
+	}
+}
+
+func TestDivideErrorInterrupt(t *testing.T) {
+	s := NewSimulator()
+	if err := s.Load([]byte{
+		0b10111000, 0b00000001, 0b00000000, // mov ax, 1
+		0b11110110, 0b11110011, // div bl
+	}, 0); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	vectors := make([]byte, 0)
+	s.InterruptHandler = func(vector byte, registers *Registers) error {
+		vectors = append(vectors, vector)
+		return nil
+	}
+
+	if err := s.Run(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if len(vectors) != 1 || vectors[0] != 0 {
+		t.Errorf("expected the interrupt 0, got %v", vectors)
+	}
+}
+
+func TestNegativeDisplacement(t *testing.T) {
+	s := NewSimulator()
+	s.Memory[0x0fff] = 0x42
+	s.Memory[0xffff] = 0x24
+
+	execute(t, s, []byte{
+		0b10111011, 0b00000000, 0b00010000, // mov bx, 4096
+		0b10001010, 0b01000111, 0b11111111, // mov al, [bx - 1]
+		0b10111011, 0b00000000, 0b00000000, // mov bx, 0
+		0b10001010, 0b01100111, 0b11111111, // mov ah, [bx - 1]
+	})
+
+	if al := s.Registers.Get("al"); al != 0x42 {
+		t.Errorf("expected al = 0x42, got 0x%02x", al)
+	}
+	// the offset wraps around within the segment
+	if ah := s.Registers.Get("ah"); ah != 0x24 {
+		t.Errorf("expected ah = 0x24, got 0x%02x", ah)
+	}
+
+	d := decoder.NewDecoder([]byte{0b10001010, 0b01000111, 0b11111111})
+	instruction, _, err := d.Next()
+	if err != nil {
+		t.Fatalf("unexpected decoding error = %v", err)
+	}
+	// 8 + EA (base + displacement = 9)
+	if clocks, _, err := EstimateCycles(instruction, false); err != nil || clocks != 17 {
+		t.Errorf("expected 17 clocks, got %d (err = %v)", clocks, err)
+	}
+}
+
+func TestExecutionError(t *testing.T) {
+	s := NewSimulator()
+	if err := s.Load([]byte{
+		0b10111001, 0b00000001, 0b00000000, // mov cx, 1
+		0b00100111, // daa
+	}, 0x100); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	err := s.Run()
+	var executionError *ExecutionError
+	if !errors.As(err, &executionError) {
+		t.Fatalf("expected *ExecutionError, got %v", err)
+	}
+
+	if executionError.IP != 0x103 || executionError.Instruction.Mnemonic != "daa" {
+		t.Errorf("expected daa at 0x103, got '%s' at 0x%x", executionError.Instruction, executionError.IP)
+	}
+	if message := err.Error(); !strings.HasPrefix(message, "at 0000:0103 'daa'") {
+		t.Errorf("unexpected message %q", message)
+	}
+}
+
+func TestNeg(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   []byte
+		register string
+		result   uint16
+		flags    string
+	}{
+		{
+			name: "zero",
+			source: []byte{
+				0b10110000, 0b00000000, // mov al, 0
+				0b11110110, 0b11011000, // neg al
+			},
+			register: "al",
+			result:   0x00,
+			flags:    "PZ",
+		},
+		{
+			name: "one",
+			source: []byte{
+				0b10110000, 0b00000001, // mov al, 1
+				0b11110110, 0b11011000, // neg al
+			},
+			register: "al",
+			result:   0xff,
+			flags:    "CPAS",
+		},
+		{
+			name: "minimal signed byte",
+			source: []byte{
+				0b10110000, 0b10000000, // mov al, 128
+				0b11110110, 0b11011000, // neg al
+			},
+			register: "al",
+			result:   0x80,
+			flags:    "CSO",
+		},
+		{
+			name: "minimal signed word",
+			source: []byte{
+				0b10111000, 0b00000000, 0b10000000, // mov ax, 32768
+				0b11110111, 0b11011000, // neg ax
+			},
+			register: "ax",
+			result:   0x8000,
+			flags:    "CPSO",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSimulator()
+			execute(t, s, test.source)
+
+			if value := s.Registers.Get(test.register); value != test.result {
+				t.Errorf("expected %s = 0x%04x, got 0x%04x", test.register, test.result, value)
+			}
+			if flags := s.Registers.Flags.String(); flags != test.flags {
+				t.Errorf("expected the flags %q, got %q", test.flags, flags)
+			}
+		})
+	}
+}
+
+func TestIncDec(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   []byte
+		carry    bool
+		register string
+		result   uint16
+		flags    string
+	}{
+		{
+			name: "inc keeps the carry set",
+			source: []byte{
+				0b10111000, 0b11111111, 0b01111111, // mov ax, 32767
+				0b01000000, // inc ax
+			},
+			carry:    true,
+			register: "ax",
+			result:   0x8000,
+			flags:    "CPASO",
+		},
+		{
+			name: "inc doesn't carry out",
+			source: []byte{
+				0b10111000, 0b11111111, 0b11111111, // mov ax, 65535
+				0b01000000, // inc ax
+			},
+			register: "ax",
+			result:   0x0000,
+			flags:    "PAZ",
+		},
+		{
+			name: "dec doesn't borrow",
+			source: []byte{
+				0b10110001, 0b00000000, // mov cl, 0
+				0b11111110, 0b11001001, // dec cl
+			},
+			register: "cl",
+			result:   0xff,
+			flags:    "PAS",
+		},
+		{
+			name: "dec memory",
+			source: []byte{
+				0b11000111, 0b00000110, 0b11101000, 0b00000011, 0b00000001, 0b00000000, // mov word [1000], 1
+				0b11111111, 0b00001110, 0b11101000, 0b00000011, // dec word [1000]
+				0b10001011, 0b00011110, 0b11101000, 0b00000011, // mov bx, [1000]
+			},
+			carry:    true,
+			register: "bx",
+			result:   0x0000,
+			flags:    "CPZ",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSimulator()
+			s.Registers.Flags.Set(FlagCarry, test.carry)
+			execute(t, s, test.source)
+
+			if value := s.Registers.Get(test.register); value != test.result {
+				t.Errorf("expected %s = 0x%04x, got 0x%04x", test.register, test.result, value)
+			}
+			if flags := s.Registers.Flags.String(); flags != test.flags {
+				t.Errorf("expected the flags %q, got %q", test.flags, flags)
+			}
+		})
+	}
+}
+
+func TestMemTrace(t *testing.T) {
+	s := NewSimulator()
+	s.MemTrace = &MemoryTrace{}
+	execute(t, s, []byte{
+		0b10111011, 0b11101000, 0b00000011, // mov bx, 1000
+		0b10001001, 0b00011111, // mov [bx], bx
+		0b10000000, 0b01000111, 0b00000001, 0b00000001, // add [bx + 1], byte 1
+	})
+
+	expected := "address,size,access\n" +
+		"0x003e8,2,write\n" +
+		"0x003e9,1,read\n" +
+		"0x003e9,1,write\n"
+
+	var builder strings.Builder
+	if err := s.MemTrace.WriteCSV(&builder); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if builder.String() != expected {
+		t.Errorf("expected %q, got %q", expected, builder.String())
+	}
+}
+
+func TestRepString(t *testing.T) {
+	program := []byte{
+		0b10111110, 0b01100100, 0b00000000, // mov si, 100 ; 4
+		0b10111111, 0b11001000, 0b00000000, // mov di, 200 ; 4
+		0b10111001, 0b00000101, 0b00000000, // mov cx, 5 ; 4
+		0b11110011, 0b10100100, // rep movsb ; 9 + 17 per repetition
+		0b11110100, // hlt ; 2
+	}
+
+	s := NewSimulator()
+	copy(s.Memory[100:], "hello")
+	if err := s.Load(program, 0); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	if copied := string(s.Memory[200:205]); copied != "hello" {
+		t.Errorf("expected the buffer to be copied, got %q", copied)
+	}
+	if s.Registers.CX != 0 || s.Registers.SI != 105 || s.Registers.DI != 205 {
+		t.Errorf("expected cx = 0, si = 105, di = 205, got %d, %d, %d", s.Registers.CX, s.Registers.SI, s.Registers.DI)
+	}
+	if expected := 4 + 4 + 4 + 9 + 17*5 + 2; s.Cycles != expected {
+		t.Errorf("expected %d clocks, got %d", expected, s.Cycles)
+	}
+
+	// repz stops at the first mismatch
+	s = NewSimulator()
+	copy(s.Memory[100:], "hello")
+	copy(s.Memory[200:], "help!")
+	execute(t, s, []byte{
+		0b10111110, 0b01100100, 0b00000000, // mov si, 100
+		0b10111111, 0b11001000, 0b00000000, // mov di, 200
+		0b10111001, 0b00000101, 0b00000000, // mov cx, 5
+		0b11110011, 0b10100110, // repz cmpsb
+	})
+	if s.Registers.CX != 1 || s.Registers.SI != 104 || s.Registers.Flags.Has(FlagZero) {
+		t.Errorf("expected to stop after 4 comparisons, got cx = %d, si = %d, flags = %s", s.Registers.CX, s.Registers.SI, s.Registers.Flags)
+	}
+
+	s = NewSimulator()
+	execute(t, s, []byte{
+		0b10111000, 0b00110100, 0b00010010, // mov ax, 0x1234
+		0b10111111, 0b00101100, 0b00000001, // mov di, 300
+		0b10111001, 0b00000010, 0b00000000, // mov cx, 2
+		0b11110011, 0b10101011, // rep stosw
+	})
+	if filled := s.Memory[300:304]; !reflect.DeepEqual(filled, []byte{0x34, 0x12, 0x34, 0x12}) {
+		t.Errorf("expected the words to be stored, got % x", filled)
+	}
+}
+
+func TestDirectionFlag(t *testing.T) {
+	s := NewSimulator()
+	copy(s.Memory[100:], "abc")
+	execute(t, s, []byte{
+		0b10111110, 0b01100110, 0b00000000, // mov si, 102
+		0b10111111, 0b11001010, 0b00000000, // mov di, 202
+		0b11111101, // std
+		0b10100100, // movsb
+	})
+
+	if !s.Registers.Flags.Has(FlagDirection) {
+		t.Errorf("expected DF to be set")
+	}
+	if s.Registers.SI != 101 || s.Registers.DI != 201 || s.Memory[202] != 'c' {
+		t.Errorf("expected the pointers to move backward, got si = %d, di = %d", s.Registers.SI, s.Registers.DI)
+	}
+
+	// the rest of the buffer is copied from the end
+	execute(t, s, []byte{
+		0b10111001, 0b00000010, 0b00000000, // mov cx, 2
+		0b11110011, 0b10100100, // rep movsb
+		0b11111100, // cld
+	})
+
+	if copied := string(s.Memory[200:203]); copied != "abc" {
+		t.Errorf("expected the buffer to be copied, got %q", copied)
+	}
+	if s.Registers.SI != 99 || s.Registers.DI != 199 {
+		t.Errorf("expected si = 99, di = 199, got %d, %d", s.Registers.SI, s.Registers.DI)
+	}
+	if s.Registers.Flags.Has(FlagDirection) {
+		t.Errorf("expected DF to be cleared")
+	}
+}
+
+func TestSegmentWrap(t *testing.T) {
+	s := NewSimulator()
+	execute(t, s, []byte{
+		0b10111000, 0b00000000, 0b00010000, // mov ax, 0x1000
+		0b10001110, 0b11011000, // mov ds, ax
+		0b10111011, 0b11111111, 0b11111111, // mov bx, 0xffff
+		0b10111110, 0b00000010, 0b00000000, // mov si, 2
+		0b11000110, 0b00000000, 0b00000111, // mov byte [bx + si], 7
+		0b11000111, 0b00000111, 0b00110100, 0b00010010, // mov word [bx], 0x1234
+	})
+
+	// bx + si = 0x10001 is the offset 1 of the segment, not the first byte of the next one
+	if s.Memory[0x10001] != 7 || s.Memory[0x20001] != 0 {
+		t.Errorf("expected the offset to wrap around to 1000:0001")
+	}
+	// the high byte of the word at the offset 0xffff is at the offset 0
+	if s.Memory[0x1ffff] != 0x34 || s.Memory[0x10000] != 0x12 || s.Memory[0x20000] != 0 {
+		t.Errorf("expected the word to be split between 1000:ffff and 1000:0000")
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	program := []byte{
+		0b10111001, 0b00000011, 0b00000000, // mov cx, 3
+		// label__3:
+		0b11100010, 0b11111110, // loop label__3
+		0b11110100, // hlt
+	}
+
+	s := NewSimulator()
+	s.MaxSteps = 5
+	if err := s.Load(program, 0); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatalf("expected the program to fit into the budget, got %v", err)
+	}
+
+	// an infinite loop
+	s = NewSimulator()
+	s.MaxSteps = 100
+	if err := s.Load([]byte{0b11101011, 0b11111110}, 0); err != nil { // jmp $
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if err := s.Run(); !errors.Is(err, ErrStepBudgetExceeded) {
+		t.Errorf("expected ErrStepBudgetExceeded, got %v", err)
+	}
+}